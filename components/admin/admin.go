@@ -0,0 +1,264 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/joselee214/j7f/components/config"
+	"github.com/joselee214/j7f/components/grace"
+	"github.com/joselee214/j7f/components/health"
+	"github.com/joselee214/j7f/components/service_register"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	rpprof "runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LISTENER_NAME is the name of the systemd socket adopted, FileDescriptorName=admin
+const LISTENER_NAME = "admin"
+
+const DEFAULT_SHUTDOWN_TIMEOUT = 5 * time.Second
+
+// Servicer is a server listing its services, e.g. a GrpcServer or an HttpServer
+type Servicer interface {
+	GetServicesInfo() map[string]service_register.ServerInfo
+}
+
+// DBStatser is a db pool, e.g. a dao.Node
+type DBStatser interface {
+	Stats() map[string]sql.DBStats
+}
+
+// Server is the admin listener, apart from the business ports. It serves
+//
+//	/debug/pprof/       pprof
+//	/debug/goroutines   the stacks of all the goroutines
+//	/admin/build        BuildInfo
+//	/admin/config       Settings, the secrets redacted
+//	/admin/services     the routes and the grpc services of the servers added
+//	/admin/db           the sql.DBStats of the dbs added
+//	/admin/log/level    GET the level of the log, POST level=debug to change it
+//	/health/live        health.Default liveness
+//	/health/ready       health.Default readiness
+//
+// It is a grace listener, add it to the grace Manager of the servers.
+// Anyone reaching it can profile the process, read the config and change the log level:
+// listen on a loopback or private address, and set Token on any other.
+type Server struct {
+	addr *net.TCPAddr
+	lis  *net.TCPListener
+	mux  *http.ServeMux
+	s    *http.Server
+
+	// BuildInfo is served as json, e.g. application.GetBuildInfo(name)
+	BuildInfo interface{}
+	// Settings are the effective config, e.g. Configer.AllSettings
+	Settings func() map[string]interface{}
+	// Level is the level changed by /admin/log/level, e.g. &Logger.Level
+	Level *zap.AtomicLevel
+	// Token is required as "Authorization: Bearer <token>" by all the paths but the health ones
+	Token string
+
+	l        sync.RWMutex
+	services map[string]Servicer
+	dbs      map[string]DBStatser
+}
+
+func NewServer(addr *net.TCPAddr) (*Server, error) {
+	var err error
+	a := &Server{
+		addr:     addr,
+		mux:      http.NewServeMux(),
+		services: make(map[string]Servicer),
+		dbs:      make(map[string]DBStatser),
+	}
+	a.lis, err = grace.ListenTCPNamed(LISTENER_NAME, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	a.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	a.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	a.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	a.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	a.mux.HandleFunc("/debug/goroutines", a.goroutines)
+	a.mux.HandleFunc("/admin/build", a.build)
+	a.mux.HandleFunc("/admin/config", a.config)
+	a.mux.HandleFunc("/admin/services", a.servicesInfo)
+	a.mux.HandleFunc("/admin/db", a.dbStats)
+	a.mux.HandleFunc("/admin/log/level", a.level)
+	a.mux.Handle(health.LIVENESS_PATH, health.LivenessHandler(health.Default))
+	a.mux.Handle(health.READINESS_PATH, health.ReadinessHandler(health.Default))
+
+	a.s = &http.Server{Handler: http.HandlerFunc(a.serve)}
+	return a, nil
+}
+
+func (a *Server) serve(w http.ResponseWriter, r *http.Request) {
+	//探针不带token
+	if a.Token != "" && r.URL.Path != health.LIVENESS_PATH && r.URL.Path != health.READINESS_PATH {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(a.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+// Handle adds a custom admin handler
+func (a *Server) Handle(pattern string, h http.Handler) {
+	a.mux.Handle(pattern, h)
+}
+
+// AddServices lists the services of s under name in /admin/services
+func (a *Server) AddServices(name string, s Servicer) {
+	a.l.Lock()
+	a.services[name] = s
+	a.l.Unlock()
+}
+
+// AddDB lists the stats of db under name in /admin/db
+func (a *Server) AddDB(name string, db DBStatser) {
+	a.l.Lock()
+	a.dbs[name] = db
+	a.l.Unlock()
+}
+
+// RemoveDB removes the db added under name
+func (a *Server) RemoveDB(name string) {
+	a.l.Lock()
+	delete(a.dbs, name)
+	a.l.Unlock()
+}
+
+func (a *Server) GetAddress() *net.TCPAddr {
+	return a.addr
+}
+
+func (a *Server) GetListener() *net.TCPListener {
+	return a.lis
+}
+
+func (a *Server) StartServ() error {
+	return a.s.Serve(a.lis)
+}
+
+func (a *Server) Stop() {
+	_ = a.s.Close()
+}
+
+func (a *Server) GracefulStop() {
+	ctx, cancel := context.WithTimeout(context.Background(), DEFAULT_SHUTDOWN_TIMEOUT)
+	defer cancel()
+	_ = a.Shutdown(ctx)
+}
+
+// Shutdown waits for the requests until ctx is done, a running profile included
+func (a *Server) Shutdown(ctx context.Context) error {
+	err := a.s.Shutdown(ctx)
+	if err != nil {
+		_ = a.s.Close()
+	}
+	return err
+}
+
+func (a *Server) goroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_ = rpprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+func (a *Server) build(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.BuildInfo)
+}
+
+func (a *Server) config(w http.ResponseWriter, r *http.Request) {
+	if a.Settings == nil {
+		writeError(w, http.StatusNotFound, errors.New("no config"))
+		return
+	}
+	writeJSON(w, http.StatusOK, config.Redact(a.Settings()))
+}
+
+func (a *Server) servicesInfo(w http.ResponseWriter, r *http.Request) {
+	a.l.RLock()
+	defer a.l.RUnlock()
+	infos := make(map[string]map[string]service_register.ServerInfo, len(a.services))
+	for name, s := range a.services {
+		infos[name] = s.GetServicesInfo()
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (a *Server) dbStats(w http.ResponseWriter, r *http.Request) {
+	a.l.RLock()
+	defer a.l.RUnlock()
+	stats := make(map[string]map[string]sql.DBStats, len(a.dbs))
+	for name, db := range a.dbs {
+		stats[name] = db.Stats()
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+type levelBody struct {
+	Level string `json:"level"`
+}
+
+// level serves the level of the log, a POST changes it, the level being a form value or a json body
+func (a *Server) level(w http.ResponseWriter, r *http.Request) {
+	if a.Level == nil {
+		writeError(w, http.StatusNotFound, errors.New("no log level"))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPut:
+		text := r.FormValue("level")
+		if text == "" {
+			body := levelBody{}
+			data, err := ioutil.ReadAll(r.Body)
+			if err == nil {
+				err = json.Unmarshal(data, &body)
+			}
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			text = body.Level
+		}
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(text)); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		a.Level.SetLevel(level)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	writeJSON(w, http.StatusOK, levelBody{Level: a.Level.Level().String()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,105 @@
+package admin
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/joselee214/j7f/components/health"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testDB struct{}
+
+func (testDB) Stats() map[string]sql.DBStats {
+	return map[string]sql.DBStats{"master": {OpenConnections: 3}}
+}
+
+func newTestServer(t *testing.T) *Server {
+	a, err := NewServer(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func do(a *Server, method, path, token string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != "" {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	w := httptest.NewRecorder()
+	a.s.Handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestToken(t *testing.T) {
+	a := newTestServer(t)
+	defer a.GetListener().Close()
+	a.Token = "s3cret"
+
+	if w := do(a, http.MethodGet, "/debug/goroutines", "", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("no token = %d", w.Code)
+	}
+	if w := do(a, http.MethodGet, "/debug/goroutines", "wrong", ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong token = %d", w.Code)
+	}
+	if w := do(a, http.MethodGet, "/debug/goroutines", "s3cret", ""); w.Code != http.StatusOK {
+		t.Fatalf("token = %d", w.Code)
+	}
+	if w := do(a, http.MethodGet, health.LIVENESS_PATH, "", ""); w.Code != http.StatusOK {
+		t.Fatalf("liveness without token = %d", w.Code)
+	}
+}
+
+func TestDBStats(t *testing.T) {
+	a := newTestServer(t)
+	defer a.GetListener().Close()
+	a.AddDB("orders", testDB{})
+
+	stats := map[string]map[string]sql.DBStats{}
+	w := do(a, http.MethodGet, "/admin/db", "", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats["orders"]["master"].OpenConnections != 3 {
+		t.Fatalf("stats = %s", w.Body)
+	}
+
+	a.RemoveDB("orders")
+	w = do(a, http.MethodGet, "/admin/db", "", "")
+	if strings.Contains(w.Body.String(), "orders") {
+		t.Fatalf("removed db listed: %s", w.Body)
+	}
+}
+
+func TestConfigAndLevel(t *testing.T) {
+	a := newTestServer(t)
+	defer a.GetListener().Close()
+	a.Settings = func() map[string]interface{} {
+		return map[string]interface{}{"db": map[string]interface{}{"password": "hunter2", "addr": "m"}}
+	}
+	level := zap.NewAtomicLevel()
+	a.Level = &level
+
+	w := do(a, http.MethodGet, "/admin/config", "", "")
+	if strings.Contains(w.Body.String(), "hunter2") || !strings.Contains(w.Body.String(), `"addr": "m"`) {
+		t.Fatalf("config = %s", w.Body)
+	}
+
+	if w = do(a, http.MethodPost, "/admin/log/level", "", "level=debug"); w.Code != http.StatusOK {
+		t.Fatalf("set level = %d %s", w.Code, w.Body)
+	}
+	if level.Level() != zap.DebugLevel {
+		t.Fatalf("level = %s", level.Level())
+	}
+	if w = do(a, http.MethodPost, "/admin/log/level", "", "level=loud"); w.Code != http.StatusBadRequest {
+		t.Fatalf("bad level = %d", w.Code)
+	}
+}
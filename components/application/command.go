@@ -0,0 +1,454 @@
+package application
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/joselee214/j7f/components/config"
+	"github.com/joselee214/j7f/components/grace"
+	flag "github.com/spf13/pflag"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const DEFAULT_CONFIG_FILE = "config.yaml"
+
+const DEFAULT_STOP_TIMEOUT = 90
+
+// Command is a sub command of an App, e.g. "serve" or "config check"
+type Command struct {
+	Name  string
+	Usage string
+	// Flags are the flags of the command, the global ones of the App are added to them
+	Flags *flag.FlagSet
+	Run   func(app *App, args []string) error
+
+	Sub []*Command
+}
+
+// App is the command line of a service:
+//
+//	app serve [--workers n]
+//	app version [--verbose]
+//	app config check
+//	app config encrypt [value]
+//	app config keygen
+//	app migrate [args]
+//	app reload
+//	app stop [--timeout seconds]
+//
+// all of them take --config, --env, --set, --key-file and --pid. The config is read from, by
+// increasing precedence, the --config file, its config.<env>.yaml overlay, the
+// <EnvPrefix>_<KEY> env vars and the --set key=value flags, see config.Load
+type App struct {
+	Name string
+
+	ConfigFile string
+	PidFile    string
+	// Env selects the config overlay, <EnvPrefix>_ENV by default
+	Env string
+	// EnvPrefix of the env vars overriding the config, the upper-cased Name by default
+	EnvPrefix string
+	// KeyFile holds the key of the ENC(...) values, see config.LoadKey
+	KeyFile string
+
+	// Setup registers the services and the routes before serving
+	Setup func(a *ApplicationManager) error
+	// Migrate runs the migrations of the migrate command
+	Migrate func(c *config.Configer, cfg *Config, args []string) error
+
+	Out io.Writer
+
+	sets     []string
+	commands []*Command
+}
+
+func NewApp(name string) *App {
+	prefix := strings.ToUpper(strings.Replace(name, "-", "_", -1))
+	app := &App{
+		Name:       name,
+		ConfigFile: DEFAULT_CONFIG_FILE,
+		PidFile:    name + ".pid",
+		Env:        os.Getenv(prefix + "_ENV"),
+		EnvPrefix:  prefix,
+		Out:        os.Stdout,
+	}
+	app.AddCommand(app.serveCommand(), app.versionCommand(), app.configCommand(),
+		app.migrateCommand(), app.signalCommand("reload", "reload the running instance gracefully", syscall.SIGHUP),
+		app.stopCommand())
+	return app
+}
+
+// AddCommand adds commands, one named like an existing one replaces it
+func (app *App) AddCommand(cmds ...*Command) {
+	for _, cmd := range cmds {
+		replaced := false
+		for i, c := range app.commands {
+			if c.Name == cmd.Name {
+				app.commands[i] = cmd
+				replaced = true
+			}
+		}
+		if !replaced {
+			app.commands = append(app.commands, cmd)
+		}
+	}
+}
+
+// Execute runs the command of os.Args
+func (app *App) Execute() error {
+	return app.ExecuteArgs(os.Args[1:])
+}
+
+func (app *App) ExecuteArgs(args []string) error {
+	//兼容旧版本平滑重启的参数
+	filtered := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg != grace.FLAG_GRACEFUL {
+			filtered = append(filtered, arg)
+		}
+	}
+	args = filtered
+
+	cmds := app.commands
+	var cmd *Command
+	path := make([]string, 0)
+	for len(args) > 0 {
+		next := find(cmds, args[0])
+		if next == nil {
+			break
+		}
+		cmd = next
+		path = append(path, cmd.Name)
+		cmds = cmd.Sub
+		args = args[1:]
+	}
+	if cmd == nil || cmd.Run == nil {
+		app.usage(cmds, path)
+		if len(args) > 0 && args[0] != "help" && args[0] != "-h" && args[0] != "--help" {
+			return fmt.Errorf("unknown command %q", strings.Join(append(path, args[0]), " "))
+		}
+		return nil
+	}
+
+	fs := cmd.Flags
+	if fs == nil {
+		fs = flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	}
+	if fs.Lookup("config") == nil {
+		fs.StringVarP(&app.ConfigFile, "config", "c", app.ConfigFile, "config file")
+		fs.StringVarP(&app.Env, "env", "e", app.Env, "environment, reads the config.<env> overlay")
+		fs.StringArrayVar(&app.sets, "set", nil, "override a config key, key=value")
+		fs.StringVar(&app.KeyFile, "key-file", app.KeyFile, "key of the encrypted config values, else $"+config.ENV_CONFIG_KEY+" or $"+config.ENV_CONFIG_KEY_FILE)
+		fs.StringVar(&app.PidFile, "pid", app.PidFile, "pid file")
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	return cmd.Run(app, fs.Args())
+}
+
+func find(cmds []*Command, name string) *Command {
+	for _, c := range cmds {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (app *App) usage(cmds []*Command, path []string) {
+	_, _ = fmt.Fprintf(app.Out, "Usage: %s <command> [flags]\n\nCommands:\n", strings.Join(append([]string{app.Name}, path...), " "))
+	for _, c := range cmds {
+		_, _ = fmt.Fprintf(app.Out, "  %-10s %s\n", c.Name, c.Usage)
+	}
+}
+
+func (app *App) serveCommand() *Command {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	workers := fs.Int("workers", 0, "fork n workers sharing the ports with SO_REUSEPORT, 0 serves in process")
+	return &Command{
+		Name:  "serve",
+		Usage: "run the service",
+		Flags: fs,
+		Run: func(app *App, args []string) error {
+			opts, err := app.LoadOptions()
+			if err != nil {
+				return err
+			}
+			if *workers > 0 && !grace.IsWorker() {
+				return app.runMaster(opts, *workers)
+			}
+
+			a, err := NewApplicationManagerWithOptions(opts)
+			if err != nil {
+				return err
+			}
+			if app.Setup != nil {
+				if err = app.Setup(a); err != nil {
+					return err
+				}
+			}
+			if !grace.IsWorker() {
+				//就绪后写pid, 平滑重启的子进程覆盖父进程的
+				a.ReadyHooks = append(a.ReadyHooks, app.writePid)
+				defer app.removePid()
+			}
+			return a.Run()
+		},
+	}
+}
+
+// runMaster forks the workers, the master serves the admin
+func (app *App) runMaster(opts *config.LoadOptions, workers int) error {
+	c, cfg, err := LoadConfigWith(opts)
+	if err != nil {
+		return err
+	}
+	s, err := NewMasterAdmin(c, cfg)
+	if err != nil {
+		return err
+	}
+	if s != nil {
+		go func() {
+			_ = s.StartServ()
+		}()
+		defer s.GracefulStop()
+	}
+	if err = app.writePid(); err != nil {
+		return err
+	}
+	defer app.removePid()
+	return grace.NewMaster(workers).Run()
+}
+
+func (app *App) versionCommand() *Command {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	verbose := fs.BoolP("verbose", "v", false, "print the dependencies")
+	return &Command{
+		Name:  "version",
+		Usage: "print the build info",
+		Flags: fs,
+		Run: func(app *App, args []string) error {
+			GetBuildInfo(app.Name).Print(app.Out, *verbose)
+			return nil
+		},
+	}
+}
+
+func (app *App) configCommand() *Command {
+	return &Command{
+		Name:  "config",
+		Usage: "config tools",
+		Sub: []*Command{{
+			Name:  "check",
+			Usage: "validate the config and print the effective one",
+			Run: func(app *App, args []string) error {
+				opts, err := app.LoadOptions()
+				if err != nil {
+					return err
+				}
+				c, _, err := LoadConfigWith(opts)
+				if err != nil {
+					return err
+				}
+				_, _ = fmt.Fprintf(app.Out, "# %s is valid\n", app.ConfigFile)
+				sources := c.Sources()
+				keys := make([]string, 0, len(sources))
+				for key := range sources {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				//和admin一样打印Redacted的值, 列表里的密码也要隐藏
+				settings := c.Redacted()
+				for _, key := range keys {
+					v := lookupKey(settings, key)
+					if c.IsSecret(key) {
+						v = config.REDACTED
+					}
+					value, err := json.Marshal(v)
+					if err != nil {
+						value = []byte(fmt.Sprint(v))
+					}
+					_, _ = fmt.Fprintf(app.Out, "%s: %s  # %s\n", key, value, sources[key])
+				}
+				return nil
+			},
+		}, {
+			Name:  "encrypt",
+			Usage: "encrypt a value, read from stdin without argument, into ENC(...)",
+			Run: func(app *App, args []string) error {
+				value := strings.Join(args, " ")
+				if len(args) == 0 {
+					data, err := ioutil.ReadAll(os.Stdin)
+					if err != nil {
+						return err
+					}
+					value = strings.TrimRight(string(data), "\r\n")
+				}
+				key, err := config.LoadKey(app.KeyFile)
+				if err != nil {
+					return err
+				}
+				c, err := config.NewCipher(key)
+				if err != nil {
+					return err
+				}
+				enc, err := c.Encrypt(value)
+				if err != nil {
+					return err
+				}
+				_, _ = fmt.Fprintln(app.Out, enc)
+				return nil
+			},
+		}, {
+			Name:  "keygen",
+			Usage: "print a new key for the encrypted values",
+			Run: func(app *App, args []string) error {
+				key, err := config.GenerateKey()
+				if err != nil {
+					return err
+				}
+				_, _ = fmt.Fprintln(app.Out, key)
+				return nil
+			},
+		}},
+	}
+}
+
+// lookupKey returns the value of the dotted key in the nested settings
+func lookupKey(settings map[string]interface{}, key string) interface{} {
+	var value interface{} = settings
+	for _, part := range strings.Split(key, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[part]
+	}
+	return value
+}
+
+func (app *App) migrateCommand() *Command {
+	return &Command{
+		Name:  "migrate",
+		Usage: "run the migrations",
+		Run: func(app *App, args []string) error {
+			if app.Migrate == nil {
+				return errors.New("no migration defined")
+			}
+			opts, err := app.LoadOptions()
+			if err != nil {
+				return err
+			}
+			c, cfg, err := LoadConfigWith(opts)
+			if err != nil {
+				return err
+			}
+			return app.Migrate(c, cfg, args)
+		},
+	}
+}
+
+func (app *App) signalCommand(name, usage string, sig syscall.Signal) *Command {
+	return &Command{
+		Name:  name,
+		Usage: usage,
+		Run: func(app *App, args []string) error {
+			pid, err := app.readPid()
+			if err != nil {
+				return err
+			}
+			return syscall.Kill(pid, sig)
+		},
+	}
+}
+
+func (app *App) stopCommand() *Command {
+	fs := flag.NewFlagSet("stop", flag.ContinueOnError)
+	timeout := fs.Int("timeout", DEFAULT_STOP_TIMEOUT, "seconds to wait for the exit")
+	return &Command{
+		Name:  "stop",
+		Usage: "stop the running instance gracefully and wait for its exit",
+		Flags: fs,
+		Run: func(app *App, args []string) error {
+			pid, err := app.readPid()
+			if err != nil {
+				return err
+			}
+			if err = syscall.Kill(pid, syscall.SIGTERM); err != nil {
+				return err
+			}
+			deadline := time.Now().Add(time.Duration(*timeout) * time.Second)
+			for time.Now().Before(deadline) {
+				if syscall.Kill(pid, 0) == syscall.ESRCH {
+					return nil
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			return fmt.Errorf("process %d still running after %ds", pid, *timeout)
+		},
+	}
+}
+
+// LoadOptions are the config layers of the command line
+func (app *App) LoadOptions() (*config.LoadOptions, error) {
+	opts := &config.LoadOptions{
+		File:      app.ConfigFile,
+		Env:       app.Env,
+		EnvPrefix: app.EnvPrefix,
+		KeyFile:   app.KeyFile,
+	}
+	if len(app.sets) == 0 {
+		return opts, nil
+	}
+	//--set key=value 转成以key命名的flag
+	opts.Flags = flag.NewFlagSet("set", flag.ContinueOnError)
+	for _, set := range app.sets {
+		i := strings.Index(set, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid --set %q, expect key=value", set)
+		}
+		//配置的key不区分大小写
+		key := strings.ToLower(set[:i])
+		if opts.Flags.Lookup(key) == nil {
+			opts.Flags.String(key, "", "")
+		}
+		if err := opts.Flags.Set(key, set[i+1:]); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+func (app *App) writePid() error {
+	return ioutil.WriteFile(app.PidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// removePid removes the pid file if it is still the one of the process, not of a restarted child
+func (app *App) removePid() {
+	if pid, err := app.readPid(); err == nil && pid == os.Getpid() {
+		_ = os.Remove(app.PidFile)
+	}
+}
+
+func (app *App) readPid() (int, error) {
+	data, err := ioutil.ReadFile(app.PidFile)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid pid file %s: %s", app.PidFile, err)
+	}
+	return pid, nil
+}
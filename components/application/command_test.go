@@ -0,0 +1,216 @@
+package application
+
+import (
+	"bytes"
+	"github.com/joselee214/j7f/components/config"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func newTestApp() (*App, *bytes.Buffer) {
+	app := NewApp("test-svc")
+	out := &bytes.Buffer{}
+	app.Out = out
+	return app, out
+}
+
+func TestExecuteArgsDispatch(t *testing.T) {
+	app, out := newTestApp()
+	var got []string
+	app.AddCommand(&Command{
+		Name: "echo",
+		Run: func(app *App, args []string) error {
+			got = args
+			return nil
+		},
+	})
+	if err := app.ExecuteArgs([]string{"echo", "--graceful", "a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "a,b" {
+		t.Fatalf("args = %v", got)
+	}
+
+	if err := app.ExecuteArgs([]string{"nope"}); err == nil {
+		t.Fatal("unknown command accepted")
+	}
+	if !strings.Contains(out.String(), "serve") || !strings.Contains(out.String(), "echo") {
+		t.Fatalf("usage = %s", out)
+	}
+	out.Reset()
+	if err := app.ExecuteArgs([]string{"config"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "test-svc config") || !strings.Contains(out.String(), "check") {
+		t.Fatalf("config usage = %s", out)
+	}
+}
+
+func TestAddCommandReplaces(t *testing.T) {
+	app, _ := newTestApp()
+	called := false
+	app.AddCommand(&Command{Name: "version", Run: func(app *App, args []string) error {
+		called = true
+		return nil
+	}})
+	if err := app.ExecuteArgs([]string{"version"}); err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Fatal("version not replaced")
+	}
+}
+
+func TestConfigCheck(t *testing.T) {
+	path := writeConfig(t, testConfig)
+	defer os.Remove(path)
+	app, out := newTestApp()
+	err := app.ExecuteArgs([]string{"config", "check", "-c", path, "--set", "grpc.unaryTimeout=9", "--set", "db.password=hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := out.String()
+	if !strings.Contains(s, path+" is valid") || !strings.Contains(s, `name: "user"`) {
+		t.Fatalf("output = %s", s)
+	}
+	if !strings.Contains(s, `grpc.unarytimeout: "9"  # flag --grpc.unarytimeout`) {
+		t.Fatalf("--set not applied: %s", s)
+	}
+	if strings.Contains(s, "hunter2") {
+		t.Fatalf("secret printed: %s", s)
+	}
+
+	if err := app.ExecuteArgs([]string{"config", "check", "-c", path, "--set", "novalue"}); err == nil {
+		t.Fatal("invalid --set accepted")
+	}
+}
+
+func TestConfigCheckRedactsLists(t *testing.T) {
+	path := writeConfig(t, testConfig+`
+db:
+  orders:
+    master: {addr: m, password: master-pw}
+    slave:
+      - {addr: s1, password: slave-pw}
+`)
+	defer os.Remove(path)
+	app, out := newTestApp()
+	if err := app.ExecuteArgs([]string{"config", "check", "-c", path}); err != nil {
+		t.Fatal(err)
+	}
+	s := out.String()
+	if strings.Contains(s, "master-pw") || strings.Contains(s, "slave-pw") || !strings.Contains(s, `"addr":"s1"`) {
+		t.Fatalf("output = %s", s)
+	}
+}
+
+func TestSignalCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "svc.pid")
+
+	app, _ := newTestApp()
+	if err := app.ExecuteArgs([]string{"reload", "--pid", pidFile}); err == nil {
+		t.Fatal("reload without pid file")
+	}
+
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	if err := ioutil.WriteFile(pidFile, []byte(strconv.Itoa(cmd.Process.Pid)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// sleep 没有处理SIGHUP, reload 会让它退出
+	if err := app.ExecuteArgs([]string{"reload", "--pid", pidFile}); err != nil {
+		t.Fatal(err)
+	}
+	err = <-done
+	if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); !ok || ws.Signal() != syscall.SIGHUP {
+		t.Fatalf("reload: %v", err)
+	}
+}
+
+func TestStopWaitsForExit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "svc.pid")
+
+	cmd := exec.Command("sleep", "60")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	//回收子进程, 否则kill(pid, 0)对僵尸进程一直成功
+	go func() {
+		_ = cmd.Wait()
+	}()
+	if err := ioutil.WriteFile(pidFile, []byte(strconv.Itoa(cmd.Process.Pid)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	app, _ := newTestApp()
+	if err := app.ExecuteArgs([]string{"stop", "--pid", pidFile, "--timeout", "5"}); err != nil {
+		t.Fatal(err)
+	}
+	if syscall.Kill(cmd.Process.Pid, 0) != syscall.ESRCH {
+		t.Fatal("process still running")
+	}
+
+	if err := ioutil.WriteFile(pidFile, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.ExecuteArgs([]string{"stop", "--pid", pidFile}); err == nil {
+		t.Fatal("invalid pid file accepted")
+	}
+}
+
+func TestMigrateRequiresMigration(t *testing.T) {
+	app, _ := newTestApp()
+	if err := app.ExecuteArgs([]string{"migrate"}); err == nil {
+		t.Fatal("migrate without Migrate")
+	}
+}
+
+func TestMigrateReadsAllLayers(t *testing.T) {
+	path := writeConfig(t, testConfig)
+	defer os.Remove(path)
+	overlay := config.EnvFile(path, "prod")
+	if err := ioutil.WriteFile(overlay, []byte("version: 2.0.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(overlay)
+
+	app, _ := newTestApp()
+	var got *Config
+	var gotArgs []string
+	app.Migrate = func(c *config.Configer, cfg *Config, args []string) error {
+		got, gotArgs = cfg, args
+		return nil
+	}
+	err := app.ExecuteArgs([]string{"migrate", "-c", path, "-e", "prod", "--set", "grpc.unaryTimeout=9", "up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != "2.0.0" || got.Grpc.UnaryTimeout != 9 {
+		t.Fatalf("config = version %s, unaryTimeout %d", got.Version, got.Grpc.UnaryTimeout)
+	}
+	if len(gotArgs) != 1 || gotArgs[0] != "up" {
+		t.Fatalf("args = %v", gotArgs)
+	}
+}
@@ -1,52 +1,753 @@
 package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/joselee214/j7f/components/admin"
+	"github.com/joselee214/j7f/components/config"
+	"github.com/joselee214/j7f/components/grace"
+	"github.com/joselee214/j7f/components/grpc/interceptor"
+	grpcserver "github.com/joselee214/j7f/components/grpc/server"
+	"github.com/joselee214/j7f/components/health"
+	"github.com/joselee214/j7f/components/http/middleware"
+	httpserver "github.com/joselee214/j7f/components/http/server"
+	"github.com/joselee214/j7f/components/lifecycle"
+	"github.com/joselee214/j7f/components/log"
+	"github.com/joselee214/j7f/components/service_register"
+	"github.com/joselee214/j7f/util"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	DEFAULT_REGISTER_PREFIX    = "/services"
+	DEFAULT_REGISTER_HEARTBEAT = 3
+	DEFAULT_REGISTER_TTL       = 10
+
+	DEFAULT_REMOTE_PREFIX       = "/config"
+	DEFAULT_REMOTE_LOAD_TIMEOUT = 5 * time.Second
+)
+
+// Config is the config file of an application, e.g.
 //
-//type ApplicationManager struct {
-//	Config map[string]interface{}
-//	Env string
-//	Version string
-//}
-//
-//func NewApplicationManager() (*ApplicationManager,error){
-//	s := &ApplicationManager{}
-//	return  s,nil
-//}
-//
-//func (a ApplicationManager) RegisterStreamInterceptors(...interface{}) {
-//	panic("implement me")
-//}
-//
-//func (a ApplicationManager) RegisterUnaryInterceptors(...interface{}) {
-//	panic("implement me")
-//}
-//
-//func (a ApplicationManager) RegisterCb(...interface{}) {
-//	panic("implement me")
-//}
-//
-//func (a ApplicationManager) NewServ() error {
-//	panic("implement me")
-//}
-//
-//func (a ApplicationManager) StartServ() error {
-//	panic("implement me")
-//}
-//
-//func (a ApplicationManager) Stop() {
-//	panic("implement me")
-//}
-//
-//func (a ApplicationManager) GracefulStop() {
-//	panic("implement me")
-//}
-//
-//func (a ApplicationManager) GetServicesInfo() map[string]service_register.ServerInfo {
-//	panic("implement me")
-//}
-//
-//func (a ApplicationManager) GetAddress() *net.TCPAddr {
-//	panic("implement me")
-//}
-//
-//func (a ApplicationManager) GetListener() *net.TCPListener {
-//	panic("implement me")
-//}
\ No newline at end of file
+//	name: user
+//	version: 1.0.0
+//	env: prod
+//	log:
+//	  level: info
+//	  encoding: json
+//	grpc:
+//	  addr: ":9000"
+//	  processingTimeout: 5
+//	  unaryTimeout: 5
+//	  methodTimeouts:
+//	    - {method: /user.User/Export, timeout: 60}
+//	  rateLimit:
+//	    key: x-app-id
+//	    default: {rate: 100, burst: 200}
+//	http:
+//	  addr: ":8080"
+//	register:
+//	  prefix: /services
+//	  etcd:
+//	    endpoints: ["127.0.0.1:2379"]
+//	grace:
+//	  timeout: 60
+//	health:
+//	  interval: 5
+//	  deregisterOnDegraded: true
+//	admin:
+//	  addr: "127.0.0.1:6060"
+//	remote:
+//	  prefix: /config/user
+type Config struct {
+	Name    string
+	Version string
+	Env     string
+
+	Log  log.Config
+	Grpc *ServerConfig
+	Http *ServerConfig
+
+	Register *RegisterConfig
+	Grace    GraceConfig
+	Health   HealthConfig
+
+	// Admin is the pprof, config and log level listener, off when nil, see admin.Server
+	Admin *AdminConfig
+	// Remote merges the config of etcd over the file and watches it, off when nil
+	Remote *RemoteConfig
+}
+
+type ServerConfig struct {
+	Addr string
+	// ShutdownTimeout is the drain deadline of the server in seconds
+	ShutdownTimeout int
+
+	// grpc stream only
+	PerRequest        int
+	ProcessingTimeout int
+	MaxInFlight       int
+
+	// grpc unary only, the processing timeouts in seconds, MethodTimeouts by full method or service
+	UnaryTimeout   int
+	MethodTimeouts []interceptor.MethodTimeout
+
+	// RateLimit limits the grpc calls of each caller, off when nil, see ApplicationManager.RateLimiter
+	RateLimit *interceptor.RateLimitConfig
+}
+
+type RegisterConfig struct {
+	Prefix string
+	NodeId string
+	Ip     string
+	// Heartbeat and TTL of the registration in seconds
+	Heartbeat int
+	TTL       int
+	Metadata  map[string]string
+
+	Etcd service_register.Config
+}
+
+type GraceConfig struct {
+	// Timeout is the drain deadline of the shutdown in seconds, see grace.DefaultTimeout
+	Timeout int
+	// ReadyTimeout is how long a restarted child has to be ready in seconds
+	ReadyTimeout int
+}
+
+type RemoteConfig struct {
+	// Prefix of the keys, <DEFAULT_REMOTE_PREFIX>/<name> by default
+	Prefix string
+	// Etcd is register.etcd when its endpoints are empty
+	Etcd service_register.Config
+}
+
+type AdminConfig struct {
+	// Addr listens on the loopback when its host is empty, e.g. ":6060"
+	Addr string
+	// Token is required on a non loopback Addr, see admin.Server
+	Token string
+}
+
+// addr is the admin address, the loopback by default
+func (c *AdminConfig) addr() (*net.TCPAddr, error) {
+	addr, err := net.ResolveTCPAddr("tcp", c.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if addr.IP == nil {
+		addr.IP = net.IPv4(127, 0, 0, 1)
+	}
+	if !addr.IP.IsLoopback() && c.Token == "" {
+		return nil, fmt.Errorf("%s is not a loopback address, set a token", c.Addr)
+	}
+	return addr, nil
+}
+
+type HealthConfig struct {
+	// Interval of the readiness checks driving the registration in seconds, see health.Watch
+	Interval int
+	// CacheTTL of the check results in seconds
+	CacheTTL int
+	// DeregisterOnDegraded deregisters the service when degraded too, not only when down
+	DeregisterOnDegraded bool
+}
+
+// ApplicationManager builds the logger, the servers with the standard interceptors,
+// the service registration and runs them under grace
+type ApplicationManager struct {
+	Config   *Config
+	Configer *config.Configer
+
+	Logger *log.Logger
+	Grpc   *grpcserver.GrpcServer
+	Http   *httpserver.HttpServer
+	Etcd   *service_register.EtcdCli
+	Admin  *admin.Server
+	// RateLimiter applies the grpc RateLimit, SetRedis before Run for the cluster-wide limits
+	RateLimiter *interceptor.RateLimiter
+	// Remote is the etcd config source of Config.Remote
+	Remote *config.EtcdSource
+
+	Grace *grace.Manager
+	// ReadyHooks are added to the grace ReadyHooks, they run once the servers serve
+	ReadyHooks []func() error
+
+	// undo closes what NewApplicationManagerWithConfig opened when a later step fails
+	undo []func()
+}
+
+// LoadConfig reads and validates the config file path, yaml, json or toml
+func LoadConfig(path string) (*config.Configer, *Config, error) {
+	return LoadConfigWith(&config.LoadOptions{File: path})
+}
+
+// LoadConfigWith reads and validates the layers of opts, see config.Load. Config.Env is opts.Env
+// when not set
+func LoadConfigWith(opts *config.LoadOptions) (*config.Configer, *Config, error) {
+	c, err := config.Load(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := &Config{}
+	if err = c.Unmarshal(cfg); err != nil {
+		return nil, nil, err
+	}
+	if cfg.Env == "" {
+		cfg.Env = opts.Env
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return c, cfg, nil
+}
+
+// Validate checks the servers and the log level
+func (cfg *Config) Validate() error {
+	if cfg.Grpc == nil && cfg.Http == nil {
+		return errors.New("application: no grpc nor http server configured")
+	}
+	for name, sc := range map[string]*ServerConfig{"grpc": cfg.Grpc, "http": cfg.Http} {
+		if sc == nil {
+			continue
+		}
+		if _, err := net.ResolveTCPAddr("tcp", sc.Addr); err != nil {
+			return fmt.Errorf("application: %s.addr: %s", name, err)
+		}
+	}
+	if cfg.Log.Level != "" {
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(cfg.Log.Level)); err != nil {
+			return fmt.Errorf("application: log.level: %s", err)
+		}
+	}
+	if cfg.Admin != nil {
+		if _, err := cfg.Admin.addr(); err != nil {
+			return fmt.Errorf("application: admin.addr: %s", err)
+		}
+	}
+	if cfg.Register != nil && len(cfg.Register.Etcd.Endpoints) == 0 {
+		return errors.New("application: register.etcd.endpoints is empty")
+	}
+	if cfg.Remote != nil && len(cfg.Remote.Etcd.Endpoints) == 0 && cfg.Register == nil {
+		return errors.New("application: remote.etcd.endpoints is empty")
+	}
+	return nil
+}
+
+// NewApplicationManager builds the application of the config file path
+func NewApplicationManager(path string) (*ApplicationManager, error) {
+	return NewApplicationManagerWithOptions(&config.LoadOptions{File: path})
+}
+
+// NewApplicationManagerWithOptions builds the application of the config layers of opts
+func NewApplicationManagerWithOptions(opts *config.LoadOptions) (*ApplicationManager, error) {
+	c, cfg, err := LoadConfigWith(opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewApplicationManagerWithConfig(c, cfg)
+}
+
+func NewApplicationManagerWithConfig(c *config.Configer, cfg *Config) (_ *ApplicationManager, err error) {
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	a := &ApplicationManager{
+		Config:   cfg,
+		Configer: c,
+	}
+	defer func() {
+		if err == nil {
+			a.undo = nil
+			return
+		}
+		//关闭已经打开的连接和监听, 撤销注册的钩子
+		for i := len(a.undo) - 1; i >= 0; i-- {
+			a.undo[i]()
+		}
+	}()
+
+	if cfg.Register != nil {
+		if a.Etcd, err = service_register.NewEtcd(&cfg.Register.Etcd); err != nil {
+			return nil, err
+		}
+		a.closeOnFailure(a.Etcd)
+	}
+	if cfg.Remote != nil {
+		if err = a.loadRemote(); err != nil {
+			return nil, err
+		}
+		cfg = a.Config
+	}
+
+	if a.Logger, err = log.NewZap(&cfg.Log); err != nil {
+		return nil, err
+	}
+	a.undo = append(a.undo, a.Add(a.Logger, health.Default))
+	if a.Remote != nil {
+		a.watchRemote()
+	}
+
+	if cfg.Grpc != nil {
+		if err = a.newGrpc(cfg.Grpc); err != nil {
+			return nil, err
+		}
+		a.undo = append(a.undo, func() {
+			_ = a.Grpc.GetListener().Close()
+		})
+	}
+	if cfg.Http != nil {
+		if err = a.newHttp(cfg.Http); err != nil {
+			return nil, err
+		}
+		a.undo = append(a.undo, func() {
+			_ = a.Http.GetListener().Close()
+		})
+	}
+
+	//worker 共享端口, admin 由 master 提供, 见 NewMasterAdmin
+	if cfg.Admin != nil && !grace.IsWorker() {
+		if err = a.newAdmin(cfg.Admin); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// closeOnFailure adds the etcd client e and closes it if NewApplicationManagerWithConfig fails
+func (a *ApplicationManager) closeOnFailure(e *service_register.EtcdCli) {
+	remove := a.Add(e)
+	a.undo = append(a.undo, func() {
+		remove()
+		_ = e.Close()
+	})
+}
+
+// stopHooker is a component closed with the lifecycle, e.g. a dao.Node or an mq.Consumer
+type stopHooker interface {
+	StopHook() lifecycle.Hook
+}
+
+// healthChecker is a component with health checks, e.g. a dao.Node or an mq.Producer
+type healthChecker interface {
+	HealthChecks() []*health.Check
+}
+
+// namedDB is a db pool listed in /admin/db, e.g. a dao.Node
+type namedDB interface {
+	admin.DBStatser
+	Name() string
+}
+
+// Add registers the StopHook of the components to lifecycle.Default, their HealthChecks to
+// health.Default and their db stats to the Admin, e.g. the dao nodes and the mq producers and
+// consumers of the application. The components built by the manager are added already.
+// The returned func removes them
+func (a *ApplicationManager) Add(components ...interface{}) func() {
+	removes := make([]func(), 0)
+	for _, c := range components {
+		if db, ok := c.(namedDB); ok && a.Admin != nil {
+			name := db.Name()
+			a.Admin.AddDB(name, db)
+			removes = append(removes, func() {
+				a.Admin.RemoveDB(name)
+			})
+		}
+		if s, ok := c.(stopHooker); ok {
+			removes = append(removes, lifecycle.OnStop(s.StopHook()))
+		}
+		if h, ok := c.(healthChecker); ok {
+			for _, check := range h.HealthChecks() {
+				removes = append(removes, health.Register(check))
+			}
+		}
+	}
+	return func() {
+		for _, remove := range removes {
+			remove()
+		}
+	}
+}
+
+// loadRemote merges the etcd config over the file one and reloads Config from it
+func (a *ApplicationManager) loadRemote() error {
+	rc := a.Config.Remote
+	if a.Configer == nil {
+		return errors.New("application: remote config needs a Configer")
+	}
+
+	etcd := a.Etcd
+	if len(rc.Etcd.Endpoints) > 0 {
+		var err error
+		if etcd, err = service_register.NewEtcd(&rc.Etcd); err != nil {
+			return err
+		}
+		etcd.Name = "etcd.remote"
+		a.closeOnFailure(etcd)
+	}
+	prefix := rc.Prefix
+	if prefix == "" {
+		prefix = DEFAULT_REMOTE_PREFIX + "/" + a.Config.Name
+	}
+
+	a.Remote = config.NewEtcdSource(a.Configer, etcd.Client(), prefix)
+	a.Remote.AddValidator(func(c *config.Configer) error {
+		cfg := &Config{}
+		if err := c.Unmarshal(cfg); err != nil {
+			return err
+		}
+		return cfg.Validate()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), DEFAULT_REMOTE_LOAD_TIMEOUT)
+	defer cancel()
+	if err := a.Remote.Load(ctx); err != nil {
+		return fmt.Errorf("application: remote config %s: %s", prefix, err)
+	}
+
+	cfg := &Config{}
+	if err := a.Configer.Unmarshal(cfg); err != nil {
+		return err
+	}
+	a.Config = cfg
+	return nil
+}
+
+// watchRemote applies the log level updates and stops the watch with the lifecycle,
+// the other settings are read once, see EtcdSource.OnChange
+func (a *ApplicationManager) watchRemote() {
+	a.Remote.OnChange("log.level", func(key string, old, new interface{}) {
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(fmt.Sprint(new))); err != nil {
+			return
+		}
+		a.Logger.Level.SetLevel(level)
+		a.Logger.Info("log level changed", zap.Any("old", old), zap.Stringer("new", level))
+	})
+	a.Remote.Watch()
+	remove := lifecycle.OnStop(lifecycle.Hook{
+		Name:     "application.remote",
+		Priority: lifecycle.PRIORITY_STOP_CONSUMERS,
+		Func: func(ctx context.Context) error {
+			a.Remote.Close()
+			return nil
+		},
+	})
+	a.undo = append(a.undo, func() {
+		remove()
+		a.Remote.Close()
+	})
+}
+
+func (a *ApplicationManager) newGrpc(cfg *ServerConfig) error {
+	addr, err := net.ResolveTCPAddr("tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+	if a.Grpc, err = grpcserver.NewGrpcServer(addr); err != nil {
+		return err
+	}
+	a.Grpc.ShutdownTimeout = time.Duration(cfg.ShutdownTimeout) * time.Second
+	a.Grpc.Health = health.Default
+
+	//标准拦截器: trace + 限流 + 错误 + 超时, trace在外层, 错误带上trace_id
+	a.Grpc.RegisterUnaryInterceptors(interceptor.UnaryServerTraceInterceptor(a.Logger))
+	a.Grpc.RegisterStreamInterceptors(interceptor.StreamServerTraceInterceptor(a.Logger, &grpcserver.Config{
+		PerRequest:        cfg.PerRequest,
+		ProcessingTimeout: cfg.ProcessingTimeout,
+		MaxInFlight:       cfg.MaxInFlight,
+	}))
+	if cfg.RateLimit != nil {
+		a.RateLimiter = interceptor.NewRateLimiter(a.Logger, cfg.RateLimit)
+		a.Grpc.RegisterUnaryInterceptors(interceptor.UnaryServerRateLimitInterceptor(a.RateLimiter))
+		a.Grpc.RegisterStreamInterceptors(interceptor.StreamServerRateLimitInterceptor(a.RateLimiter))
+	}
+	a.Grpc.RegisterUnaryInterceptors(
+		interceptor.UnaryServerErrorInterceptor(a.Logger),
+		interceptor.UnaryServerTimeoutInterceptor(a.Logger, &interceptor.TimeoutConfig{
+			Default: cfg.UnaryTimeout,
+			Methods: cfg.MethodTimeouts,
+		}),
+	)
+	a.Grpc.RegisterStreamInterceptors(interceptor.StreamServerErrorInterceptor(a.Logger))
+	return nil
+}
+
+func (a *ApplicationManager) newHttp(cfg *ServerConfig) error {
+	addr, err := net.ResolveTCPAddr("tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+	if a.Http, err = httpserver.NewHttpServer(addr, a.Logger, a.Config.Env); err != nil {
+		return err
+	}
+	a.Http.ShutdownTimeout = time.Duration(cfg.ShutdownTimeout) * time.Second
+	a.Http.Health = health.Default
+
+	//标准中间件: recovery + 日志 + 错误
+	a.Http.RegisterUnaryInterceptors(
+		middleware.Recovery(a.Logger),
+		middleware.Logger(a.Logger),
+		middleware.Error(),
+	)
+	return nil
+}
+
+func (a *ApplicationManager) newAdmin(cfg *AdminConfig) error {
+	addr, err := cfg.addr()
+	if err != nil {
+		return err
+	}
+	if a.Admin, err = admin.NewServer(addr); err != nil {
+		return err
+	}
+	a.Admin.Token = cfg.Token
+	a.Admin.BuildInfo = GetBuildInfo(a.Config.Name)
+	a.Admin.Level = &a.Logger.Level
+	if a.Configer != nil {
+		a.Admin.Settings = a.Configer.Redacted
+	}
+	return nil
+}
+
+// NewMasterAdmin builds the admin server of a grace.Master, nil without Admin config. The workers
+// share their ports, so they serve no admin, the one of the master serves its build and its config
+func NewMasterAdmin(c *config.Configer, cfg *Config) (*admin.Server, error) {
+	if cfg.Admin == nil {
+		return nil, nil
+	}
+	addr, err := cfg.Admin.addr()
+	if err != nil {
+		return nil, err
+	}
+	s, err := admin.NewServer(addr)
+	if err != nil {
+		return nil, err
+	}
+	s.Token = cfg.Admin.Token
+	s.BuildInfo = GetBuildInfo(cfg.Name)
+	if c != nil {
+		s.Settings = c.Redacted
+	}
+	return s, nil
+}
+
+// RegisterGrpc adds callbacks registering the grpc services, run by Run
+func (a *ApplicationManager) RegisterGrpc(cbs ...grpcserver.GrpcCallback) {
+	if a.Grpc == nil {
+		return
+	}
+	for _, cb := range cbs {
+		a.Grpc.RegisterCb(cb)
+	}
+}
+
+// RegisterHttp adds callbacks registering the http routes, run by Run
+func (a *ApplicationManager) RegisterHttp(cbs ...httpserver.HttpCallback) {
+	if a.Http == nil {
+		return
+	}
+	for _, cb := range cbs {
+		a.Http.RegisterCb(cb)
+	}
+}
+
+// Run builds the servers, registers the service once they serve and blocks until
+// the graceful shutdown is done
+func (a *ApplicationManager) Run() error {
+	if a.Config.Grace.Timeout > 0 {
+		grace.DefaultTimeout = time.Duration(a.Config.Grace.Timeout) * time.Second
+	}
+	if a.Config.Health.CacheTTL > 0 {
+		health.Default.CacheTTL = time.Duration(a.Config.Health.CacheTTL) * time.Second
+	}
+
+	a.Grace = grace.NewManager()
+	if a.Grpc != nil {
+		if err := a.Grpc.NewServ(); err != nil {
+			return err
+		}
+		a.Grace.Add(a.Grpc)
+	}
+	if a.Http != nil {
+		if err := a.Http.NewServ(); err != nil {
+			return err
+		}
+		a.Grace.Add(a.Http)
+	}
+	if a.Admin != nil {
+		if a.Grpc != nil {
+			a.Admin.AddServices("grpc", a.Grpc)
+		}
+		if a.Http != nil {
+			a.Admin.AddServices("http", a.Http)
+		}
+		a.Grace.Add(a.Admin)
+	}
+	a.Grace.ReadyTimeout = time.Duration(a.Config.Grace.ReadyTimeout) * time.Second
+	a.Grace.ReadyHooks = append(a.Grace.ReadyHooks, a.ReadyHooks...)
+
+	if a.Etcd != nil {
+		s, err := a.Service()
+		if err != nil {
+			return err
+		}
+		a.Grace.Rr = *service_register.NewRegisterOpts(s, a.Etcd)
+		a.Grace.ReadyHooks = append(a.Grace.ReadyHooks, a.watchHealth)
+	}
+
+	return a.Grace.ListenAndServe()
+}
+
+// Node describes the application for the registry, its services are those of the
+// grpc server, or of the http server without one. The servers must be built, see Run
+func (a *ApplicationManager) Node() (*service_register.Node, error) {
+	rc := a.Config.Register
+	if rc == nil {
+		rc = &RegisterConfig{}
+	}
+
+	var addr *net.TCPAddr
+	var infos map[string]service_register.ServerInfo
+	metadata := make(map[string]string)
+	for k, v := range rc.Metadata {
+		metadata[k] = v
+	}
+	if a.Grpc != nil {
+		addr = a.Grpc.GetListener().Addr().(*net.TCPAddr)
+		infos = a.Grpc.GetServicesInfo()
+		if a.Http != nil {
+			metadata["http_port"] = strconv.Itoa(a.Http.GetListener().Addr().(*net.TCPAddr).Port)
+		}
+	} else {
+		addr = a.Http.GetListener().Addr().(*net.TCPAddr)
+		infos = a.Http.GetServicesInfo()
+	}
+
+	ip := rc.Ip
+	if ip == "" && addr.IP != nil && !addr.IP.IsUnspecified() {
+		ip = addr.IP.String()
+	}
+	if ip == "" {
+		ips := util.GetLocalIps()
+		if len(ips) == 0 {
+			return nil, errors.New("application: no local ip to register")
+		}
+		ip = ips[0]
+	}
+
+	nodeId := rc.NodeId
+	if nodeId == "" {
+		hostname, _ := os.Hostname()
+		nodeId = fmt.Sprintf("%s-%s-%d", a.Config.Name, hostname, addr.Port)
+	}
+	if id := grace.WorkerId(); id >= 0 {
+		//每个 worker 各自注册, 一个 worker 退出不注销其他的
+		nodeId += "-w" + strconv.Itoa(id)
+		metadata["worker"] = strconv.Itoa(id)
+	}
+
+	address := net.JoinHostPort(ip, strconv.Itoa(addr.Port))
+	node := service_register.NewNode(nodeId, address, ip, a.Config.Version, addr.Port, metadata)
+	for name, info := range infos {
+		methods := make([]string, 0, len(info.Methods))
+		for _, m := range info.Methods {
+			methods = append(methods, m.Name)
+		}
+		sort.Strings(methods)
+		node.SetServices(name, &service_register.ServiceInfo{
+			Methods: methods,
+			Version: a.Config.Version,
+		})
+	}
+	return node, nil
+}
+
+// Service is the registration of Node under <prefix>/<name>/<address>, <prefix>/<name>/<address>#<worker>
+// in the workers of a grace.Master which share the address
+func (a *ApplicationManager) Service() (*service_register.Service, error) {
+	node, err := a.Node()
+	if err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := a.Config.Register
+	if rc == nil {
+		rc = &RegisterConfig{}
+	}
+	prefix := rc.Prefix
+	if prefix == "" {
+		prefix = DEFAULT_REGISTER_PREFIX
+	}
+	heartbeat, ttl := rc.Heartbeat, rc.TTL
+	if heartbeat <= 0 {
+		heartbeat = DEFAULT_REGISTER_HEARTBEAT
+	}
+	if ttl <= 0 {
+		ttl = DEFAULT_REGISTER_TTL
+	}
+
+	key := prefix + "/" + a.Config.Name + "/" + node.Address
+	if id := grace.WorkerId(); id >= 0 {
+		key += "#" + strconv.Itoa(id)
+	}
+	return &service_register.Service{
+		Key:   key,
+		Value: string(value),
+		TTL:   service_register.NewTTLOption(time.Duration(heartbeat)*time.Second, time.Duration(ttl)*time.Second),
+	}, nil
+}
+
+// watchHealth deregisters the service while its readiness is down, or degraded with
+// DeregisterOnDegraded, and registers it again once recovered. It stops with the lifecycle
+func (a *ApplicationManager) watchHealth() error {
+	threshold := health.StatusDown
+	if a.Config.Health.DeregisterOnDegraded {
+		threshold = health.StatusDegraded
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lifecycle.OnStop(lifecycle.Hook{
+		Name:     "application.health",
+		Priority: lifecycle.PRIORITY_DEREGISTER,
+		Func: func(context.Context) error {
+			cancel()
+			return nil
+		},
+	})
+
+	health.Default.OnChange(func(old, new *health.Report) {
+		if ctx.Err() != nil {
+			//停止中, 由grace注销
+			return
+		}
+		switch {
+		case old.Status < threshold && new.Status >= threshold:
+			a.Logger.Warn("health "+new.Status.String()+", deregister", zap.Any("checks", new.Checks))
+			if err := a.Grace.Rr.DeRegister(); err != nil {
+				a.Logger.Error("deregister error", zap.Error(err))
+			}
+		case old.Status >= threshold && new.Status < threshold:
+			a.Logger.Info("health " + new.Status.String() + ", register again")
+			if err := a.Grace.Rr.Register(); err != nil {
+				a.Logger.Error("register error", zap.Error(err))
+			}
+		}
+	})
+	go health.Default.Watch(ctx, time.Duration(a.Config.Health.Interval)*time.Second)
+	return nil
+}
@@ -0,0 +1,244 @@
+package application
+
+import (
+	"context"
+	"github.com/joselee214/j7f/components/dao"
+	"github.com/joselee214/j7f/components/dao/fake"
+	"github.com/joselee214/j7f/components/grace"
+	"github.com/joselee214/j7f/components/health"
+	"github.com/joselee214/j7f/components/log"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAddRegistersComponents(t *testing.T) {
+	n, err := fake.NewNode(&dao.DBConfig{
+		Name:   "orders",
+		Master: &dao.NodeConfig{Addr: "m"},
+		Slave:  []*dao.NodeConfig{{Addr: "s", Weight: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	ctx := context.Background()
+
+	if _, ok := health.Default.CheckOne(ctx, "dao.orders.master"); ok {
+		t.Fatal("NewNode registered its checks")
+	}
+
+	a := &ApplicationManager{}
+	remove := a.Add(n.Node)
+	for _, name := range []string{"dao.orders.master", "dao.orders.slaves"} {
+		res, ok := health.Default.CheckOne(ctx, name)
+		if !ok {
+			t.Fatalf("%s not registered", name)
+		}
+		if res.Status != health.StatusUp {
+			t.Fatalf("%s = %+v", name, res)
+		}
+	}
+	remove()
+	if _, ok := health.Default.CheckOne(ctx, "dao.orders.master"); ok {
+		t.Fatal("check not removed")
+	}
+}
+
+const testConfig = `
+name: user
+version: 1.2.0
+log:
+  level: info
+  encoding: json
+  outputPaths: [stdout]
+grpc:
+  addr: "127.0.0.1:0"
+  unaryTimeout: 5
+  methodTimeouts:
+    - {method: /user.User/Export, timeout: 60}
+http:
+  addr: "127.0.0.1:0"
+admin:
+  addr: "127.0.0.1:0"
+`
+
+func writeConfig(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "app-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	return f.Name()
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, testConfig)
+	defer os.Remove(path)
+
+	_, cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "user" || cfg.Grpc.UnaryTimeout != 5 || cfg.Admin.Addr != "127.0.0.1:0" {
+		t.Fatalf("config = %+v", cfg)
+	}
+	if len(cfg.Grpc.MethodTimeouts) != 1 || cfg.Grpc.MethodTimeouts[0].Method != "/user.User/Export" || cfg.Grpc.MethodTimeouts[0].Timeout != 60 {
+		t.Fatalf("method timeouts = %+v", cfg.Grpc.MethodTimeouts)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cases := map[string]*Config{
+		"no server":     {},
+		"bad addr":      {Grpc: &ServerConfig{Addr: "nohost:port"}},
+		"bad level":     {Grpc: &ServerConfig{Addr: ":0"}, Log: log.Config{Level: "loud"}},
+		"no endpoints":  {Grpc: &ServerConfig{Addr: ":0"}, Register: &RegisterConfig{}},
+		"bad admin":     {Grpc: &ServerConfig{Addr: ":0"}, Admin: &AdminConfig{Addr: "x"}},
+		"public admin":  {Grpc: &ServerConfig{Addr: ":0"}, Admin: &AdminConfig{Addr: "0.0.0.0:6060"}},
+		"remote w/o ep": {Grpc: &ServerConfig{Addr: ":0"}, Remote: &RemoteConfig{}},
+	}
+	for name, cfg := range cases {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if err := (&Config{Http: &ServerConfig{Addr: ":0"}}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManagerRegistration(t *testing.T) {
+	path := writeConfig(t, testConfig)
+	defer os.Remove(path)
+	c, cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewApplicationManagerWithConfig(c, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Grpc.GetListener().Close()
+	defer a.Http.GetListener().Close()
+	defer a.Admin.Stop()
+	if err := a.Grpc.NewServ(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Http.NewServ(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.Grpc.GetEngine().GetServiceInfo()[health.GRPC_SERVICE]; !ok {
+		t.Fatal("no grpc health service")
+	}
+	a.Config.Register = &RegisterConfig{Prefix: "/svc", Ip: "10.0.0.1"}
+
+	s, err := a.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := a.Grpc.GetListener().Addr().(*net.TCPAddr).Port
+	if want := "/svc/user/10.0.0.1:" + strconv.Itoa(port); s.Key != want {
+		t.Fatalf("key = %s, want %s", s.Key, want)
+	}
+
+	_ = os.Setenv(grace.ENV_WORKER, "2")
+	defer os.Unsetenv(grace.ENV_WORKER)
+	if s, err = a.Service(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(s.Key, "#2") || !strings.Contains(s.Value, `"worker":"2"`) {
+		t.Fatalf("worker registration = %s %s", s.Key, s.Value)
+	}
+}
+
+func TestAdminAddr(t *testing.T) {
+	addr, err := (&AdminConfig{Addr: ":6060"}).addr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !addr.IP.IsLoopback() || addr.Port != 6060 {
+		t.Fatalf("addr = %s", addr)
+	}
+	if _, err = (&AdminConfig{Addr: "0.0.0.0:6060", Token: "t"}).addr(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddListsDBsInAdmin(t *testing.T) {
+	n, err := fake.NewNode(&dao.DBConfig{Name: "orders", Master: &dao.NodeConfig{Addr: "m"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	a := &ApplicationManager{
+		Config: &Config{},
+		Logger: &log.Logger{Logger: zap.NewNop(), Level: zap.NewAtomicLevel()},
+	}
+	if err = a.newAdmin(&AdminConfig{Addr: "127.0.0.1:0", Token: "t"}); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Admin.GetListener().Close()
+	go func() {
+		_ = a.Admin.StartServ()
+	}()
+	defer a.Admin.Stop()
+
+	get := func() string {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+a.Admin.GetListener().Addr().String()+"/admin/db", nil)
+		req.Header.Set("Authorization", "Bearer t")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return string(body)
+	}
+	remove := a.Add(n.Node)
+	if body := get(); !strings.Contains(body, `"orders"`) {
+		t.Fatalf("db stats = %s", body)
+	}
+	remove()
+	if body := get(); strings.Contains(body, `"orders"`) {
+		t.Fatalf("removed db listed: %s", body)
+	}
+}
+
+func TestManagerClosesOnFailure(t *testing.T) {
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcAddr := free.Addr().String()
+	_ = free.Close()
+	//http的端口被占用, 构建失败
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	cfg := &Config{
+		Name: "user",
+		Log:  log.Config{Level: "info", Encoding: "json", OutputPaths: []string{"stdout"}},
+		Grpc: &ServerConfig{Addr: grpcAddr},
+		Http: &ServerConfig{Addr: busy.Addr().String()},
+	}
+	if _, err = NewApplicationManagerWithConfig(nil, cfg); err == nil {
+		t.Fatal("listened on a busy port")
+	}
+	lis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		t.Fatalf("grpc listener left open: %s", err)
+	}
+	_ = lis.Close()
+}
@@ -0,0 +1,76 @@
+package application
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"sort"
+)
+
+// Build info, set at link time, e.g.
+//
+//	go build -ldflags "-X github.com/joselee214/j7f/components/application.Version=1.2.0 \
+//		-X github.com/joselee214/j7f/components/application.GitCommit=$(git rev-parse HEAD) \
+//		-X github.com/joselee214/j7f/components/application.BuildTime=$(date +%FT%T%z)"
+var (
+	Version   = "dev"
+	GitCommit = ""
+	BuildTime = ""
+)
+
+// BuildInfo describes the running binary
+type BuildInfo struct {
+	Name      string            `json:"name"`
+	Version   string            `json:"version"`
+	GitCommit string            `json:"git_commit"`
+	BuildTime string            `json:"build_time"`
+	GoVersion string            `json:"go_version"`
+	Platform  string            `json:"platform"`
+	Module    string            `json:"module"`
+	Deps      map[string]string `json:"deps,omitempty"`
+}
+
+// GetBuildInfo returns the link time info and the modules of the binary
+func GetBuildInfo(name string) *BuildInfo {
+	info := &BuildInfo{
+		Name:      name,
+		Version:   Version,
+		GitCommit: GitCommit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.Module = bi.Main.Path + "@" + bi.Main.Version
+		info.Deps = make(map[string]string, len(bi.Deps))
+		for _, dep := range bi.Deps {
+			info.Deps[dep.Path] = dep.Version
+		}
+	}
+	return info
+}
+
+func (b *BuildInfo) Print(w io.Writer, verbose bool) {
+	_, _ = fmt.Fprintf(w, "%s %s\n", b.Name, b.Version)
+	if b.GitCommit != "" {
+		_, _ = fmt.Fprintf(w, "  commit:   %s\n", b.GitCommit)
+	}
+	if b.BuildTime != "" {
+		_, _ = fmt.Fprintf(w, "  built:    %s\n", b.BuildTime)
+	}
+	_, _ = fmt.Fprintf(w, "  go:       %s %s\n", b.GoVersion, b.Platform)
+	if b.Module != "" {
+		_, _ = fmt.Fprintf(w, "  module:   %s\n", b.Module)
+	}
+	if verbose {
+		paths := make([]string, 0, len(b.Deps))
+		for path := range b.Deps {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			_, _ = fmt.Fprintf(w, "  dep:      %s@%s\n", path, b.Deps[path])
+		}
+	}
+}
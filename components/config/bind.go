@@ -0,0 +1,277 @@
+package config
+
+import (
+	"fmt"
+	"github.com/joselee214/j7f/components/errors"
+	"github.com/mitchellh/mapstructure"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tags read by Bind:
+//
+//	default:"1s"        the value of a key not set
+//	min:"100ms"         the minimum of a number or a duration, the minimum length of a string, a slice or a map
+//	max:"5m"            the maximum, likewise
+//	required:"true"     the value must not be zero
+//	enum:"json,console" the allowed values
+//
+// the keys are the json names of the fields, or their lowercased names
+const (
+	TAG_DEFAULT  = "default"
+	TAG_MIN      = "min"
+	TAG_MAX      = "max"
+	TAG_REQUIRED = "required"
+	TAG_ENUM     = "enum"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// FieldError is a violation of the constraints of the value of Key
+type FieldError struct {
+	Key string
+	Msg string
+}
+
+func (e *FieldError) Error() string {
+	return e.Key + ": " + e.Msg
+}
+
+// Bind unmarshals the section key, the whole config when "", into out, a pointer to a struct,
+// applies its default tags and checks its constraints. All the violations are returned at
+// once in an *errors.MultiError of *FieldError, e.g.
+//
+//	cfg := &mq.Config{}
+//	err := c.Bind("mq", cfg)
+func (c *Configer) Bind(key string, out interface{}) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: Bind %s: out must be a pointer to a struct, not %T", key, out)
+	}
+
+	// the section of AllSettings, not of UnmarshalKey, which misses the values under the overridden ones
+	var input interface{} = c.AllSettings()
+	if key != "" {
+		input = lookup(input.(map[string]interface{}), strings.ToLower(key))
+	}
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		WeaklyTypedInput: true,
+		TagName:          "json",
+		Result:           out,
+	})
+	if err == nil {
+		err = dec.Decode(input)
+	}
+	if err != nil {
+		return fmt.Errorf("config: %s: %s", key, err)
+	}
+
+	errs := errors.NewMultiError()
+	c.bindStruct(key, v.Elem(), errs)
+	return errs.ErrorOrNil()
+}
+
+func (c *Configer) bindStruct(prefix string, v reflect.Value, errs *errors.MultiError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := fieldKey(f)
+		if name == "" {
+			continue
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		c.bindField(key, f, v.Field(i), errs)
+	}
+}
+
+func (c *Configer) bindField(key string, f reflect.StructField, fv reflect.Value, errs *errors.MultiError) {
+	if def, ok := f.Tag.Lookup(TAG_DEFAULT); ok && fv.IsZero() && !c.IsSet(key) {
+		dv, err := parseValue(fv.Type(), def)
+		if err != nil {
+			errs.Append(&FieldError{Key: key, Msg: fmt.Sprintf("invalid default %q: %s", def, err)})
+			return
+		}
+		fv.Set(dv)
+	}
+
+	checkField(key, f, fv, c.IsSecret(key), errs)
+
+	switch {
+	case fv.Kind() == reflect.Struct && fv.Type() != reflect.TypeOf(time.Time{}):
+		c.bindStruct(key, fv, errs)
+	case fv.Kind() == reflect.Ptr && !fv.IsNil() && fv.Elem().Kind() == reflect.Struct:
+		c.bindStruct(key, fv.Elem(), errs)
+	case fv.Kind() == reflect.Slice:
+		for i := 0; i < fv.Len(); i++ {
+			ev := fv.Index(i)
+			if ev.Kind() == reflect.Ptr && !ev.IsNil() {
+				ev = ev.Elem()
+			}
+			if ev.Kind() == reflect.Struct {
+				c.bindStruct(key+"["+strconv.Itoa(i)+"]", ev, errs)
+			}
+		}
+	}
+}
+
+// checkField checks the required, enum, min and max tags of fv, the values of the secrets are not shown
+func checkField(key string, f reflect.StructField, fv reflect.Value, secret bool, errs *errors.MultiError) {
+	if required, _ := strconv.ParseBool(f.Tag.Get(TAG_REQUIRED)); required && fv.IsZero() {
+		errs.Append(&FieldError{Key: key, Msg: "is required"})
+		return
+	}
+
+	if enum, ok := f.Tag.Lookup(TAG_ENUM); ok && !fv.IsZero() {
+		s := fmt.Sprint(fv.Interface())
+		if secret {
+			s = REDACTED
+		}
+		allowed := strings.Split(enum, ",")
+		found := false
+		for _, a := range allowed {
+			if strings.TrimSpace(a) == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs.Append(&FieldError{Key: key, Msg: fmt.Sprintf("%q is not one of %s", s, strings.Join(allowed, ", "))})
+		}
+	}
+
+	for _, tag := range []string{TAG_MIN, TAG_MAX} {
+		bound, ok := f.Tag.Lookup(tag)
+		if !ok {
+			continue
+		}
+		if err := checkBound(tag, fv, bound, secret); err != nil {
+			errs.Append(&FieldError{Key: key, Msg: err.Error()})
+		}
+	}
+}
+
+// checkBound compares fv, or its length, to the min or max bound
+func checkBound(tag string, fv reflect.Value, bound string, secret bool) error {
+	var value, limit float64
+	var shown interface{}
+	switch fv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		n, err := strconv.Atoi(bound)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %s", tag, bound, err)
+		}
+		value, limit, shown = float64(fv.Len()), float64(n), fmt.Sprintf("length %d", fv.Len())
+	default:
+		bv, err := parseValue(fv.Type(), bound)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %s", tag, bound, err)
+		}
+		if value, err = toFloat(fv); err != nil {
+			return err
+		}
+		limit, _ = toFloat(bv)
+		shown = fv.Interface()
+	}
+	if secret {
+		shown = REDACTED
+	}
+
+	if tag == TAG_MIN && value < limit {
+		return fmt.Errorf("%v is less than the min %s", shown, bound)
+	}
+	if tag == TAG_MAX && value > limit {
+		return fmt.Errorf("%v is more than the max %s", shown, bound)
+	}
+	return nil
+}
+
+func toFloat(v reflect.Value) (float64, error) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	}
+	return 0, fmt.Errorf("min and max do not apply to %s", v.Type())
+}
+
+// parseValue parses s, a tag value, as a value of type t
+func parseValue(t reflect.Type, s string) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+	if t == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return v, err
+		}
+		v.SetInt(int64(d))
+		return v, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return v, err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, t.Bits())
+		if err != nil {
+			return v, err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if t.Elem().Kind() != reflect.String {
+			return v, fmt.Errorf("unsupported type %s", t)
+		}
+		parts := strings.Split(s, ",")
+		sv := reflect.MakeSlice(t, 0, len(parts))
+		for _, p := range parts {
+			sv = reflect.Append(sv, reflect.ValueOf(strings.TrimSpace(p)).Convert(t.Elem()))
+		}
+		v.Set(sv)
+	default:
+		return v, fmt.Errorf("unsupported type %s", t)
+	}
+	return v, nil
+}
+
+// fieldKey is the config key of f, "" when skipped
+func fieldKey(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		name = f.Name
+	}
+	return strings.ToLower(name)
+}
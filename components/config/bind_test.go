@@ -0,0 +1,107 @@
+package config
+
+import (
+	"bytes"
+	"github.com/joselee214/j7f/components/errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bindServer struct {
+	Addr     string        `json:"addr" required:"true"`
+	Timeout  time.Duration `json:"timeout" default:"1s" min:"100ms" max:"1m"`
+	Encoding string        `json:"encoding" default:"json" enum:"json,console"`
+	Workers  int           `json:"workers" default:"4" min:"1"`
+	Tags     []string      `json:"tags" default:"a,b"`
+	Password string        `json:"password" min:"8"`
+}
+
+type bindConfig struct {
+	Server  bindServer    `json:"server"`
+	Backups []*bindServer `json:"backups"`
+}
+
+func newYamlConfiger(t *testing.T, yaml string) *Configer {
+	c := NewViper()
+	c.SetConfigType("yaml")
+	if err := c.ReadConfig(bytes.NewBufferString(yaml)); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestBindDefaults(t *testing.T) {
+	c := newYamlConfiger(t, `
+server:
+  addr: ":9000"
+  workers: 0
+  password: longenough
+`)
+	cfg := &bindConfig{}
+	err := c.Bind("", cfg)
+	if err == nil || !strings.Contains(err.Error(), "server.workers") {
+		t.Fatalf("explicit 0 below the min: %v", err)
+	}
+
+	c.Set("server.workers", 2)
+	cfg = &bindConfig{}
+	if err = c.Bind("", cfg); err != nil {
+		t.Fatal(err)
+	}
+	s := cfg.Server
+	if s.Addr != ":9000" || s.Timeout != time.Second || s.Encoding != "json" || s.Workers != 2 {
+		t.Fatalf("server = %+v", s)
+	}
+	if strings.Join(s.Tags, ",") != "a,b" {
+		t.Fatalf("tags = %v", s.Tags)
+	}
+}
+
+func TestBindSection(t *testing.T) {
+	c := newYamlConfiger(t, `
+server:
+  addr: ":9000"
+  timeout: 5s
+  password: longenough
+`)
+	s := &bindServer{}
+	if err := c.Bind("server", s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Timeout != 5*time.Second || s.Workers != 4 {
+		t.Fatalf("server = %+v", s)
+	}
+	if err := c.Bind("server", *s); err == nil {
+		t.Fatal("non pointer accepted")
+	}
+}
+
+func TestBindReportsAllViolations(t *testing.T) {
+	c := newYamlConfiger(t, `
+server:
+  timeout: 2m
+  encoding: xml
+  password: short
+backups:
+  - {addr: ":9001", timeout: 1ms}
+`)
+	err := c.Bind("", &bindConfig{})
+	multi, ok := err.(*errors.MultiError)
+	if !ok {
+		t.Fatalf("err = %T %v", err, err)
+	}
+	keys := make(map[string]string)
+	for _, e := range multi.Errors() {
+		fe := e.(*FieldError)
+		keys[fe.Key] = fe.Msg
+	}
+	for _, key := range []string{"server.addr", "server.timeout", "server.encoding", "server.password", "backups[0].timeout"} {
+		if _, ok := keys[key]; !ok {
+			t.Errorf("no error for %s in %v", key, keys)
+		}
+	}
+	if strings.Contains(keys["server.password"], "short") || !strings.Contains(keys["server.password"], REDACTED) {
+		t.Fatalf("secret shown: %s", keys["server.password"])
+	}
+}
@@ -1,11 +1,31 @@
 package config
 
 import (
+	flag "github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"sync"
+	"sync/atomic"
 )
 
 type Configer struct {
+	// Viper holds the local layers, the remote values are only in the snapshot, see sync.go
 	*viper.Viper
+	// wl serializes the writes, snap is the *viper.Viper read once published
+	wl     sync.Mutex
+	snap   atomic.Value
+	values map[string]interface{}
+
+	// the sources of the values, see Source
+	l         sync.RWMutex
+	files     map[string]string
+	remote    map[string]string
+	envPrefix string
+	flags     *flag.FlagSet
+
+	// the ENC(...) values, see DecryptAll
+	keyFile string
+	cipher  *Cipher
+	secrets map[string]bool
 }
 
 func NewConfig() (*Configer, error) {
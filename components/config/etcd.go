@@ -0,0 +1,275 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"github.com/joselee214/j7f/internal/log"
+	"go.etcd.io/etcd/clientv3"
+	"reflect"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DEFAULT_ETCD_RETRY_DELAY = time.Second
+
+// EtcdSource merges the keys under Prefix over the config and keeps them in sync.
+// A key is the path of a setting, its value is yaml:
+//
+//	/config/user/mq/read_timeout  10s
+//	/config/user/grpc             {addr: ":9000", processingTimeout: 5}
+//
+// The remote values override the local ones, a deleted key restores the local value.
+// An update is checked by the validators first, on the config it would make, the local layers
+// included, an invalid one is logged and the last good config kept. Then the OnChange callbacks
+// of the changed keys are called, out of the lock of the source.
+//
+// The updates are published at once in a new snapshot, the methods of the Configer read either
+// the config before or after them, see sync.go.
+type EtcdSource struct {
+	Prefix string
+
+	c   *Configer
+	cli *clientv3.Client
+	log log.Logger
+
+	l sync.Mutex
+	// kvs is the state of etcd
+	kvs map[string][]byte
+	rev int64
+
+	validators []func(c *Configer) error
+	subs       []subscription
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type subscription struct {
+	key string
+	f   func(key string, old, new interface{})
+}
+
+// change is a call of a subscription, made once s.l is released
+type change struct {
+	sub      subscription
+	old, new interface{}
+}
+
+func NewEtcdSource(c *Configer, cli *clientv3.Client, prefix string) *EtcdSource {
+	return &EtcdSource{
+		Prefix: strings.TrimSuffix(prefix, "/") + "/",
+		c:      c,
+		cli:    cli,
+		log:    log.NewLoggerDefault(),
+		kvs:    make(map[string][]byte),
+	}
+}
+
+// Validate checks the updates by binding the section key into a new value of the type of out,
+// a pointer to a struct, see Bind
+func (s *EtcdSource) Validate(key string, out interface{}) {
+	t := reflect.TypeOf(out).Elem()
+	s.AddValidator(func(c *Configer) error {
+		return c.Bind(key, reflect.New(t).Interface())
+	})
+}
+
+// AddValidator adds f, called with the config updated, an error rejects the update
+func (s *EtcdSource) AddValidator(f func(c *Configer) error) {
+	s.l.Lock()
+	s.validators = append(s.validators, f)
+	s.l.Unlock()
+}
+
+// OnChange adds f, called when the value of key, a setting or a section, changes
+func (s *EtcdSource) OnChange(key string, f func(key string, old, new interface{})) {
+	s.l.Lock()
+	s.subs = append(s.subs, subscription{key: strings.ToLower(key), f: f})
+	s.l.Unlock()
+}
+
+// Load reads the keys under Prefix and merges them over the config
+func (s *EtcdSource) Load(ctx context.Context) error {
+	resp, err := s.cli.Get(ctx, s.Prefix, clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+
+	s.l.Lock()
+	s.kvs = make(map[string][]byte, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		s.kvs[string(kv.Key)] = kv.Value
+	}
+	s.rev = resp.Header.Revision
+	changes, err := s.apply()
+	s.l.Unlock()
+	notify(changes)
+	return err
+}
+
+// Watch applies the updates of the keys until Close, Load must be called first
+func (s *EtcdSource) Watch() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	go s.watch(ctx)
+}
+
+// Close stops Watch
+func (s *EtcdSource) Close() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+}
+
+func (s *EtcdSource) watch(ctx context.Context) {
+	defer close(s.done)
+	for {
+		s.l.Lock()
+		rev := s.rev
+		s.l.Unlock()
+
+		wch := s.cli.Watch(clientv3.WithRequireLeader(ctx), s.Prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+		for resp := range wch {
+			if err := resp.Err(); err != nil {
+				s.log.Errorf("config: watch %s error: %s", s.Prefix, err)
+				break
+			}
+			s.update(resp.Events, resp.Header.Revision)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(DEFAULT_ETCD_RETRY_DELAY):
+		}
+		//watch中断(如被compact), 全量重新加载
+		if err := s.Load(ctx); err != nil {
+			s.log.Errorf("config: reload %s error: %s", s.Prefix, err)
+		}
+	}
+}
+
+func (s *EtcdSource) update(events []*clientv3.Event, rev int64) {
+	s.l.Lock()
+	for _, ev := range events {
+		if ev.Type == clientv3.EventTypeDelete {
+			delete(s.kvs, string(ev.Kv.Key))
+		} else {
+			s.kvs[string(ev.Kv.Key)] = ev.Kv.Value
+		}
+	}
+	s.rev = rev
+	changes, err := s.apply()
+	s.l.Unlock()
+	if err != nil {
+		s.log.Errorf("config: update of %s rejected, keep the last good config: %s", s.Prefix, err)
+	}
+	notify(changes)
+}
+
+// apply validates the config with the settings of kvs and publishes it if valid, s.l is held.
+// It returns the calls of the subscriptions to make
+func (s *EtcdSource) apply() ([]change, error) {
+	settings, err := s.settings()
+	if err != nil {
+		return nil, err
+	}
+
+	s.c.wl.Lock()
+	v, err := s.c.build(settings)
+	s.c.wl.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	candidate := &Configer{Viper: v, secrets: s.c.secretKeys()}
+	for _, f := range s.validators {
+		if err = f(candidate); err != nil {
+			return nil, err
+		}
+	}
+
+	old := s.c.AllSettings()
+	if err = s.c.setRemote(settings, "etcd "+strings.TrimSuffix(s.Prefix, "/")); err != nil {
+		return nil, err
+	}
+	updated := s.c.AllSettings()
+
+	var changes []change
+	for _, sub := range s.subs {
+		o, n := lookup(old, sub.key), lookup(updated, sub.key)
+		if !reflect.DeepEqual(o, n) {
+			changes = append(changes, change{sub: sub, old: o, new: n})
+		}
+	}
+	return changes, nil
+}
+
+func notify(changes []change) {
+	for _, ch := range changes {
+		ch.sub.f(ch.sub.key, ch.old, ch.new)
+	}
+}
+
+// settings flattens kvs into the settings they set, the deeper keys last
+func (s *EtcdSource) settings() (map[string]interface{}, error) {
+	keys := make([]string, 0, len(s.kvs))
+	for k := range s.kvs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	settings := make(map[string]interface{})
+	for _, k := range keys {
+		var value interface{}
+		if err := yaml.Unmarshal(s.kvs[k], &value); err != nil {
+			return nil, fmt.Errorf("config: %s: %s", k, err)
+		}
+		path := strings.ToLower(strings.Trim(strings.TrimPrefix(k, s.Prefix), "/"))
+		path = strings.Replace(path, "/", ".", -1)
+		flatten(path, value, settings)
+	}
+	for key, value := range settings {
+		plain, err := s.c.decrypt(key, value)
+		if err != nil {
+			return nil, err
+		}
+		settings[key] = plain
+	}
+	return settings, nil
+}
+
+func flatten(prefix string, value interface{}, out map[string]interface{}) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		if prefix != "" {
+			out[prefix] = value
+		}
+		return
+	}
+	for k, v := range m {
+		key := strings.ToLower(k)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		flatten(key, v, out)
+	}
+}
+
+// lookup returns the value of the dotted key in the nested settings
+func lookup(settings map[string]interface{}, key string) interface{} {
+	var value interface{} = settings
+	for _, part := range strings.Split(key, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[part]
+	}
+	return value
+}
@@ -0,0 +1,240 @@
+//go:build !race
+// +build !race
+
+// the embedded etcd trips the checkptr of -race in bbolt
+
+package config
+
+import (
+	"context"
+	"errors"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/embed"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// freeURL is an url on a port free when called
+func freeURL(t *testing.T) *url.URL {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return &url.URL{Scheme: "http", Host: lis.Addr().String()}
+}
+
+func startEtcd(t *testing.T) (*clientv3.Client, func()) {
+	dir, err := ioutil.TempDir("", "etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	lc, lp := freeURL(t), freeURL(t)
+	cfg.LCUrls, cfg.ACUrls = []url.URL{*lc}, []url.URL{*lc}
+	cfg.LPUrls, cfg.APUrls = []url.URL{*lp}, []url.URL{*lp}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		t.Skip(err)
+	}
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		e.Close()
+		_ = os.RemoveAll(dir)
+		t.Fatal("etcd not ready")
+	}
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{lc.Host}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cli, func() {
+		_ = cli.Close()
+		e.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func waitFor(t *testing.T, what string, f func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEtcdSource(t *testing.T) {
+	cli, stop := startEtcd(t)
+	defer stop()
+	ctx := context.Background()
+
+	c := newYamlConfiger(t, `
+grpc:
+  addr: ":9000"
+  timeout: 5
+`)
+	if _, err := cli.Put(ctx, "/config/t/grpc/timeout", "10"); err != nil {
+		t.Fatal(err)
+	}
+	s := NewEtcdSource(c, cli, "/config/t")
+	s.AddValidator(func(c *Configer) error {
+		if c.GetInt("grpc.timeout") <= 0 {
+			return errors.New("grpc.timeout must be positive")
+		}
+		return nil
+	})
+	var l sync.Mutex
+	changes := make([]interface{}, 0)
+	s.OnChange("grpc.timeout", func(key string, old, new interface{}) {
+		l.Lock()
+		changes = append(changes, new)
+		l.Unlock()
+	})
+	if err := s.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if c.GetInt("grpc.timeout") != 10 || c.GetString("grpc.addr") != ":9000" {
+		t.Fatalf("settings = %v", c.AllSettings())
+	}
+	if src := c.Source("grpc.timeout"); src != "etcd /config/t" {
+		t.Fatalf("source = %s", src)
+	}
+	s.Watch()
+	defer s.Close()
+
+	if _, err := cli.Put(ctx, "/config/t/grpc/timeout", "20"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the update", func() bool {
+		return c.GetInt("grpc.timeout") == 20
+	})
+
+	//非法的更新被拒绝, 保留上一个合法配置
+	if _, err := cli.Put(ctx, "/config/t/grpc/timeout", "-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cli.Put(ctx, "/config/t/grpc/addr", `":9100"`); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if c.GetInt("grpc.timeout") != 20 || c.GetString("grpc.addr") != ":9000" {
+		t.Fatalf("invalid update applied: %v", c.AllSettings())
+	}
+
+	if _, err := cli.Delete(ctx, "/config/t/", clientv3.WithPrefix()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the local value", func() bool {
+		return c.GetInt("grpc.timeout") == 5
+	})
+
+	l.Lock()
+	defer l.Unlock()
+	if len(changes) != 3 {
+		t.Fatalf("changes = %v", changes)
+	}
+}
+
+func TestEtcdSourceConcurrentReads(t *testing.T) {
+	cli, stop := startEtcd(t)
+	defer stop()
+	ctx := context.Background()
+
+	c := newYamlConfiger(t, `
+db:
+  password: hunter2
+`)
+	s := NewEtcdSource(c, cli, "/config/r")
+	if err := s.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.Watch()
+	defer s.Close()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				_ = c.Redacted()
+				_ = c.GetInt("mq.timeout")
+			}
+		}()
+	}
+	for i := 1; i <= 50; i++ {
+		if _, err := cli.Put(ctx, "/config/r/mq", "{timeout: "+strconv.Itoa(i)+"}"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the updates", func() bool {
+		return c.GetInt("mq.timeout") == 50
+	})
+	close(done)
+	wg.Wait()
+}
+
+func TestEtcdSourceValidatesLocalLayers(t *testing.T) {
+	cli, stop := startEtcd(t)
+	defer stop()
+	ctx := context.Background()
+
+	c := newYamlConfiger(t, `
+grpc:
+  timeout: 5
+  max: 100
+`)
+	s := NewEtcdSource(c, cli, "/config/v")
+	s.AddValidator(func(c *Configer) error {
+		if c.GetInt("grpc.timeout") > c.GetInt("grpc.max") {
+			return errors.New("grpc.timeout over grpc.max")
+		}
+		return nil
+	})
+	//回调里再调用source不能死锁
+	reloaded := make(chan error, 1)
+	s.OnChange("grpc.timeout", func(key string, old, new interface{}) {
+		reloaded <- s.Load(ctx)
+	})
+	if err := s.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Set("grpc.max", 8)
+	if _, err := cli.Put(ctx, "/config/v/grpc/timeout", "10"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Load(ctx); err == nil || c.GetInt("grpc.timeout") != 5 {
+		t.Fatalf("update over the local grpc.max applied: %v", err)
+	}
+
+	if _, err := cli.Put(ctx, "/config/v/grpc/timeout", "7"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-reloaded; err != nil {
+		t.Fatal(err)
+	}
+	if c.GetInt("grpc.timeout") != 7 || c.GetInt("grpc.max") != 8 {
+		t.Fatalf("settings = %v", c.AllSettings())
+	}
+}
@@ -0,0 +1,210 @@
+package config
+
+import (
+	"fmt"
+	flag "github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	SOURCE_DEFAULT = "default"
+	SOURCE_ENV     = "env"
+	SOURCE_FLAG    = "flag"
+)
+
+// LoadOptions are the layers of a config, by increasing precedence:
+//
+//	defaults    SetDefault
+//	File        config.yaml
+//	Env         config.<env>.yaml next to File, when it exists
+//	EnvPrefix   <PREFIX>_<KEY>, the key upper-cased with "." replaced by "_", e.g. APP_GRPC_ADDR
+//	Flags       the flags set named like the keys, e.g. --grpc.addr=:9001
+//	remote      an EtcdSource
+//
+// The ENC(...) values of all the layers are decrypted with the key of KeyFile, see LoadKey
+type LoadOptions struct {
+	File      string
+	Env       string
+	EnvPrefix string
+	Flags     *flag.FlagSet
+	KeyFile   string
+}
+
+// EnvFile is the overlay of file for env, e.g. config.prod.yaml for config.yaml
+func EnvFile(file, env string) string {
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "." + env + ext
+}
+
+// Load reads the layers of opts, see Source for the layer of a value
+func Load(opts *LoadOptions) (*Configer, error) {
+	c := NewViper()
+	c.files = make(map[string]string)
+	c.keyFile = opts.KeyFile
+
+	if opts.File != "" {
+		c.SetConfigFile(opts.File)
+		settings, err := c.readFile(opts.File, nil)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Env != "" {
+			overlay := EnvFile(opts.File, opts.Env)
+			if _, err = os.Stat(overlay); err == nil {
+				if settings, err = c.readFile(overlay, settings); err != nil {
+					return nil, err
+				}
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		if err = c.Viper.MergeConfigMap(settings); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.EnvPrefix != "" {
+		c.envPrefix = opts.EnvPrefix
+		c.SetEnvPrefix(opts.EnvPrefix)
+		c.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+		c.AutomaticEnv()
+	}
+
+	if opts.Flags != nil {
+		c.flags = opts.Flags
+		var err error
+		opts.Flags.Visit(func(f *flag.Flag) {
+			if e := c.BindPFlag(f.Name, f); e != nil && err == nil {
+				err = e
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the files are decrypted already, the env vars and the flags left
+	if err := c.DecryptAll(); err != nil {
+		return nil, err
+	}
+	//发布所有层的快照, 之后只读快照
+	c.wl.Lock()
+	defer c.wl.Unlock()
+	if err := c.publish(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DecryptAll decrypts the ENC(...) values, setting the plain ones over them
+func (c *Configer) DecryptAll() error {
+	for _, key := range c.AllKeys() {
+		value := c.Get(key)
+		plain, err := c.decrypt(key, value)
+		if err != nil {
+			return err
+		}
+		if s, ok := value.(string); ok && IsEncrypted(s) {
+			c.Set(key, plain)
+		}
+	}
+	return nil
+}
+
+// readFile reads path over settings, recording it as the source of its values
+func (c *Configer) readFile(path string, settings map[string]interface{}) (map[string]interface{}, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("config: %s: %s", path, err)
+	}
+	if settings == nil {
+		settings = make(map[string]interface{})
+	}
+	for _, key := range v.AllKeys() {
+		value, err := c.decrypt(key, v.Get(key))
+		if err != nil {
+			return nil, err
+		}
+		set(settings, key, value)
+		c.files[key] = path
+	}
+	return settings, nil
+}
+
+// set sets the dotted key in the nested settings, replacing the values in the way
+func set(settings map[string]interface{}, key string, value interface{}) {
+	parts := strings.Split(key, ".")
+	m := settings
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			m[part] = next
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = value
+}
+
+// setRemote sets the values of an EtcdSource over the local layers, recording source as theirs
+func (c *Configer) setRemote(values map[string]interface{}, source string) error {
+	c.wl.Lock()
+	defer c.wl.Unlock()
+	v, err := c.build(values)
+	if err != nil {
+		return err
+	}
+	c.values = values
+	c.snap.Store(v)
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.remote = make(map[string]string, len(values))
+	for key := range values {
+		c.remote[key] = source
+	}
+	return nil
+}
+
+// Source tells where the value of key comes from: an EtcdSource, a flag, an env var, a file or default
+func (c *Configer) Source(key string) string {
+	key = strings.ToLower(key)
+	c.l.RLock()
+	remote, ok := c.remote[key]
+	c.l.RUnlock()
+	if ok {
+		return remote
+	}
+	if c.flags != nil {
+		if f := c.flags.Lookup(key); f != nil && f.Changed {
+			return SOURCE_FLAG + " --" + key
+		}
+	}
+	if c.envPrefix != "" {
+		name := strings.ToUpper(c.envPrefix + "_" + strings.Replace(key, ".", "_", -1))
+		if _, ok := os.LookupEnv(name); ok {
+			return SOURCE_ENV + " " + name
+		}
+	}
+	if file, ok := c.files[key]; ok {
+		return file
+	}
+	c.wl.Lock()
+	defer c.wl.Unlock()
+	if c.ConfigFileUsed() != "" && c.InConfig(key) {
+		return c.ConfigFileUsed()
+	}
+	return SOURCE_DEFAULT
+}
+
+// Sources are the sources of all the keys
+func (c *Configer) Sources() map[string]string {
+	sources := make(map[string]string)
+	for _, key := range c.AllKeys() {
+		sources[key] = c.Source(key)
+	}
+	return sources
+}
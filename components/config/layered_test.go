@@ -0,0 +1,62 @@
+package config
+
+import (
+	flag "github.com/spf13/pflag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layered")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.yaml")
+	write := func(path, content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(file, "name: user\ngrpc:\n  addr: \":9000\"\n  timeout: 5\nlog:\n  level: info\n")
+	write(EnvFile(file, "prod"), "grpc:\n  timeout: 10\nlog:\n  level: warn\n")
+
+	_ = os.Setenv("LAYERED_LOG_LEVEL", "error")
+	defer os.Unsetenv("LAYERED_LOG_LEVEL")
+	fs := flag.NewFlagSet("set", flag.ContinueOnError)
+	fs.String("grpc.addr", "", "")
+	if err = fs.Set("grpc.addr", ":9100"); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(&LoadOptions{File: file, Env: "prod", EnvPrefix: "LAYERED", Flags: fs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		key, value, source string
+	}{
+		{"name", "user", file},
+		{"grpc.timeout", "10", EnvFile(file, "prod")},
+		{"log.level", "error", "env LAYERED_LOG_LEVEL"},
+		{"grpc.addr", ":9100", "flag --grpc.addr"},
+	}
+	for _, cs := range cases {
+		if v := c.GetString(cs.key); v != cs.value {
+			t.Errorf("%s = %s, want %s", cs.key, v, cs.value)
+		}
+		if s := c.Source(cs.key); s != cs.source {
+			t.Errorf("source of %s = %s, want %s", cs.key, s, cs.source)
+		}
+	}
+
+	//没有overlay文件时只读主文件
+	if c, err = Load(&LoadOptions{File: file, Env: "dev"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.GetInt("grpc.timeout") != 5 {
+		t.Fatalf("grpc.timeout = %d", c.GetInt("grpc.timeout"))
+	}
+}
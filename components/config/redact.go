@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"github.com/joselee214/j7f/components/secret"
+)
+
+const REDACTED = "******"
+
+// Redact returns a copy of settings, e.g. AllSettings(), with the secret values replaced by REDACTED
+func Redact(settings map[string]interface{}) map[string]interface{} {
+	return redact(settings).(map[string]interface{})
+}
+
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, vv := range v {
+			if secret.IsKey(k) && vv != nil {
+				m[k] = REDACTED
+				continue
+			}
+			m[k] = redact(vv)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, vv := range v {
+			ks := fmt.Sprint(k)
+			if secret.IsKey(ks) && vv != nil {
+				m[ks] = REDACTED
+				continue
+			}
+			m[ks] = redact(vv)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, vv := range v {
+			s[i] = redact(vv)
+		}
+		return s
+	default:
+		return v
+	}
+}
@@ -0,0 +1,234 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/joselee214/j7f/components/secret"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// the key of the ENC(...) values, base64 of 16, 24 or 32 bytes, or the file holding it
+const (
+	ENV_CONFIG_KEY      = "J7F_CONFIG_KEY"
+	ENV_CONFIG_KEY_FILE = "J7F_CONFIG_KEY_FILE"
+)
+
+const (
+	ENC_PREFIX = "ENC("
+	ENC_SUFFIX = ")"
+)
+
+var ErrNoKey = errors.New("config: no key to decrypt, set " + ENV_CONFIG_KEY + " or " + ENV_CONFIG_KEY_FILE)
+
+// Cipher encrypts the secrets of a config into ENC(<base64 of nonce and AES-GCM ciphertext>)
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+func NewCipher(key []byte) (*Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// GenerateKey returns a new base64 AES-256 key
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// LoadKey reads the key of ENV_CONFIG_KEY, else of file, else of the ENV_CONFIG_KEY_FILE file
+func LoadKey(file string) ([]byte, error) {
+	text := os.Getenv(ENV_CONFIG_KEY)
+	if text == "" {
+		if file == "" {
+			file = os.Getenv(ENV_CONFIG_KEY_FILE)
+		}
+		if file == "" {
+			return nil, ErrNoKey
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("config: key file: %s", err)
+		}
+		text = string(data)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil {
+		return nil, fmt.Errorf("config: key: %s", err)
+	}
+	return key, nil
+}
+
+// IsEncrypted tells if value is ENC(...)
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, ENC_PREFIX) && strings.HasSuffix(value, ENC_SUFFIX)
+}
+
+func (c *Cipher) Encrypt(plain string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(plain), nil)
+	return ENC_PREFIX + base64.StdEncoding.EncodeToString(sealed) + ENC_SUFFIX, nil
+}
+
+func (c *Cipher) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(value[len(ENC_PREFIX) : len(value)-len(ENC_SUFFIX)])
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < c.aead.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	nonce := sealed[:c.aead.NonceSize()]
+	plain, err := c.aead.Open(nil, nonce, sealed[len(nonce):], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// decrypt decrypts the ENC(...) strings of value, a setting of key, the cipher is loaded
+// on the first one. The maps in the lists are decrypted too, e.g. the passwords of db.<name>.slave,
+// their secrets are recorded under the key of the list joined with their own, db.<name>.slave.password
+func (c *Configer) decrypt(key string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if !IsEncrypted(v) {
+			return v, nil
+		}
+		c.l.Lock()
+		defer c.l.Unlock()
+		if c.cipher == nil {
+			k, err := LoadKey(c.keyFile)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+			if c.cipher, err = NewCipher(k); err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+		}
+		plain, err := c.cipher.Decrypt(v)
+		if err != nil {
+			return nil, fmt.Errorf("config: %s: decrypt: %s", key, err)
+		}
+		if c.secrets == nil {
+			c.secrets = make(map[string]bool)
+		}
+		c.secrets[key] = true
+		return plain, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			d, err := c.decrypt(key, e)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = d
+		}
+		return out, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			d, err := c.decrypt(key+"."+strings.ToLower(k), e)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = d
+		}
+		return out, nil
+	case map[interface{}]interface{}:
+		out := make(map[interface{}]interface{}, len(v))
+		for k, e := range v {
+			d, err := c.decrypt(key+"."+strings.ToLower(fmt.Sprint(k)), e)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = d
+		}
+		return out, nil
+	case []string:
+		out := make([]string, len(v))
+		for i, e := range v {
+			d, err := c.decrypt(key, e)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = d.(string)
+		}
+		return out, nil
+	}
+	return value, nil
+}
+
+// IsSecret tells if the value of key was encrypted, or if key names a secret, see secret.IsKey
+func (c *Configer) IsSecret(key string) bool {
+	key = strings.ToLower(key)
+	c.l.RLock()
+	defer c.l.RUnlock()
+	return c.secrets[key] || secret.IsKey(key)
+}
+
+// secretKeys is a copy of the keys of the ENC(...) values
+func (c *Configer) secretKeys() map[string]bool {
+	c.l.RLock()
+	defer c.l.RUnlock()
+	keys := make(map[string]bool, len(c.secrets))
+	for key := range c.secrets {
+		keys[key] = true
+	}
+	return keys
+}
+
+// Redacted is AllSettings with the secrets replaced by REDACTED, for the dumps and the logs
+func (c *Configer) Redacted() map[string]interface{} {
+	settings := Redact(c.AllSettings())
+	c.l.RLock()
+	defer c.l.RUnlock()
+	for key := range c.secrets {
+		redactPath(settings, strings.Split(key, "."))
+	}
+	return settings
+}
+
+// redactPath replaces the value at path, the lists on the way have it replaced in each element
+func redactPath(v interface{}, path []string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, next := range v {
+			if !strings.EqualFold(k, path[0]) || next == nil {
+				continue
+			}
+			if len(path) == 1 {
+				v[k] = REDACTED
+			} else {
+				redactPath(next, path[1:])
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			redactPath(e, path)
+		}
+	}
+}
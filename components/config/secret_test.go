@@ -0,0 +1,118 @@
+package config
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newTestCipher(t *testing.T) (*Cipher, string) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := base64.StdEncoding.DecodeString(key)
+	c, err := NewCipher(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, key
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	c, _ := newTestCipher(t)
+	enc, err := c.Encrypt("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(enc) || strings.Contains(enc, "hunter2") {
+		t.Fatalf("enc = %s", enc)
+	}
+	again, _ := c.Encrypt("hunter2")
+	if again == enc {
+		t.Fatal("same ciphertext twice, the nonce is not random")
+	}
+	plain, err := c.Decrypt(enc)
+	if err != nil || plain != "hunter2" {
+		t.Fatalf("plain = %s, %v", plain, err)
+	}
+	if plain, _ = c.Decrypt("clear"); plain != "clear" {
+		t.Fatalf("plain value = %s", plain)
+	}
+
+	other, _ := newTestCipher(t)
+	if _, err = other.Decrypt(enc); err == nil {
+		t.Fatal("decrypted with another key")
+	}
+	if _, err = c.Decrypt(enc[:len(enc)-6] + "AAAA)"); err == nil {
+		t.Fatal("tampered ciphertext decrypted")
+	}
+}
+
+func TestLoadDecryptsNestedValues(t *testing.T) {
+	c, key := newTestCipher(t)
+	_ = os.Setenv(ENV_CONFIG_KEY, key)
+	defer os.Unsetenv(ENV_CONFIG_KEY)
+	enc := func(s string) string {
+		e, err := c.Encrypt(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	f, err := ioutil.TempFile("", "secret-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	_, _ = f.WriteString(`
+db:
+  orders:
+    master: {addr: m, password: "` + enc("master-pw") + `"}
+    slave:
+      - {addr: s1, password: "` + enc("slave-pw") + `"}
+      - {addr: s2, dsn: "` + enc("user:dsn-pw@s2") + `"}
+`)
+	_ = f.Close()
+
+	cfg, err := Load(&LoadOptions{File: f.Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw := cfg.GetString("db.orders.master.password"); pw != "master-pw" {
+		t.Fatalf("master password = %s", pw)
+	}
+	slaves := cfg.Get("db.orders.slave").([]interface{})
+	get := func(i int, k string) string {
+		switch m := slaves[i].(type) {
+		case map[string]interface{}:
+			return m[k].(string)
+		case map[interface{}]interface{}:
+			return m[k].(string)
+		}
+		t.Fatalf("slave %d = %T", i, slaves[i])
+		return ""
+	}
+	if get(0, "password") != "slave-pw" || get(1, "dsn") != "user:dsn-pw@s2" || get(1, "addr") != "s2" {
+		t.Fatalf("slaves = %v", slaves)
+	}
+	if !cfg.IsSecret("db.orders.slave.dsn") {
+		t.Fatal("encrypted dsn not recorded as a secret")
+	}
+
+	dump := cfg.Redacted()
+	if pw := lookup(dump, "db.orders.master.password"); pw != REDACTED {
+		t.Fatalf("master password = %v", pw)
+	}
+	for i, s := range lookup(dump, "db.orders.slave").([]interface{}) {
+		m := s.(map[string]interface{})
+		for k, v := range m {
+			if k != "addr" && v != REDACTED {
+				t.Fatalf("slave %d %s = %v", i, k, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"github.com/spf13/viper"
+	"time"
+)
+
+// viper is not safe for concurrent use: the methods below read the current snapshot, a viper
+// never modified once published. The writes of the Configer and of an EtcdSource go to the local
+// layers or the remote values under wl, then publish a new snapshot of both.
+// The embedded Viper holds the local layers only, it must not be modified while read.
+
+// current is the snapshot, the embedded Viper until the first write
+func (c *Configer) current() *viper.Viper {
+	if v, ok := c.snap.Load().(*viper.Viper); ok {
+		return v
+	}
+	return c.Viper
+}
+
+// build is a snapshot of the local layers with values over them, wl is held
+func (c *Configer) build(values map[string]interface{}) (*viper.Viper, error) {
+	v := viper.New()
+	if err := v.MergeConfigMap(c.Viper.AllSettings()); err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		v.Set(key, value)
+	}
+	return v, nil
+}
+
+// publish stores the snapshot of the local layers and of the remote values, wl is held
+func (c *Configer) publish() error {
+	v, err := c.build(c.values)
+	if err != nil {
+		return err
+	}
+	c.snap.Store(v)
+	return nil
+}
+
+// write runs f on the local layers and publishes them
+func (c *Configer) write(f func(v *viper.Viper) error) error {
+	c.wl.Lock()
+	defer c.wl.Unlock()
+	if err := f(c.Viper); err != nil {
+		return err
+	}
+	return c.publish()
+}
+
+func (c *Configer) Get(key string) interface{} {
+	return c.current().Get(key)
+}
+
+func (c *Configer) GetString(key string) string {
+	return c.current().GetString(key)
+}
+
+func (c *Configer) GetBool(key string) bool {
+	return c.current().GetBool(key)
+}
+
+func (c *Configer) GetInt(key string) int {
+	return c.current().GetInt(key)
+}
+
+func (c *Configer) GetInt32(key string) int32 {
+	return c.current().GetInt32(key)
+}
+
+func (c *Configer) GetInt64(key string) int64 {
+	return c.current().GetInt64(key)
+}
+
+func (c *Configer) GetUint(key string) uint {
+	return c.current().GetUint(key)
+}
+
+func (c *Configer) GetFloat64(key string) float64 {
+	return c.current().GetFloat64(key)
+}
+
+func (c *Configer) GetTime(key string) time.Time {
+	return c.current().GetTime(key)
+}
+
+func (c *Configer) GetDuration(key string) time.Duration {
+	return c.current().GetDuration(key)
+}
+
+func (c *Configer) GetIntSlice(key string) []int {
+	return c.current().GetIntSlice(key)
+}
+
+func (c *Configer) GetStringSlice(key string) []string {
+	return c.current().GetStringSlice(key)
+}
+
+func (c *Configer) GetStringMap(key string) map[string]interface{} {
+	return c.current().GetStringMap(key)
+}
+
+func (c *Configer) GetStringMapString(key string) map[string]string {
+	return c.current().GetStringMapString(key)
+}
+
+func (c *Configer) GetStringMapStringSlice(key string) map[string][]string {
+	return c.current().GetStringMapStringSlice(key)
+}
+
+func (c *Configer) GetSizeInBytes(key string) uint {
+	return c.current().GetSizeInBytes(key)
+}
+
+func (c *Configer) IsSet(key string) bool {
+	return c.current().IsSet(key)
+}
+
+func (c *Configer) AllKeys() []string {
+	return c.current().AllKeys()
+}
+
+func (c *Configer) AllSettings() map[string]interface{} {
+	return c.current().AllSettings()
+}
+
+// Sub is a copy of the section key, it does not follow the updates
+func (c *Configer) Sub(key string) *viper.Viper {
+	return c.current().Sub(key)
+}
+
+func (c *Configer) Unmarshal(rawVal interface{}, opts ...viper.DecoderConfigOption) error {
+	return c.current().Unmarshal(rawVal, opts...)
+}
+
+func (c *Configer) UnmarshalKey(key string, rawVal interface{}, opts ...viper.DecoderConfigOption) error {
+	return c.current().UnmarshalKey(key, rawVal, opts...)
+}
+
+func (c *Configer) UnmarshalExact(rawVal interface{}, opts ...viper.DecoderConfigOption) error {
+	return c.current().UnmarshalExact(rawVal, opts...)
+}
+
+func (c *Configer) Set(key string, value interface{}) {
+	_ = c.write(func(v *viper.Viper) error {
+		v.Set(key, value)
+		return nil
+	})
+}
+
+func (c *Configer) SetDefault(key string, value interface{}) {
+	_ = c.write(func(v *viper.Viper) error {
+		v.SetDefault(key, value)
+		return nil
+	})
+}
+
+func (c *Configer) MergeConfigMap(cfg map[string]interface{}) error {
+	return c.write(func(v *viper.Viper) error {
+		return v.MergeConfigMap(cfg)
+	})
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSnapshotConcurrentReads(t *testing.T) {
+	c := newYamlConfiger(t, `
+mq:
+  timeout: 0
+`)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				_ = c.Redacted()
+				_ = c.GetInt("mq.timeout")
+				_ = c.Source("mq.timeout")
+			}
+		}()
+	}
+	for i := 1; i <= 50; i++ {
+		if err := c.setRemote(map[string]interface{}{"mq.timeout": i}, "etcd /config/r"); err != nil {
+			t.Fatal(err)
+		}
+		c.Set("mq.retries", i)
+	}
+	close(done)
+	wg.Wait()
+	if c.GetInt("mq.timeout") != 50 || c.GetInt("mq.retries") != 50 {
+		t.Fatalf("settings = %v", c.AllSettings())
+	}
+}
@@ -84,5 +84,5 @@ import (
 
 func NewViper() *Configer {
 	v := viper.New()
-	return &Configer{v}
+	return &Configer{Viper: v}
 }
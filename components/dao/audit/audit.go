@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"context"
+	"github.com/joselee214/j7f/components/dao"
+	"google.golang.org/grpc/metadata"
+	"time"
+)
+
+const DEFAULT_OPERATOR_KEY = "operator"
+
+// Record is one audited change
+type Record struct {
+	TraceId  string `json:"trace_id"`
+	Operator string `json:"operator"`
+
+	Op    string        `json:"op"`
+	Table string        `json:"table"`
+	Query string        `json:"query"`
+	Args  []interface{} `json:"args"`
+
+	Old []map[string]interface{} `json:"old"`
+	New []map[string]interface{} `json:"new"`
+
+	LastInsertId int64     `json:"last_insert_id"`
+	RowsAffected int64     `json:"rows_affected"`
+	Time         time.Time `json:"time"`
+}
+
+// Sink stores the audit records
+type Sink interface {
+	Write(ctx context.Context, r *Record) error
+}
+
+// Hook is a dao.Hook writing the successful changes to a Sink,
+// register it with node.RegisterHook(audit.New(sink), "db.user", "db.order")
+type Hook struct {
+	sink Sink
+
+	// OperatorKey is the incoming metadata key holding who made the change
+	OperatorKey string
+
+	// OnError receives the sink errors, they never fail the write
+	OnError func(err error)
+}
+
+func New(sink Sink) *Hook {
+	return &Hook{
+		sink:        sink,
+		OperatorKey: DEFAULT_OPERATOR_KEY,
+	}
+}
+
+func (h *Hook) Before(ctx context.Context, c *dao.Change) error {
+	return nil
+}
+
+func (h *Hook) After(ctx context.Context, c *dao.Change) {
+	if c.Err != nil {
+		return
+	}
+	r := &Record{
+		TraceId:      c.TraceId,
+		Operator:     h.operator(ctx),
+		Op:           c.Op,
+		Table:        c.Table,
+		Query:        c.Query,
+		Args:         c.Args,
+		Old:          c.Old,
+		New:          c.New,
+		LastInsertId: c.LastInsertId,
+		RowsAffected: c.RowsAffected,
+		Time:         c.Time,
+	}
+	if err := h.sink.Write(ctx, r); err != nil && h.OnError != nil {
+		h.OnError(err)
+	}
+}
+
+func (h *Hook) operator(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if v, ok := md[h.OperatorKey]; ok && len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/joselee214/j7f/components/dao"
+	"github.com/joselee214/j7f/components/mq"
+)
+
+// TableSink inserts the records into an audit table, in the same transaction as
+// the change when there is one. The table is expected to look like:
+//
+//	CREATE TABLE audit_log (
+//	    id            BIGINT AUTO_INCREMENT PRIMARY KEY,
+//	    trace_id      VARCHAR(64),
+//	    operator      VARCHAR(64),
+//	    op            VARCHAR(16),
+//	    tbl           VARCHAR(128),
+//	    query         TEXT,
+//	    old_data      JSON,
+//	    new_data      JSON,
+//	    rows_affected BIGINT,
+//	    created_at    DATETIME
+//	)
+type TableSink struct {
+	node  *dao.Node
+	query string
+}
+
+func NewTableSink(node *dao.Node, table string) *TableSink {
+	return &TableSink{
+		node: node,
+		query: "INSERT INTO " + table + " (trace_id, operator, op, tbl, query, old_data, new_data, rows_affected, created_at)" +
+			" VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	}
+}
+
+func (s *TableSink) Write(ctx context.Context, r *Record) error {
+	oldData, err := json.Marshal(r.Old)
+	if err != nil {
+		return err
+	}
+	newData, err := json.Marshal(r.New)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{r.TraceId, r.Operator, r.Op, r.Table, r.Query, string(oldData), string(newData), r.RowsAffected, r.Time}
+
+	// not through node.Exec, the audit table must not trigger the hooks again
+	if tx, err := s.node.GetConnFromCtx(ctx); err == nil {
+		_, err = tx.ExecContext(ctx, s.query, args...)
+		return err
+	}
+	db, err := s.node.GetMasterConn()
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, s.query, args...)
+	return err
+}
+
+// NSQSink publishes the records as json to a topic, after the commit for a transaction
+type NSQSink struct {
+	producer *mq.Producer
+	topic    string
+
+	// OnError receives the publish errors deferred until the commit
+	OnError func(err error)
+}
+
+func NewNSQSink(producer *mq.Producer, topic string) *NSQSink {
+	return &NSQSink{
+		producer: producer,
+		topic:    topic,
+	}
+}
+
+func (s *NSQSink) Write(ctx context.Context, r *Record) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	deferred := dao.OnCommit(ctx, func() {
+		if err := s.producer.Publish(s.topic, body); err != nil && s.OnError != nil {
+			s.OnError(err)
+		}
+	})
+	if deferred {
+		return nil
+	}
+	return s.producer.Publish(s.topic, body)
+}
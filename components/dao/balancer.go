@@ -40,6 +40,11 @@ func Gcd(ary []int) int {
 func (n *Node) InitBalancer() {
 	var sum int
 	n.LastSlaveIndex = 0
+	if len(n.SlaveWeights) == 0 {
+		//只有master
+		n.RoundRobinQ = nil
+		return
+	}
 	gcd := Gcd(n.SlaveWeights)
 
 	for _, weight := range n.SlaveWeights {
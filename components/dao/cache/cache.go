@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrCacheMiss = errors.New("cache miss")
+
+// Cache is the backend of the dao query cache
+type Cache interface {
+	// Get returns ErrCacheMiss when key is not cached
+	Get(key string) ([]byte, error)
+	// Set stores value for ttl, a zero ttl never expires
+	Set(key string, value []byte, ttl time.Duration) error
+	Del(keys ...string) error
+	// Incr increases the integer at key by one and returns the new value
+	Incr(key string) (int64, error)
+}
+
+// Chain reads through the caches in order and backfills the upper levels on hit,
+// e.g. NewChain(lru, redis) keeps the hottest entries in process
+type Chain struct {
+	caches []Cache
+	ttl    time.Duration
+}
+
+// NewChain builds a multi level cache, ttl is used when backfilling
+func NewChain(ttl time.Duration, caches ...Cache) *Chain {
+	return &Chain{
+		caches: caches,
+		ttl:    ttl,
+	}
+}
+
+func (c *Chain) Get(key string) ([]byte, error) {
+	for i, cache := range c.caches {
+		value, err := cache.Get(key)
+		if err == ErrCacheMiss {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < i; j++ {
+			_ = c.caches[j].Set(key, value, c.ttl)
+		}
+		return value, nil
+	}
+	return nil, ErrCacheMiss
+}
+
+func (c *Chain) Set(key string, value []byte, ttl time.Duration) error {
+	for _, cache := range c.caches {
+		if err := cache.Set(key, value, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Chain) Del(keys ...string) error {
+	for _, cache := range c.caches {
+		if err := cache.Del(keys...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Incr increases the counter in the last level, which is the shared one
+func (c *Chain) Incr(key string) (int64, error) {
+	last := c.Last()
+	if last == nil {
+		return 0, errors.New("cache chain is empty")
+	}
+	return last.Incr(key)
+}
+
+// Last is the last level, the one shared by the processes, nil when the chain is empty
+func (c *Chain) Last() Cache {
+	if len(c.caches) == 0 {
+		return nil
+	}
+	return c.caches[len(c.caches)-1]
+}
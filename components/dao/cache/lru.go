@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const DEFAULT_LRU_SIZE = 10000
+
+// LRU is an in-process cache evicting the least recently used entries
+type LRU struct {
+	l     sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type lruEntry struct {
+	key      string
+	value    []byte
+	expireAt time.Time
+}
+
+func NewLRU(size int) *LRU {
+	if size <= 0 {
+		size = DEFAULT_LRU_SIZE
+	}
+	return &LRU{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *LRU) Get(key string) ([]byte, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	entry := e.Value.(*lruEntry)
+	if !entry.expireAt.IsZero() && time.Now().After(entry.expireAt) {
+		c.remove(e)
+		return nil, ErrCacheMiss
+	}
+	c.ll.MoveToFront(e)
+	return entry.value, nil
+}
+
+func (c *LRU) Set(key string, value []byte, ttl time.Duration) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.set(key, value, ttl)
+	return nil
+}
+
+func (c *LRU) Del(keys ...string) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+	for _, key := range keys {
+		if e, ok := c.items[key]; ok {
+			c.remove(e)
+		}
+	}
+	return nil
+}
+
+func (c *LRU) Incr(key string) (int64, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	var n int64
+	if e, ok := c.items[key]; ok {
+		n, _ = strconv.ParseInt(string(e.Value.(*lruEntry).value), 10, 64)
+	}
+	n++
+	c.set(key, []byte(strconv.FormatInt(n, 10)), 0)
+	return n, nil
+}
+
+func (c *LRU) Len() int {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.ll.Len()
+}
+
+func (c *LRU) set(key string, value []byte, ttl time.Duration) {
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl)
+	}
+	if e, ok := c.items[key]; ok {
+		entry := e.Value.(*lruEntry)
+		entry.value = value
+		entry.expireAt = expireAt
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value, expireAt: expireAt})
+	for c.ll.Len() > c.size {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *LRU) remove(e *list.Element) {
+	c.ll.Remove(e)
+	delete(c.items, e.Value.(*lruEntry).key)
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/joselee214/j7f/lib/gopkg.in/redsync.v1"
+	"time"
+)
+
+// Redis caches in redis through the same pools configured for lock.RedisLockConfig
+type Redis struct {
+	pool   redsync.Pool
+	prefix string
+}
+
+func NewRedis(pool redsync.Pool, prefix string) *Redis {
+	return &Redis{
+		pool:   pool,
+		prefix: prefix,
+	}
+}
+
+func (c *Redis) Get(key string) ([]byte, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	value, err := redis.Bytes(conn.Do("GET", c.prefix+key))
+	if err == redis.ErrNil {
+		return nil, ErrCacheMiss
+	}
+	return value, err
+}
+
+func (c *Redis) Set(key string, value []byte, ttl time.Duration) error {
+	conn := c.pool.Get()
+	defer conn.Close()
+	var err error
+	if ttl > 0 {
+		_, err = conn.Do("SET", c.prefix+key, value, "PX", int64(ttl/time.Millisecond))
+	} else {
+		_, err = conn.Do("SET", c.prefix+key, value)
+	}
+	return err
+}
+
+func (c *Redis) Del(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	conn := c.pool.Get()
+	defer conn.Close()
+	args := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		args = append(args, c.prefix+key)
+	}
+	_, err := conn.Do("DEL", args...)
+	return err
+}
+
+func (c *Redis) Incr(key string) (int64, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	return redis.Int64(conn.Do("INCR", c.prefix+key))
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrCallPanicked is returned to the callers waiting for a call which panicked,
+// the panic is raised again in the caller running it
+var ErrCallPanicked = errors.New("cache: the shared call panicked")
+
+// Group collapses concurrent calls with the same key into one execution
+type Group struct {
+	l     sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	wg    sync.WaitGroup
+	value []byte
+	err   error
+}
+
+// Do runs fn once for all the concurrent callers of key, shared reports
+// whether the caller waited for the result of another one
+func (g *Group) Do(key string, fn func() ([]byte, error)) (value []byte, err error, shared bool) {
+	g.l.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.l.Unlock()
+		c.wg.Wait()
+		return c.value, c.err, true
+	}
+	c := new(call)
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.l.Unlock()
+
+	g.call(c, key, fn)
+	return c.value, c.err, false
+}
+
+// call runs fn and releases the waiters even if fn panics
+func (g *Group) call(c *call, key string, fn func() ([]byte, error)) {
+	returned := false
+	defer func() {
+		if !returned {
+			c.value, c.err = nil, ErrCallPanicked
+		}
+		g.l.Lock()
+		delete(g.calls, key)
+		g.l.Unlock()
+		c.wg.Done()
+	}()
+	c.value, c.err = fn()
+	returned = true
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupDo(t *testing.T) {
+	g := &Group{}
+	release := make(chan struct{})
+	calls := 0
+	var wg sync.WaitGroup
+	results := make(chan bool, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, shared := g.Do("k", func() ([]byte, error) {
+				calls++
+				<-release
+				return []byte("v"), nil
+			})
+			if err != nil || string(v) != "v" {
+				t.Errorf("Do = %q, %v", v, err)
+			}
+			results <- shared
+		}()
+	}
+	//等其他调用者加入
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+	shared := 0
+	for s := range results {
+		if s {
+			shared++
+		}
+	}
+	if calls != 1 || shared != 2 {
+		t.Fatalf("calls = %d, shared = %d", calls, shared)
+	}
+}
+
+func TestGroupDoPanic(t *testing.T) {
+	g := &Group{}
+	started, release := make(chan struct{}), make(chan struct{})
+	recovered := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			recovered <- recover()
+		}()
+		g.Do("k", func() ([]byte, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+
+	waited := make(chan error, 1)
+	go func() {
+		_, err, _ := g.Do("k", func() ([]byte, error) {
+			return nil, nil
+		})
+		waited <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if r := <-recovered; r != "boom" {
+		t.Fatalf("leader recovered %v", r)
+	}
+	select {
+	case err := <-waited:
+		if err != ErrCallPanicked {
+			t.Fatalf("waiter error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter blocked after the panic")
+	}
+	if _, err, shared := g.Do("k", func() ([]byte, error) {
+		return []byte("v"), nil
+	}); err != nil || shared {
+		t.Fatalf("next call = %v, shared %v", err, shared)
+	}
+}
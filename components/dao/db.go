@@ -0,0 +1,25 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DB is the master/slave/shard/transaction api of a Node,
+// DAOs depending on it can be tested with dao/fake instead of a live mysql
+type DB interface {
+	GetMasterConn() (*sql.DB, error)
+	GetSlaveConn() (*sql.DB, error)
+	GetTable(db, table string, key ...interface{}) (string, error)
+
+	CachedQuery(ctx context.Context, dest interface{}, scan ScanFunc, query string, args ...interface{}) error
+	Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+
+	BeginTransaction(ctx context.Context, maxRuntime time.Duration) (context.Context, error)
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
+	GetConnFromCtx(ctx context.Context) (*sql.Tx, error)
+}
+
+var _ DB = (*Node)(nil)
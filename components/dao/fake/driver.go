@@ -0,0 +1,455 @@
+package fake
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DriverName is the database/sql driver registered by this package
+const DriverName = "j7fake"
+
+const (
+	BEGIN    = "BEGIN"
+	COMMIT   = "COMMIT"
+	ROLLBACK = "ROLLBACK"
+)
+
+var (
+	storesLock sync.RWMutex
+	stores     = make(map[string]*Store)
+	storeSeq   int
+)
+
+func init() {
+	sql.Register(DriverName, &fakeDriver{})
+}
+
+// Statement is one statement executed against the fake driver
+type Statement struct {
+	Addr  string
+	Query string
+	Args  []driver.Value
+	Tx    bool
+	Time  time.Time
+}
+
+// Expectation is a canned response for the statements matching a pattern
+type Expectation struct {
+	re *regexp.Regexp
+
+	columns []string
+	rows    [][]driver.Value
+
+	lastInsertId int64
+	rowsAffected int64
+
+	err   error
+	times int
+}
+
+// WillReturnRows sets the rows returned by Query
+func (e *Expectation) WillReturnRows(columns []string, rows ...[]interface{}) *Expectation {
+	e.columns = columns
+	e.rows = make([][]driver.Value, 0, len(rows))
+	for _, row := range rows {
+		values := make([]driver.Value, len(row))
+		for i, v := range row {
+			values[i] = v
+		}
+		e.rows = append(e.rows, values)
+	}
+	return e
+}
+
+// WillReturnResult sets the result returned by Exec
+func (e *Expectation) WillReturnResult(lastInsertId, rowsAffected int64) *Expectation {
+	e.lastInsertId = lastInsertId
+	e.rowsAffected = rowsAffected
+	return e
+}
+
+// WillReturnError makes the matching statements fail with err
+func (e *Expectation) WillReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// Times limits the expectation to the first n matching statements, 0 means always
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// Store is the in-memory backend of a fake Node, it records every statement and answers
+// with the registered expectations, else with its tables, which understand the statements
+// of a DAO and of the row images of the hooks
+//
+//	INSERT [IGNORE] INTO t (a, b) VALUES (?, ?), (?, ?)
+//	SELECT *|a, b FROM t [WHERE a = ? AND b IN (?, ?)] [FOR UPDATE]
+//	UPDATE t SET a = ?, b = ? [WHERE ...]
+//	DELETE FROM t [WHERE ...]
+//
+// A table is created by its first insert, its id column is the primary key, auto incremented
+// when not given. The master and the slaves share the tables, a rollback reverts the writes
+// of its transaction only.
+type Store struct {
+	id string
+
+	l            sync.Mutex
+	statements   []Statement
+	expectations []*Expectation
+	pingErr      map[string]error
+	failErr      map[string]error
+	tables       map[string]*table
+}
+
+func NewStore() *Store {
+	storesLock.Lock()
+	defer storesLock.Unlock()
+	storeSeq++
+	s := &Store{
+		id:      strconv.Itoa(storeSeq),
+		pingErr: make(map[string]error),
+		failErr: make(map[string]error),
+		tables:  make(map[string]*table),
+	}
+	stores[s.id] = s
+	return s
+}
+
+// DSN returns the data source name to sql.Open the store for the node addr
+func (s *Store) DSN(addr string) string {
+	return s.id + "/" + addr
+}
+
+// Expect registers a canned response for the statements matching the regexp pattern,
+// later expectations take precedence over earlier ones
+func (s *Store) Expect(pattern string) *Expectation {
+	e := &Expectation{re: regexp.MustCompile(pattern)}
+	s.l.Lock()
+	s.expectations = append(s.expectations, e)
+	s.l.Unlock()
+	return e
+}
+
+// SetPingError makes Ping on addr fail with err, a nil err clears it
+func (s *Store) SetPingError(addr string, err error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if err == nil {
+		delete(s.pingErr, addr)
+		return
+	}
+	s.pingErr[addr] = err
+}
+
+// Fail makes every statement, ping and transaction on addr fail with err, a nil err clears it
+func (s *Store) Fail(addr string, err error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if err == nil {
+		delete(s.failErr, addr)
+		return
+	}
+	s.failErr[addr] = err
+}
+
+// Rows returns a copy of the rows of table, e.g. "db.user"
+func (s *Store) Rows(table string) []map[string]interface{} {
+	s.l.Lock()
+	defer s.l.Unlock()
+	rows := make([]map[string]interface{}, 0)
+	t, ok := s.tables[strings.ToLower(table)]
+	if !ok {
+		return rows
+	}
+	for _, row := range t.rows {
+		r := make(map[string]interface{}, len(row))
+		for k, v := range copyRow(row) {
+			r[k] = v
+		}
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+// Statements returns a copy of the executed statements
+func (s *Store) Statements() []Statement {
+	s.l.Lock()
+	defer s.l.Unlock()
+	statements := make([]Statement, len(s.statements))
+	copy(statements, s.statements)
+	return statements
+}
+
+// StatementsOn returns the statements executed on addr
+func (s *Store) StatementsOn(addr string) []Statement {
+	statements := make([]Statement, 0)
+	for _, st := range s.Statements() {
+		if st.Addr == addr {
+			statements = append(statements, st)
+		}
+	}
+	return statements
+}
+
+// Reset drops the recorded statements, the expectations, the failures and the tables
+func (s *Store) Reset() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.statements = nil
+	s.expectations = nil
+	s.pingErr = make(map[string]error)
+	s.failErr = make(map[string]error)
+	s.tables = make(map[string]*table)
+}
+
+// Close unregisters the store from the driver
+func (s *Store) Close() {
+	storesLock.Lock()
+	delete(stores, s.id)
+	storesLock.Unlock()
+}
+
+// run records the statement and answers it with an expectation, else with the tables,
+// tx is the transaction of the statement if any
+func (s *Store) run(addr, query string, args []driver.Value, tx *tx) (*result, *rows, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if err := s.failErr[addr]; err != nil {
+		return nil, nil, err
+	}
+	if e := s.record(addr, query, args, tx != nil); e != nil {
+		if e.err != nil {
+			return nil, nil, e.err
+		}
+		return &result{lastInsertId: e.lastInsertId, rowsAffected: e.rowsAffected}, &rows{columns: e.columns, values: e.rows}, nil
+	}
+	switch query {
+	case BEGIN, COMMIT, ROLLBACK:
+		return &result{}, nil, nil
+	}
+	return s.execute(query, args, tx)
+}
+
+// record records the statement and returns its expectation if any, s.l is held
+func (s *Store) record(addr, query string, args []driver.Value, tx bool) *Expectation {
+	s.statements = append(s.statements, Statement{
+		Addr:  addr,
+		Query: query,
+		Args:  args,
+		Tx:    tx,
+		Time:  time.Now(),
+	})
+
+	for i := len(s.expectations) - 1; i >= 0; i-- {
+		e := s.expectations[i]
+		if !e.re.MatchString(query) {
+			continue
+		}
+		if e.times > 0 {
+			e.times--
+			if e.times == 0 {
+				s.expectations = append(s.expectations[:i], s.expectations[i+1:]...)
+			}
+		}
+		return e
+	}
+	return nil
+}
+
+func (s *Store) ping(addr string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if err := s.failErr[addr]; err != nil {
+		return err
+	}
+	return s.pingErr[addr]
+}
+
+// revert undoes the writes of tx, the newest first
+func (s *Store) revert(tx *tx) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+	tx.undo = nil
+}
+
+type fakeDriver struct{}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	i := strings.Index(name, "/")
+	if i < 0 {
+		return nil, errors.New("fake: invalid dsn " + name)
+	}
+	storesLock.RLock()
+	s, ok := stores[name[:i]]
+	storesLock.RUnlock()
+	if !ok {
+		return nil, errors.New("fake: store is closed " + name)
+	}
+	return &conn{s: s, addr: name[i+1:]}, nil
+}
+
+type conn struct {
+	s    *Store
+	addr string
+	tx   *tx
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{c: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	t := &tx{c: c}
+	if _, _, err := c.s.run(c.addr, BEGIN, nil, t); err != nil {
+		return nil, err
+	}
+	c.tx = t
+	return t, nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	return c.s.ping(c.addr)
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.exec(query, namedValues(args))
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.query(query, namedValues(args))
+}
+
+func (c *conn) exec(query string, args []driver.Value) (driver.Result, error) {
+	res, _, err := c.s.run(c.addr, query, args, c.tx)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		return driver.RowsAffected(0), nil
+	}
+	return res, nil
+}
+
+func (c *conn) query(query string, args []driver.Value) (driver.Rows, error) {
+	_, r, err := c.s.run(c.addr, query, args, c.tx)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		return &rows{}, nil
+	}
+	return r, nil
+}
+
+type tx struct {
+	c *conn
+	// undo reverts the writes of the transaction, in their order
+	undo []func()
+}
+
+// onRollback registers f to revert a write, t is nil out of a transaction
+func (t *tx) onRollback(f func()) {
+	if t != nil {
+		t.undo = append(t.undo, f)
+	}
+}
+
+func (t *tx) Commit() error {
+	t.c.tx = nil
+	_, _, err := t.c.s.run(t.c.addr, COMMIT, nil, t)
+	return err
+}
+
+func (t *tx) Rollback() error {
+	t.c.tx = nil
+	//连接断开时mysql也会回滚
+	t.c.s.revert(t)
+	_, _, err := t.c.s.run(t.c.addr, ROLLBACK, nil, t)
+	return err
+}
+
+type stmt struct {
+	c     *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.c.exec(s.query, args)
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.c.query(s.query, args)
+}
+
+type result struct {
+	lastInsertId int64
+	rowsAffected int64
+}
+
+func (r *result) LastInsertId() (int64, error) {
+	return r.lastInsertId, nil
+}
+
+func (r *result) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+	pos     int
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.pos])
+	r.pos++
+	return nil
+}
+
+func namedValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
@@ -0,0 +1,626 @@
+package fake
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ER_DUP_ENTRY is the number of the *mysql.MySQLError of a duplicate primary key
+const ER_DUP_ENTRY = 1062
+
+// ErrUnsupported is returned for the statements the tables of a Store do not understand,
+// register an expectation for them
+var ErrUnsupported = errors.New("fake: unsupported statement")
+
+// table keeps its rows ordered by id, like the primary key of an InnoDB table
+type table struct {
+	columns []string
+	rows    []map[string]driver.Value
+	autoInc int64
+}
+
+func (t *table) addColumn(column string) {
+	for _, c := range t.columns {
+		if c == column {
+			return
+		}
+	}
+	t.columns = append(t.columns, column)
+}
+
+// index is the position of the row id, or where to insert it
+func (t *table) index(id int64) (int, bool) {
+	i := sort.Search(len(t.rows), func(i int) bool {
+		return t.rows[i]["id"].(int64) >= id
+	})
+	return i, i < len(t.rows) && t.rows[i]["id"].(int64) == id
+}
+
+func (t *table) insert(row map[string]driver.Value) {
+	i, _ := t.index(row["id"].(int64))
+	t.rows = append(t.rows, nil)
+	copy(t.rows[i+1:], t.rows[i:])
+	t.rows[i] = row
+}
+
+func (t *table) remove(id int64) {
+	if i, ok := t.index(id); ok {
+		t.rows = append(t.rows[:i], t.rows[i+1:]...)
+	}
+}
+
+func (t *table) replace(row map[string]driver.Value) {
+	if i, ok := t.index(row["id"].(int64)); ok {
+		t.rows[i] = row
+	}
+}
+
+func copyRow(row map[string]driver.Value) map[string]driver.Value {
+	c := make(map[string]driver.Value, len(row))
+	for k, v := range row {
+		if b, ok := v.([]byte); ok {
+			v = append([]byte(nil), b...)
+		}
+		c[k] = v
+	}
+	return c
+}
+
+// parser reads the tokens of a statement, binding the ? to args in order
+type parser struct {
+	tokens []string
+	pos    int
+	args   []driver.Value
+	arg    int
+}
+
+func newParser(query string, args []driver.Value) (*parser, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{tokens: tokens, args: args}, nil
+}
+
+func tokenize(query string) ([]string, error) {
+	tokens := make([]string, 0)
+	rs := []rune(query)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r) || r == ';':
+			i++
+		case r == '\'' || r == '"':
+			j := i + 1
+			var b strings.Builder
+			for ; j < len(rs); j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+					b.WriteRune(rs[j])
+					continue
+				}
+				if rs[j] == r {
+					if j+1 < len(rs) && rs[j+1] == r {
+						j++
+						b.WriteRune(r)
+						continue
+					}
+					break
+				}
+				b.WriteRune(rs[j])
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("fake: unterminated string in %q", query)
+			}
+			tokens = append(tokens, "'"+b.String())
+			i = j + 1
+		case strings.ContainsRune("(),*=?", r):
+			tokens = append(tokens, string(r))
+			i++
+		default:
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || strings.ContainsRune("_.`", rs[j])) {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("%w: unexpected %q in %q", ErrUnsupported, r, query)
+			}
+			tokens = append(tokens, string(rs[i:j]))
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func (p *parser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// is consumes the keyword kw when next
+func (p *parser) is(kw string) bool {
+	if strings.EqualFold(p.peek(), kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kw string) error {
+	if !p.is(kw) {
+		return fmt.Errorf("%w: expected %s, got %q", ErrUnsupported, kw, p.peek())
+	}
+	return nil
+}
+
+// end checks the whole statement was read
+func (p *parser) end() error {
+	if p.pos < len(p.tokens) {
+		return fmt.Errorf("%w: unexpected %q", ErrUnsupported, p.peek())
+	}
+	return nil
+}
+
+func (p *parser) name() (string, error) {
+	t := p.next()
+	if t == "" || strings.HasPrefix(t, "'") || !(unicode.IsLetter([]rune(t)[0]) || t[0] == '_' || t[0] == '`') {
+		return "", fmt.Errorf("%w: expected a name, got %q", ErrUnsupported, t)
+	}
+	return strings.ToLower(strings.Replace(t, "`", "", -1)), nil
+}
+
+// column reads a column name, dropping its table
+func (p *parser) column() (string, error) {
+	name, err := p.name()
+	if err != nil {
+		return "", err
+	}
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[i+1:], nil
+	}
+	return name, nil
+}
+
+// value reads a ?, a string, a number or NULL
+func (p *parser) value() (driver.Value, error) {
+	t := p.next()
+	switch {
+	case t == "?":
+		if p.arg >= len(p.args) {
+			return nil, fmt.Errorf("fake: missing arg %d", p.arg+1)
+		}
+		p.arg++
+		return p.args[p.arg-1], nil
+	case strings.HasPrefix(t, "'"):
+		return t[1:], nil
+	case strings.EqualFold(t, "NULL"):
+		return nil, nil
+	}
+	if i, err := strconv.ParseInt(t, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(t, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("%w: expected a value, got %q", ErrUnsupported, t)
+}
+
+// condition is a = or an IN of a WHERE
+type condition struct {
+	column string
+	values []driver.Value
+}
+
+// where reads the conditions joined by AND
+func (p *parser) where() ([]condition, error) {
+	if !p.is("WHERE") {
+		return nil, nil
+	}
+	conds := make([]condition, 0)
+	for {
+		column, err := p.column()
+		if err != nil {
+			return nil, err
+		}
+		c := condition{column: column}
+		switch {
+		case p.is("="):
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			c.values = []driver.Value{v}
+		case p.is("IN"):
+			if err = p.expect("("); err != nil {
+				return nil, err
+			}
+			for {
+				v, err := p.value()
+				if err != nil {
+					return nil, err
+				}
+				c.values = append(c.values, v)
+				if !p.is(",") {
+					break
+				}
+			}
+			if err = p.expect(")"); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%w: operator %q", ErrUnsupported, p.peek())
+		}
+		conds = append(conds, c)
+		if !p.is("AND") {
+			return conds, nil
+		}
+	}
+}
+
+func match(row map[string]driver.Value, conds []condition) bool {
+	for _, c := range conds {
+		found := false
+		for _, v := range c.values {
+			if equal(row[c.column], v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// equal compares the numbers by value and the rest by their text, NULL equals nothing
+func equal(a, b driver.Value) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	fa, aok := number(a)
+	fb, bok := number(b)
+	if aok && bok {
+		return fa == fb
+	}
+	return text(a) == text(b)
+}
+
+func number(v driver.Value) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	case []byte:
+		f, err := strconv.ParseFloat(string(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func text(v driver.Value) string {
+	switch s := v.(type) {
+	case []byte:
+		return string(s)
+	case time.Time:
+		return s.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+// execute runs the statement on the tables of the store, s.l is held. The writes in tx,
+// nil out of a transaction, register how to revert them
+func (s *Store) execute(query string, args []driver.Value, tx *tx) (*result, *rows, error) {
+	p, err := newParser(query, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch strings.ToUpper(p.next()) {
+	case "INSERT":
+		return s.insert(p, tx)
+	case "SELECT":
+		r, err := s.selectRows(p)
+		return nil, r, err
+	case "UPDATE":
+		return s.update(p, tx)
+	case "DELETE":
+		return s.delete(p, tx)
+	}
+	return nil, nil, fmt.Errorf("%w: %s", ErrUnsupported, query)
+}
+
+// insert adds the rows, a row without id gets the next auto increment. A duplicate id
+// fails the whole statement, or skips the row with INSERT IGNORE
+func (s *Store) insert(p *parser, tx *tx) (*result, *rows, error) {
+	ignore := p.is("IGNORE")
+	if err := p.expect("INTO"); err != nil {
+		return nil, nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = p.expect("("); err != nil {
+		return nil, nil, err
+	}
+	columns := make([]string, 0)
+	for {
+		c, err := p.column()
+		if err != nil {
+			return nil, nil, err
+		}
+		columns = append(columns, c)
+		if !p.is(",") {
+			break
+		}
+	}
+	if err = p.expect(")"); err != nil {
+		return nil, nil, err
+	}
+	if !p.is("VALUES") && !p.is("VALUE") {
+		return nil, nil, fmt.Errorf("%w: expected VALUES", ErrUnsupported)
+	}
+	values := make([]map[string]driver.Value, 0)
+	for {
+		if err = p.expect("("); err != nil {
+			return nil, nil, err
+		}
+		row := make(map[string]driver.Value, len(columns)+1)
+		for i, c := range columns {
+			if i > 0 {
+				if err = p.expect(","); err != nil {
+					return nil, nil, err
+				}
+			}
+			if row[c], err = p.value(); err != nil {
+				return nil, nil, err
+			}
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, nil, err
+		}
+		values = append(values, row)
+		if !p.is(",") {
+			break
+		}
+	}
+	if err = p.end(); err != nil {
+		return nil, nil, err
+	}
+
+	//整条语句检查完再写入
+	t := s.tables[name]
+	if t == nil {
+		t = &table{}
+	}
+	autoInc := t.autoInc
+	added := make([]map[string]driver.Value, 0, len(values))
+	ids := make(map[int64]bool, len(values))
+	for _, row := range values {
+		var id int64
+		if row["id"] == nil || equal(row["id"], int64(0)) {
+			autoInc++
+			id = autoInc
+		} else if f, ok := number(row["id"]); ok && f == float64(int64(f)) {
+			id = int64(f)
+			if id > autoInc {
+				autoInc = id
+			}
+		} else {
+			return nil, nil, fmt.Errorf("%w: id %v is not an integer", ErrUnsupported, row["id"])
+		}
+		if _, ok := t.index(id); ok || ids[id] {
+			if ignore {
+				continue
+			}
+			return nil, nil, &mysql.MySQLError{Number: ER_DUP_ENTRY, Message: fmt.Sprintf("Duplicate entry '%d' for key 'PRIMARY'", id)}
+		}
+		ids[id] = true
+		row["id"] = id
+		added = append(added, row)
+	}
+
+	s.tables[name] = t
+	t.autoInc = autoInc
+	t.addColumn("id")
+	for _, c := range columns {
+		t.addColumn(c)
+	}
+	res := &result{rowsAffected: int64(len(added))}
+	for _, row := range added {
+		t.insert(row)
+		id := row["id"].(int64)
+		tx.onRollback(func() {
+			t.remove(id)
+		})
+	}
+	if len(added) > 0 {
+		res.lastInsertId = added[0]["id"].(int64)
+	}
+	return res, nil, nil
+}
+
+func (s *Store) selectRows(p *parser) (*rows, error) {
+	columns := make([]string, 0)
+	if !p.is("*") {
+		for {
+			c, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			columns = append(columns, c)
+			if !p.is(",") {
+				break
+			}
+		}
+	}
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	conds, err := p.where()
+	if err != nil {
+		return nil, err
+	}
+	if p.is("FOR") {
+		if err = p.expect("UPDATE"); err != nil {
+			return nil, err
+		}
+	}
+	if err = p.end(); err != nil {
+		return nil, err
+	}
+
+	t := s.tables[name]
+	if t == nil {
+		t = &table{}
+	}
+	if len(columns) == 0 {
+		columns = t.columns
+	}
+	r := &rows{columns: columns, values: make([][]driver.Value, 0)}
+	for _, row := range t.rows {
+		if !match(row, conds) {
+			continue
+		}
+		row = copyRow(row)
+		values := make([]driver.Value, len(columns))
+		for i, c := range columns {
+			values[i] = row[c]
+		}
+		r.values = append(r.values, values)
+	}
+	return r, nil
+}
+
+// update counts the rows it changes as affected, like mysql without CLIENT_FOUND_ROWS
+func (s *Store) update(p *parser, tx *tx) (*result, *rows, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = p.expect("SET"); err != nil {
+		return nil, nil, err
+	}
+	sets := make(map[string]driver.Value)
+	for {
+		c, err := p.column()
+		if err != nil {
+			return nil, nil, err
+		}
+		if c == "id" {
+			return nil, nil, fmt.Errorf("%w: update of the id", ErrUnsupported)
+		}
+		if err = p.expect("="); err != nil {
+			return nil, nil, err
+		}
+		if sets[c], err = p.value(); err != nil {
+			return nil, nil, err
+		}
+		if !p.is(",") {
+			break
+		}
+	}
+	conds, err := p.where()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = p.end(); err != nil {
+		return nil, nil, err
+	}
+
+	res := &result{}
+	t := s.tables[name]
+	if t == nil {
+		return res, nil, nil
+	}
+	for c := range sets {
+		t.addColumn(c)
+	}
+	for i, row := range t.rows {
+		if !match(row, conds) {
+			continue
+		}
+		updated, changed := copyRow(row), false
+		for c, v := range sets {
+			if !(v == nil && row[c] == nil) && !equal(row[c], v) {
+				updated[c] = v
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		t.rows[i] = updated
+		old := row
+		tx.onRollback(func() {
+			t.replace(old)
+		})
+		res.rowsAffected++
+	}
+	return res, nil, nil
+}
+
+func (s *Store) delete(p *parser, tx *tx) (*result, *rows, error) {
+	if err := p.expect("FROM"); err != nil {
+		return nil, nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, nil, err
+	}
+	conds, err := p.where()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = p.end(); err != nil {
+		return nil, nil, err
+	}
+
+	res := &result{}
+	t := s.tables[name]
+	if t == nil {
+		return res, nil, nil
+	}
+	kept := make([]map[string]driver.Value, 0, len(t.rows))
+	for _, row := range t.rows {
+		if !match(row, conds) {
+			kept = append(kept, row)
+			continue
+		}
+		row := row
+		tx.onRollback(func() {
+			t.insert(row)
+		})
+		res.rowsAffected++
+	}
+	t.rows = kept
+	return res, nil, nil
+}
@@ -0,0 +1,114 @@
+package fake
+
+import (
+	"database/sql"
+	"github.com/joselee214/j7f/components/dao"
+	"sync"
+)
+
+const (
+	DEFAULT_MASTER_ADDR = "fake-master"
+	DEFAULT_SLAVE_ADDR  = "fake-slave"
+
+	DEFAULT_PING_TICKER_TIME = 1
+)
+
+// Node is a dao.Node backed by an in-memory Store, it needs no live mysql.
+// The failures are injected in the driver, so every path of the dao.Node sees them.
+type Node struct {
+	*dao.Node
+	*Store
+
+	l         sync.RWMutex
+	checkErrs []error
+	slaveErr  error
+}
+
+// NewNode builds a fake node for cfg on a new Store, a nil cfg gives one master and one slave
+func NewNode(cfg *dao.DBConfig) (*Node, error) {
+	s := NewStore()
+	n, err := NewNodeWithStore(cfg, s)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return n, nil
+}
+
+// NewNodeWithStore builds a fake node on s, set its ping errors and failures before, e.g. to
+// check how a DAO handles a master down at startup. cfg is not modified
+func NewNodeWithStore(cfg *dao.DBConfig, s *Store) (*Node, error) {
+	if cfg == nil {
+		cfg = &dao.DBConfig{
+			Name:   "fake",
+			Master: &dao.NodeConfig{Addr: DEFAULT_MASTER_ADDR},
+			Slave:  []*dao.NodeConfig{{Addr: DEFAULT_SLAVE_ADDR, Weight: 1}},
+		}
+	}
+	c := *cfg
+	if c.PingTickerTime == 0 {
+		c.PingTickerTime = DEFAULT_PING_TICKER_TIME
+	}
+
+	n := &Node{Store: s}
+	node, err := dao.NewNodeWithOpener(&c, n.checkHandler, func(dsn *dao.NodeConfig) (*sql.DB, error) {
+		return sql.Open(DriverName, n.DSN(dsn.Addr))
+	})
+	if err != nil {
+		return nil, err
+	}
+	n.Node = node
+
+	return n, nil
+}
+
+// FailMaster makes every statement on the master fail with err, e.g. ErrNoMasterConn, a nil err clears it
+func (n *Node) FailMaster(err error) {
+	n.Fail(n.Cfg.Master.Addr, err)
+}
+
+// FailSlave makes GetSlaveConn and every statement on the slaves fail with err, e.g. ErrNoSlaveDB,
+// a nil err clears it
+func (n *Node) FailSlave(err error) {
+	n.l.Lock()
+	n.slaveErr = err
+	n.l.Unlock()
+	for _, slave := range n.Cfg.Slave {
+		n.Fail(slave.Addr, err)
+	}
+}
+
+// GetSlaveConn is the dao.Node one, failing as set by FailSlave
+func (n *Node) GetSlaveConn() (*sql.DB, error) {
+	n.l.RLock()
+	err := n.slaveErr
+	n.l.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return n.Node.GetSlaveConn()
+}
+
+// CheckErrors returns the errors reported by the node alive check, see SetPingError
+func (n *Node) CheckErrors() []error {
+	n.l.RLock()
+	defer n.l.RUnlock()
+	errs := make([]error, len(n.checkErrs))
+	copy(errs, n.checkErrs)
+	return errs
+}
+
+// Close closes the node and the store
+func (n *Node) Close() error {
+	err := n.Node.Close()
+	n.Store.Close()
+	return err
+}
+
+func (n *Node) checkHandler(err error) {
+	n.l.Lock()
+	n.checkErrs = append(n.checkErrs, err)
+	n.l.Unlock()
+}
+
+var _ dao.DB = (*Node)(nil)
@@ -0,0 +1,363 @@
+package fake
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/joselee214/j7f/components/dao"
+	"github.com/joselee214/j7f/components/dao/cache"
+	. "github.com/joselee214/j7f/components/dao/errors"
+	"github.com/joselee214/j7f/components/dao/shard"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type user struct {
+	Id   int64
+	Name string
+}
+
+func scanUsers(rows *sql.Rows) (interface{}, error) {
+	users := make([]user, 0)
+	for rows.Next() {
+		u := user{}
+		if err := rows.Scan(&u.Id, &u.Name); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+func newNode(t *testing.T) *Node {
+	n, err := NewNode(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestNodeReadsBackWrites(t *testing.T) {
+	n := newNode(t)
+	defer n.Close()
+	ctx := context.Background()
+
+	res, err := n.Exec(ctx, "INSERT INTO db.user (name, age) VALUES (?, ?), (?, ?)", "ann", 30, "bob", 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//多行插入时mysql返回第一行的id
+	if id, _ := res.LastInsertId(); id != 1 {
+		t.Fatalf("last insert id = %d, want 1", id)
+	}
+	if res, err = n.Exec(ctx, "UPDATE db.user SET age = ? WHERE name IN (?, ?)", 31, "ann", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if affected, _ := res.RowsAffected(); affected != 2 {
+		t.Fatalf("rows affected = %d, want 2", affected)
+	}
+	if res, err = n.Exec(ctx, "UPDATE db.user SET age = ? WHERE name = ?", 31, "ann"); err != nil {
+		t.Fatal(err)
+	}
+	if affected, _ := res.RowsAffected(); affected != 0 {
+		t.Fatalf("rows affected = %d, an unchanged row counted", affected)
+	}
+
+	var users []user
+	if err = n.CachedQuery(ctx, &users, scanUsers, "SELECT id, name FROM db.user WHERE age = ?", 31); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || users[0].Name != "ann" || users[1].Name != "bob" {
+		t.Fatalf("users = %+v", users)
+	}
+
+	if _, err = n.Exec(ctx, "DELETE FROM db.user WHERE id IN (?)", 2); err != nil {
+		t.Fatal(err)
+	}
+	rows := n.Rows("db.user")
+	if len(rows) != 1 || rows[0]["name"] != "ann" {
+		t.Fatalf("rows = %v", rows)
+	}
+}
+
+func TestNodeInsertKeys(t *testing.T) {
+	n := newNode(t)
+	defer n.Close()
+	ctx := context.Background()
+
+	if _, err := n.Exec(ctx, "INSERT INTO user (id, name) VALUES (5, 'ann')"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := n.Exec(ctx, "INSERT INTO user (name) VALUES ('bob'), ('cat')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = n.Exec(ctx, "INSERT INTO user (id, name) VALUES (?, ?), (?, ?)", 8, "dan", 6, "eve")
+	var merr *mysql.MySQLError
+	if !errors.As(err, &merr) || merr.Number != ER_DUP_ENTRY {
+		t.Fatalf("duplicate id error = %v", err)
+	}
+	if rows := n.Rows("user"); len(rows) != 3 {
+		t.Fatalf("rows = %v, the failed insert wrote some", rows)
+	}
+
+	res, err := n.Exec(ctx, "INSERT IGNORE INTO user (id, name) VALUES (?, ?), (?, ?)", 6, "eve", 8, "dan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if affected, _ := res.RowsAffected(); affected != 1 {
+		t.Fatalf("rows affected = %d, want 1", affected)
+	}
+	if id, _ := res.LastInsertId(); id != 8 {
+		t.Fatalf("last insert id = %d, want 8", id)
+	}
+
+	if _, err = n.Exec(ctx, "INSERT INTO user (name) VALUES ('fay') ON DUPLICATE KEY UPDATE name = 'fay'"); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("unsupported insert error = %v", err)
+	}
+	rows := n.Rows("user")
+	ids := make([]interface{}, 0)
+	for _, row := range rows {
+		ids = append(ids, row["id"])
+	}
+	if !reflect.DeepEqual(ids, []interface{}{int64(5), int64(6), int64(7), int64(8)}) {
+		t.Fatalf("ids = %v", ids)
+	}
+}
+
+func TestNodeRollback(t *testing.T) {
+	n := newNode(t)
+	defer n.Close()
+	ctx, err := n.BeginTransaction(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = n.Exec(ctx, "INSERT INTO db.user (name) VALUES (?)", "ann"); err != nil {
+		t.Fatal(err)
+	}
+	if err = n.Rollback(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if rows := n.Rows("db.user"); len(rows) != 0 {
+		t.Fatalf("rows after rollback = %v", rows)
+	}
+}
+
+func TestNodeRollbackKeepsOtherCommits(t *testing.T) {
+	n := newNode(t)
+	defer n.Close()
+	bg := context.Background()
+	if _, err := n.Exec(bg, "INSERT INTO user (id, name) VALUES (1, 'ann'), (2, 'bob')"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := n.BeginTransaction(bg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		"UPDATE user SET name = 'cat' WHERE id = 1",
+		"DELETE FROM user WHERE id = 2",
+		"INSERT INTO user (id, name) VALUES (3, 'dan')",
+	} {
+		if _, err = n.Exec(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//另一个连接在事务期间的提交
+	if _, err = n.Exec(bg, "INSERT INTO user (id, name) VALUES (4, 'eve')"); err != nil {
+		t.Fatal(err)
+	}
+	if err = n.Rollback(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]interface{}, 0)
+	for _, row := range n.Rows("user") {
+		names = append(names, row["name"])
+	}
+	if !reflect.DeepEqual(names, []interface{}{"ann", "bob", "eve"}) {
+		t.Fatalf("names after rollback = %v", names)
+	}
+}
+
+func TestCachedQueryDetachedFromCancel(t *testing.T) {
+	n := newNode(t)
+	defer n.Close()
+	c, err := dao.NewQueryCache(cache.NewLRU(0), cache.NewLRU(0), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.SetQueryCache(c)
+	if _, err = n.Exec(context.Background(), "INSERT INTO user (name) VALUES ('ann')"); err != nil {
+		t.Fatal(err)
+	}
+
+	//共享的查询不随第一个调用者取消
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var users []user
+	if err = n.CachedQuery(ctx, &users, scanUsers, "SELECT id, name FROM user"); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Fatalf("users = %+v", users)
+	}
+}
+
+func TestNodeFailures(t *testing.T) {
+	n := newNode(t)
+	defer n.Close()
+	ctx := context.Background()
+
+	n.FailSlave(ErrNoSlaveDB)
+	if _, err := n.GetSlaveConn(); err != ErrNoSlaveDB {
+		t.Fatalf("GetSlaveConn error = %v, want ErrNoSlaveDB", err)
+	}
+	var users []user
+	if err := n.CachedQuery(ctx, &users, scanUsers, "SELECT id, name FROM db.user"); !errors.Is(err, ErrNoSlaveDB) {
+		t.Fatalf("CachedQuery error = %v, want ErrNoSlaveDB", err)
+	}
+	n.FailSlave(nil)
+	if err := n.CachedQuery(ctx, &users, scanUsers, "SELECT id, name FROM db.user"); err != nil {
+		t.Fatal(err)
+	}
+
+	n.FailMaster(ErrNoMasterConn)
+	if _, err := n.ExecWrite(ctx, &dao.Write{Query: "INSERT INTO db.user (name) VALUES (?)", Args: []interface{}{"ann"}}); !errors.Is(err, ErrNoMasterConn) {
+		t.Fatalf("ExecWrite error = %v, want ErrNoMasterConn", err)
+	}
+	if _, err := n.BeginTransaction(ctx, 0); !errors.Is(err, ErrNoMasterConn) {
+		t.Fatalf("BeginTransaction error = %v, want ErrNoMasterConn", err)
+	}
+}
+
+func TestNodePingErrorAtStartup(t *testing.T) {
+	s := NewStore()
+	defer s.Close()
+	down := errors.New("connection refused")
+	s.SetPingError(DEFAULT_MASTER_ADDR, down)
+
+	if _, err := NewNodeWithStore(nil, s); !errors.Is(err, down) {
+		t.Fatalf("NewNodeWithStore error = %v, want %v", err, down)
+	}
+}
+
+func TestNodeDoesNotModifyConfig(t *testing.T) {
+	cfg := &dao.DBConfig{Name: "cfg", Master: &dao.NodeConfig{Addr: "m"}}
+	n, err := NewNode(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	if cfg.PingTickerTime != 0 {
+		t.Fatalf("PingTickerTime = %d, cfg was modified", cfg.PingTickerTime)
+	}
+}
+
+func TestExpectationOverridesTables(t *testing.T) {
+	n := newNode(t)
+	defer n.Close()
+	n.Expect("(?i)^select count").WillReturnRows([]string{"n"}, []interface{}{int64(7)})
+
+	db, err := n.GetSlaveConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	if err = db.QueryRow("SELECT COUNT(*) FROM db.user").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 7 {
+		t.Fatalf("count = %d, want 7", count)
+	}
+	if st := n.StatementsOn(DEFAULT_SLAVE_ADDR); len(st) == 0 {
+		t.Fatal("statement not recorded")
+	}
+}
+
+func TestWriteInvalidatesChainCache(t *testing.T) {
+	n := newNode(t)
+	defer n.Close()
+	ctx := context.Background()
+
+	chain := cache.NewChain(time.Minute, cache.NewLRU(0), cache.NewLRU(0))
+	c, err := dao.NewQueryCache(chain, chain, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.SetQueryCache(c)
+
+	if _, err = n.Exec(ctx, "INSERT INTO user (name) VALUES (?)", "ann"); err != nil {
+		t.Fatal(err)
+	}
+	var users []user
+	if err = n.CachedQuery(ctx, &users, scanUsers, "SELECT id, name FROM user"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"bob", "cat"} {
+		if _, err = n.Exec(ctx, "UPDATE user SET name = ? WHERE id = ?", name, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err = n.CachedQuery(ctx, &users, scanUsers, "SELECT id, name FROM user"); err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 1 || users[0].Name != name {
+			t.Fatalf("users = %+v, want %s, stale cache", users, name)
+		}
+	}
+}
+
+type recordHook struct {
+	tables []string
+}
+
+func (h *recordHook) Before(ctx context.Context, c *dao.Change) error {
+	return nil
+}
+
+func (h *recordHook) After(ctx context.Context, c *dao.Change) {
+	h.tables = append(h.tables, c.Table+"|"+c.Logical)
+}
+
+func TestHooksMatchWrittenTables(t *testing.T) {
+	n, err := NewNode(&dao.DBConfig{
+		Name:   "hooks",
+		Master: &dao.NodeConfig{Addr: "m"},
+		Shard:  []*shard.ShardConfig{{DB: "db", Table: "user", Type: shard.MODSHARDTYPE, ModNum: 4}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	ctx := context.Background()
+
+	bare, qualified, order := &recordHook{}, &recordHook{}, &recordHook{}
+	n.RegisterHook(bare, "user")
+	n.RegisterHook(qualified, "DB.`user`")
+	n.RegisterHook(order, "order")
+
+	for _, q := range []string{
+		"INSERT INTO db.user_3 (name) VALUES ('a')",
+		"INSERT INTO user (name) VALUES ('b')",
+		"INSERT INTO other.user (name) VALUES ('c')",
+		"INSERT INTO `order` (uid) VALUES (1)",
+	} {
+		if _, err = n.Exec(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"db.user_3|db.user", "user|user", "other.user|other.user"}
+	if !reflect.DeepEqual(bare.tables, want) {
+		t.Fatalf("bare hook = %v, want %v", bare.tables, want)
+	}
+	if want = []string{"db.user_3|db.user"}; !reflect.DeepEqual(qualified.tables, want) {
+		t.Fatalf("qualified hook = %v, want %v", qualified.tables, want)
+	}
+	if want = []string{"order|order"}; !reflect.DeepEqual(order.tables, want) {
+		t.Fatalf("order hook = %v, want %v", order.tables, want)
+	}
+}
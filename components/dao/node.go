@@ -8,6 +8,8 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 	. "github.com/joselee214/j7f/components/dao/errors"
 	"github.com/joselee214/j7f/components/dao/shard"
+	"github.com/joselee214/j7f/components/health"
+	"github.com/joselee214/j7f/components/lifecycle"
 	"strconv"
 	"sync"
 	"time"
@@ -18,6 +20,9 @@ const TRANSACTION_MAX_RUNTIME = time.Second * 1
 
 type checkHandler func(err error)
 
+// Opener opens the *sql.DB behind one NodeConfig, the default one dials mysql
+type Opener func(dsn *NodeConfig) (*sql.DB, error)
+
 type DBConfig struct {
 	Name         string
 	MaxConnNum   int
@@ -54,12 +59,23 @@ type Node struct {
 
 	Shard   []shard.Shard
 	shardDb []string
+
+	open  Opener
+	cache *QueryCache
+	hooks []tableHook
+
+	closed chan struct{}
 }
 
 type transactionKey struct{}
 type transactionCancelKey struct{}
 
 func NewNode(cfg *DBConfig, c checkHandler) (*Node, error) {
+	return NewNodeWithOpener(cfg, c, nil)
+}
+
+// NewNodeWithOpener builds the node with a custom Opener, e.g. the fake driver in dao/fake
+func NewNodeWithOpener(cfg *DBConfig, c checkHandler, open Opener) (*Node, error) {
 	if len(cfg.Master.Addr) == 0 {
 		return nil, ErrNoMasterDB
 	}
@@ -77,6 +93,8 @@ func NewNode(cfg *DBConfig, c checkHandler) (*Node, error) {
 		Cfg:     cfg,
 		shardDb: shardDb,
 		Shard:   shards,
+		open:    open,
+		closed:  make(chan struct{}),
 	}
 
 	err = n.parseMaster()
@@ -96,6 +114,80 @@ func NewNode(cfg *DBConfig, c checkHandler) (*Node, error) {
 	return n, nil
 }
 
+// Name is the name of the db in the config
+func (n *Node) Name() string {
+	return n.Cfg.Name
+}
+
+// StopHook closes the node with the lifecycle, see ApplicationManager.Add
+func (n *Node) StopHook() lifecycle.Hook {
+	return lifecycle.Hook{
+		Name:     "dao." + n.Cfg.Name,
+		Priority: lifecycle.PRIORITY_CLOSE_DB,
+		Func: func(ctx context.Context) error {
+			return n.Close()
+		},
+	}
+}
+
+// HealthChecks are the checks of the master, critical, and of the slaves, named after Cfg.Name
+func (n *Node) HealthChecks() []*health.Check {
+	checks := []*health.Check{{
+		Name:     "dao." + n.Cfg.Name + ".master",
+		Critical: true,
+		Checker: health.CheckerFunc(func(ctx context.Context) error {
+			db, err := n.GetMasterConn()
+			if err != nil {
+				return err
+			}
+			return db.PingContext(ctx)
+		}),
+	}}
+	if len(n.Slave) == 0 {
+		return checks
+	}
+	return append(checks, &health.Check{
+		Name: "dao." + n.Cfg.Name + ".slaves",
+		Checker: health.CheckerFunc(func(ctx context.Context) error {
+			n.l.RLock()
+			slaves := make([]*sql.DB, len(n.Slave))
+			copy(slaves, n.Slave)
+			n.l.RUnlock()
+			for i, db := range slaves {
+				if err := db.PingContext(ctx); err != nil {
+					return fmt.Errorf("slave[%d]: %s", i, err)
+				}
+			}
+			return nil
+		}),
+	})
+}
+
+// Close stops the alive check and closes the master and slave connections
+func (n *Node) Close() error {
+	n.l.Lock()
+	select {
+	case <-n.closed:
+		n.l.Unlock()
+		return nil
+	default:
+	}
+	close(n.closed)
+	dbs := append([]*sql.DB{n.Master}, n.Slave...)
+	n.l.Unlock()
+
+	var err error
+	for _, db := range dbs {
+		if db == nil {
+			continue
+		}
+		if e := db.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
 func (n *Node) parseMaster() (err error) {
 	n.Master, err = n.openDB(n.Cfg.Master)
 
@@ -128,8 +220,14 @@ func (n *Node) parseSlave() (err error) {
 func (n *Node) CheckNode(checkHandler checkHandler) {
 	t := time.NewTicker( time.Duration(n.Cfg.PingTickerTime) * time.Second)
 
+	defer t.Stop()
+
 	for {
-		<-t.C
+		select {
+		case <-t.C:
+		case <-n.closed:
+			return
+		}
 		n.checkMaster(checkHandler)
 		n.checkSlave(checkHandler)
 	}
@@ -168,7 +266,11 @@ func (n *Node) checkSlave(checkHandler checkHandler) {
 }
 
 func (n *Node) openDB(dsn *NodeConfig) (db *sql.DB, err error) {
-	db, err = sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s)/?charset=utf8&loc=%s&parseTime=true", dsn.User, dsn.Password, dsn.Addr,url.QueryEscape(dsn.Timezone)))
+	if n.open != nil {
+		db, err = n.open(dsn)
+	} else {
+		db, err = sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s)/?charset=utf8&loc=%s&parseTime=true", dsn.User, dsn.Password, dsn.Addr,url.QueryEscape(dsn.Timezone)))
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -181,6 +283,20 @@ func (n *Node) openDB(dsn *NodeConfig) (db *sql.DB, err error) {
 	return db, err
 }
 
+// Stats are the pool stats of the master and of the slaves, keyed master, slave[i]
+func (n *Node) Stats() map[string]sql.DBStats {
+	n.l.RLock()
+	defer n.l.RUnlock()
+	stats := make(map[string]sql.DBStats, 1+len(n.Slave))
+	if n.Master != nil {
+		stats["master"] = n.Master.Stats()
+	}
+	for i, db := range n.Slave {
+		stats["slave["+strconv.Itoa(i)+"]"] = db.Stats()
+	}
+	return stats
+}
+
 func (n *Node) GetMasterConn() (*sql.DB, error) {
 	db := n.Master
 	if db == nil {
@@ -249,6 +365,7 @@ func (n *Node) BeginTransaction(ctx context.Context, maxRuntime time.Duration) (
 
 	ctx = context.WithValue(ctx, transactionKey{}, tx)
 	ctx = context.WithValue(ctx, transactionCancelKey{}, cancel)
+	ctx = context.WithValue(ctx, txStateKey{}, &txState{})
 	return ctx, nil
 }
 
@@ -264,7 +381,12 @@ func (n *Node) Commit(ctx context.Context) error {
 		defer cancel()
 	}
 
-	return tx.Commit()
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	n.committed(ctx)
+	return nil
 }
 
 func (n *Node) Rollback(ctx context.Context) error {
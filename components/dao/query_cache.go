@@ -0,0 +1,229 @@
+package dao
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/joselee214/j7f/components/dao/cache"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DEFAULT_QUERY_CACHE_TTL = time.Minute
+
+var spaceRe = regexp.MustCompile(`\s+`)
+
+// ScanFunc turns the rows of a query into a json serializable value
+type ScanFunc func(rows *sql.Rows) (interface{}, error)
+
+// QueryCache is the read-through cache of the slave reads of a Node.
+// Entries are keyed by statement fingerprint, args and the versions of the tables
+// they read, a write through the Node bumps the table versions so stale entries are never hit.
+type QueryCache struct {
+	// Data keeps the query results, e.g. cache.NewChain(ttl, cache.NewLRU(0), cache.NewRedis(pool, ""))
+	Data cache.Cache
+	// Tags keeps the table versions, it must be shared by all the processes writing the tables.
+	// Only the last level of a cache.Chain is used, the versions are never kept in process
+	Tags cache.Cache
+
+	TTL    time.Duration
+	Prefix string
+
+	// OnError receives the cache errors, they never fail a query or a write
+	OnError func(err error)
+
+	g cache.Group
+}
+
+// NewQueryCache builds a QueryCache, tags defaults to data, except for a cache.Chain whose
+// upper levels are per process
+func NewQueryCache(data, tags cache.Cache, ttl time.Duration) (*QueryCache, error) {
+	if data == nil {
+		return nil, errors.New("query cache: nil data cache")
+	}
+	if tags == nil {
+		if _, ok := data.(*cache.Chain); ok {
+			return nil, errors.New("query cache: tags are required with a cache chain")
+		}
+		tags = data
+	}
+	if ttl <= 0 {
+		ttl = DEFAULT_QUERY_CACHE_TTL
+	}
+	return &QueryCache{
+		Data: data,
+		Tags: tags,
+		TTL:  ttl,
+	}, nil
+}
+
+// Fingerprint identifies a statement and its args, whitespace and case of the statement are ignored
+func Fingerprint(query string, args ...interface{}) string {
+	h := sha1.New()
+	_, _ = h.Write([]byte(strings.ToLower(strings.TrimSpace(spaceRe.ReplaceAllString(query, " ")))))
+	for _, arg := range args {
+		_, _ = fmt.Fprintf(h, "\x00%T:%v", arg, arg)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Invalidate drops all the cached queries reading one of the tables
+func (c *QueryCache) Invalidate(tables ...string) {
+	for _, table := range tables {
+		if _, err := c.tags().Incr(c.tagKey(table)); err != nil {
+			c.error(err)
+		}
+	}
+}
+
+func (c *QueryCache) key(query string, args []interface{}, tables []string) (string, error) {
+	key := c.Prefix + "q:" + Fingerprint(query, args...)
+	tags := c.tags()
+	for _, table := range tables {
+		v, err := tags.Get(c.tagKey(table))
+		if err == cache.ErrCacheMiss {
+			//版本丢失(如被淘汰)时取一个新的, 不会回到旧版本而命中过期的结果
+			v = []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+			if err = tags.Set(c.tagKey(table), v, 0); err != nil {
+				return "", err
+			}
+		} else if err != nil {
+			return "", err
+		}
+		key += ":" + string(v)
+	}
+	return key, nil
+}
+
+// tags is the cache of the table versions, the shared level of a chain
+func (c *QueryCache) tags() cache.Cache {
+	if chain, ok := c.Tags.(*cache.Chain); ok {
+		if last := chain.Last(); last != nil {
+			return last
+		}
+	}
+	return c.Tags
+}
+
+func (c *QueryCache) tagKey(table string) string {
+	return c.Prefix + "t:" + table
+}
+
+func (c *QueryCache) error(err error) {
+	if c.OnError != nil {
+		c.OnError(err)
+	}
+}
+
+// SetQueryCache enables the read-through cache for CachedQuery, a nil c disables it
+func (n *Node) SetQueryCache(c *QueryCache) {
+	n.l.Lock()
+	n.cache = c
+	n.l.Unlock()
+}
+
+func (n *Node) getQueryCache() *QueryCache {
+	n.l.RLock()
+	defer n.l.RUnlock()
+	return n.cache
+}
+
+// CachedQuery runs query on a slave through the query cache, scan turns the rows
+// into a value which is json decoded into dest, the same on a hit and on a miss.
+// Concurrent misses of the same query run it only once, out of the cancellation of their contexts.
+func (n *Node) CachedQuery(ctx context.Context, dest interface{}, scan ScanFunc, query string, args ...interface{}) error {
+	load := func(ctx context.Context) ([]byte, error) {
+		db, err := n.GetSlaveConn()
+		if err != nil {
+			return nil, err
+		}
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		v, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		return json.Marshal(v)
+	}
+
+	c := n.getQueryCache()
+	if c == nil {
+		data, err := load(ctx)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, dest)
+	}
+
+	key, err := c.key(query, args, TablesOf(query))
+	if err != nil {
+		c.error(err)
+		data, err := load(ctx)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, dest)
+	}
+
+	data, err := c.Data.Get(key)
+	if err != nil {
+		if err != cache.ErrCacheMiss {
+			c.error(err)
+		}
+		data, err, _ = c.g.Do(key, func() ([]byte, error) {
+			//其他调用者在等待结果, 不能被第一个调用者取消
+			data, err := load(detached{ctx})
+			if err != nil {
+				return nil, err
+			}
+			if err := c.Data.Set(key, data, c.TTL); err != nil {
+				c.error(err)
+			}
+			return data, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, dest)
+}
+
+// detached keeps the values of a context without its deadline and cancellation
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
+
+func (n *Node) invalidate(ctx context.Context, tables ...string) {
+	c := n.getQueryCache()
+	if c == nil || len(tables) == 0 {
+		return
+	}
+	if OnCommit(ctx, func() { c.Invalidate(tables...) }) {
+		return
+	}
+	c.Invalidate(tables...)
+}
@@ -0,0 +1,62 @@
+package dao
+
+import (
+	"github.com/joselee214/j7f/components/dao/cache"
+	"testing"
+	"time"
+)
+
+func TestNewQueryCacheRejectsChainAsTags(t *testing.T) {
+	chain := cache.NewChain(time.Minute, cache.NewLRU(0), cache.NewLRU(0))
+	if _, err := NewQueryCache(chain, nil, 0); err == nil {
+		t.Fatal("nil tags with a chain accepted")
+	}
+	if _, err := NewQueryCache(cache.NewLRU(0), nil, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueryCacheTagsStayShared(t *testing.T) {
+	local, shared := cache.NewLRU(0), cache.NewLRU(0)
+	chain := cache.NewChain(time.Minute, local, shared)
+	c, err := NewQueryCache(chain, chain, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := c.key("SELECT * FROM user", nil, []string{"user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = local.Get(c.tagKey("user")); err != cache.ErrCacheMiss {
+		t.Fatalf("tag version kept in process, err = %v", err)
+	}
+
+	c.Invalidate("user")
+	after, err := c.key("SELECT * FROM user", nil, []string{"user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Fatal("key unchanged after invalidate")
+	}
+}
+
+func TestQueryCacheEvictedTagNeverGoesBack(t *testing.T) {
+	tags := cache.NewLRU(0)
+	c, err := NewQueryCache(cache.NewLRU(0), tags, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Invalidate("user")
+	before, _ := c.key("SELECT * FROM user", nil, []string{"user"})
+
+	_ = tags.Del(c.tagKey("user"))
+	after, err := c.key("SELECT * FROM user", nil, []string{"user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before == after {
+		t.Fatal("evicted tag went back to an old version")
+	}
+}
@@ -0,0 +1,72 @@
+package dao
+
+import (
+	"regexp"
+	"strings"
+)
+
+var tableRe = regexp.MustCompile("(?i)\\b(?:from|join|into|update)\\s+(?:ignore\\s+)?([`\\w.]+)")
+
+var (
+	intoRe         = regexp.MustCompile("(?i)\\binto\\s+([`\\w.]+)")
+	setRe          = regexp.MustCompile("(?i)\\bset\\b")
+	whereRe        = regexp.MustCompile("(?i)\\b(?:where|order|limit)\\b")
+	deleteTargetRe = regexp.MustCompile("(?is)^\\s*delete\\s+(?:(?:low_priority|quick|ignore)\\s+)*(.*?)\\bfrom\\b")
+	aliasRe        = regexp.MustCompile("(?i)\\b(?:from|join)\\s+([`\\w.]+)(?:\\s+(?:as\\s+)?`?(\\w+))?")
+)
+
+// TablesOf returns the distinct tables a statement reads or writes,
+// names are lower cased and unquoted, e.g. "db.user_3"
+func TablesOf(query string) []string {
+	tables := make([]string, 0, 1)
+	seen := make(map[string]bool)
+	for _, m := range tableRe.FindAllStringSubmatch(query, -1) {
+		table := strings.ToLower(strings.Replace(m[1], "`", "", -1))
+		if table == "" || seen[table] {
+			continue
+		}
+		seen[table] = true
+		tables = append(tables, table)
+	}
+	return tables
+}
+
+// WrittenTablesOf returns the distinct tables a write statement writes, the table of an
+// INSERT or REPLACE, the joined tables of an UPDATE, the targets of a DELETE, else its tables
+func WrittenTablesOf(query string) []string {
+	switch opOf(query) {
+	case OP_INSERT, OP_REPLACE:
+		if m := intoRe.FindStringSubmatch(query); m != nil {
+			return TablesOf("into " + m[1])
+		}
+	case OP_UPDATE:
+		if loc := setRe.FindStringIndex(query); loc != nil {
+			return TablesOf(query[:loc[0]])
+		}
+	case OP_DELETE:
+		if m := deleteTargetRe.FindStringSubmatch(query); m != nil && strings.TrimSpace(m[1]) != "" {
+			//DELETE u, o FROM user u JOIN order o ...
+			aliases := make(map[string]string)
+			for _, a := range aliasRe.FindAllStringSubmatch(query, -1) {
+				if a[2] != "" {
+					aliases[strings.ToLower(a[2])] = a[1]
+				}
+			}
+			targets := make([]string, 0, 1)
+			for _, t := range strings.Split(m[1], ",") {
+				t = strings.Replace(strings.TrimSuffix(strings.TrimSpace(t), ".*"), "`", "", -1)
+				if table, ok := aliases[strings.ToLower(t)]; ok {
+					t = table
+				}
+				if t != "" {
+					targets = append(targets, "from "+t)
+				}
+			}
+			return TablesOf(strings.Join(targets, " "))
+		}
+		if loc := whereRe.FindStringIndex(query); loc != nil {
+			return TablesOf(query[:loc[0]])
+		}
+	}
+	return TablesOf(query)
+}
@@ -0,0 +1,43 @@
+package dao
+
+import (
+	"github.com/joselee214/j7f/components/dao/shard"
+	"reflect"
+	"testing"
+)
+
+func TestWrittenTablesOf(t *testing.T) {
+	cases := []struct {
+		query  string
+		tables []string
+	}{
+		{"INSERT INTO `db`.`user` (name) VALUES (?)", []string{"db.user"}},
+		{"INSERT INTO log SELECT * FROM user", []string{"log"}},
+		{"REPLACE INTO db.user_3 (id) VALUES (?)", []string{"db.user_3"}},
+		{"UPDATE user u JOIN account a ON a.uid = u.id SET u.n = ? WHERE a.id IN (SELECT id FROM x)", []string{"user", "account"}},
+		{"DELETE FROM user WHERE id IN (SELECT uid FROM ban)", []string{"user"}},
+		{"DELETE u, a FROM user u JOIN account AS a ON a.uid = u.id WHERE u.id = ?", []string{"user", "account"}},
+	}
+	for _, c := range cases {
+		if tables := WrittenTablesOf(c.query); !reflect.DeepEqual(tables, c.tables) {
+			t.Errorf("WrittenTablesOf(%q) = %v, want %v", c.query, tables, c.tables)
+		}
+	}
+}
+
+func TestLogicalTable(t *testing.T) {
+	n := &Node{Cfg: &DBConfig{Shard: []*shard.ShardConfig{{DB: "db", Table: "user", Type: shard.MODSHARDTYPE, ModNum: 4}}}}
+	cases := map[string]string{
+		"db.user_3":    "db.user",
+		"user_3":       "user",
+		"other.user_3": "other.user_3",
+		"db.order_3":   "db.order_3",
+		"db.user_x":    "db.user_x",
+		"db.user":      "db.user",
+	}
+	for table, logical := range cases {
+		if got := n.logicalTable(table); got != logical {
+			t.Errorf("logicalTable(%q) = %q, want %q", table, got, logical)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package dao
+
+import (
+	"context"
+	"sync"
+)
+
+// txState keeps the work deferred until a transaction is committed
+type txState struct {
+	l        sync.Mutex
+	onCommit []func()
+}
+
+type txStateKey struct{}
+
+// OnCommit defers f until the transaction of ctx is committed, f is dropped on rollback.
+// It reports false when ctx carries no transaction, f is not called then.
+func OnCommit(ctx context.Context, f func()) bool {
+	t, ok := ctx.Value(txStateKey{}).(*txState)
+	if !ok {
+		return false
+	}
+	t.l.Lock()
+	t.onCommit = append(t.onCommit, f)
+	t.l.Unlock()
+	return true
+}
+
+func (n *Node) committed(ctx context.Context) {
+	t, ok := ctx.Value(txStateKey{}).(*txState)
+	if !ok {
+		return
+	}
+	t.l.Lock()
+	fs := t.onCommit
+	t.onCommit = nil
+	t.l.Unlock()
+	for _, f := range fs {
+		f()
+	}
+}
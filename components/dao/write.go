@@ -0,0 +1,285 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"google.golang.org/grpc/metadata"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OP_INSERT  = "INSERT"
+	OP_UPDATE  = "UPDATE"
+	OP_DELETE  = "DELETE"
+	OP_REPLACE = "REPLACE"
+)
+
+// Write is a write statement, Select optionally reads the rows it touches,
+// e.g. "SELECT * FROM db.user WHERE id=?", so the hooks get the old and new row images
+type Write struct {
+	Query string
+	Args  []interface{}
+
+	Select     string
+	SelectArgs []interface{}
+}
+
+// Change describes the write of a table for the hooks, Old is read before the write and New after it,
+// both only when the Write has a Select. A statement writing several tables gives a Change for each
+type Change struct {
+	Op    string
+	Table string
+	// Logical is the table of a shard in the Shard config, "db.user" for "db.user_3", else Table
+	Logical string
+	Query   string
+	Args    []interface{}
+	TraceId string
+	Tx      bool
+	Time    time.Time
+
+	Old []map[string]interface{}
+	New []map[string]interface{}
+
+	LastInsertId int64
+	RowsAffected int64
+
+	// Err is the error of the write, set for the After hooks
+	Err error
+}
+
+// Hook observes the writes on the tables it is registered for.
+// Before may abort the write by returning an error, After runs right after the
+// statement, inside the transaction if any, see OnCommit to defer work until the commit.
+type Hook interface {
+	Before(ctx context.Context, c *Change) error
+	After(ctx context.Context, c *Change)
+}
+
+type tableHook struct {
+	tables map[string]bool
+	h      Hook
+}
+
+// RegisterHook adds h for the writes on tables, no tables means all of them. A table without
+// a schema, e.g. "user", matches it in any schema, and the table of a shard its shards, "db.user"
+// matches "db.user_3" when the Shard config of the node shards db.user
+func (n *Node) RegisterHook(h Hook, tables ...string) {
+	th := tableHook{h: h}
+	if len(tables) > 0 {
+		th.tables = make(map[string]bool, len(tables))
+		for _, table := range tables {
+			th.tables[normalizeTable(table)] = true
+		}
+	}
+	n.l.Lock()
+	n.hooks = append(n.hooks, th)
+	n.l.Unlock()
+}
+
+func (n *Node) hooksFor(table, logical string) []Hook {
+	names := []string{table, bareTable(table), logical, bareTable(logical)}
+	n.l.RLock()
+	defer n.l.RUnlock()
+	hooks := make([]Hook, 0, len(n.hooks))
+	for _, th := range n.hooks {
+		if th.tables == nil {
+			hooks = append(hooks, th.h)
+			continue
+		}
+		for _, name := range names {
+			if th.tables[name] {
+				hooks = append(hooks, th.h)
+				break
+			}
+		}
+	}
+	return hooks
+}
+
+// logicalTable is the table sharded into table by the Shard config, else table
+func (n *Node) logicalTable(table string) string {
+	i := strings.LastIndex(table, "_")
+	if i < 0 || n.Cfg == nil {
+		return table
+	}
+	if _, err := strconv.Atoi(table[i+1:]); err != nil {
+		return table
+	}
+	base := table[:i]
+	db := ""
+	if j := strings.Index(base, "."); j >= 0 {
+		db, base = base[:j], base[j+1:]
+	}
+	for _, sc := range n.Cfg.Shard {
+		if strings.EqualFold(sc.Table, base) && (db == "" || strings.EqualFold(sc.DB, db)) {
+			return table[:i]
+		}
+	}
+	return table
+}
+
+func normalizeTable(table string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(table), "`", "", -1))
+}
+
+func bareTable(table string) string {
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		return table[i+1:]
+	}
+	return table
+}
+
+// Exec runs a write on the master, or in the transaction of ctx, and invalidates the
+// cached queries of the written tables, after the commit for a transaction
+func (n *Node) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return n.ExecWrite(ctx, &Write{Query: query, Args: args})
+}
+
+// ExecWrite is Exec with the row images for the hooks
+func (n *Node) ExecWrite(ctx context.Context, w *Write) (sql.Result, error) {
+	type dispatch struct {
+		c     *Change
+		hooks []Hook
+	}
+	var ds []dispatch
+	for _, table := range WrittenTablesOf(w.Query) {
+		logical := n.logicalTable(table)
+		if hooks := n.hooksFor(table, logical); len(hooks) > 0 {
+			ds = append(ds, dispatch{c: &Change{Table: table, Logical: logical}, hooks: hooks})
+		}
+	}
+
+	var old []map[string]interface{}
+	if len(ds) > 0 {
+		op := opOf(w.Query)
+		_, err := n.GetConnFromCtx(ctx)
+		tx := err == nil
+		if w.Select != "" && op != OP_INSERT {
+			if old, err = n.image(ctx, w.Select, w.SelectArgs); err != nil {
+				return nil, err
+			}
+		}
+		for _, d := range ds {
+			d.c.Op = op
+			d.c.Query = w.Query
+			d.c.Args = w.Args
+			d.c.TraceId = traceIdOf(ctx)
+			d.c.Tx = tx
+			d.c.Time = time.Now()
+			d.c.Old = old
+			for _, h := range d.hooks {
+				if err := h.Before(ctx, d.c); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	res, err := n.exec(ctx, w.Query, w.Args...)
+
+	if len(ds) > 0 {
+		var lastInsertId, rowsAffected int64
+		var images []map[string]interface{}
+		if err == nil {
+			lastInsertId, _ = res.LastInsertId()
+			rowsAffected, _ = res.RowsAffected()
+			if w.Select != "" && ds[0].c.Op != OP_DELETE {
+				images, _ = n.image(ctx, w.Select, w.SelectArgs)
+			}
+		}
+		for _, d := range ds {
+			d.c.Err = err
+			d.c.LastInsertId = lastInsertId
+			d.c.RowsAffected = rowsAffected
+			d.c.New = images
+			for _, h := range d.hooks {
+				h.After(ctx, d.c)
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	n.invalidate(ctx, TablesOf(w.Query)...)
+	return res, nil
+}
+
+func (n *Node) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if tx, err := n.GetConnFromCtx(ctx); err == nil {
+		return tx.ExecContext(ctx, query, args...)
+	}
+	db, err := n.GetMasterConn()
+	if err != nil {
+		return nil, err
+	}
+	return db.ExecContext(ctx, query, args...)
+}
+
+// image reads rows on the master, or in the transaction of ctx
+func (n *Node) image(ctx context.Context, query string, args []interface{}) ([]map[string]interface{}, error) {
+	var rows *sql.Rows
+	if tx, err := n.GetConnFromCtx(ctx); err == nil {
+		rows, err = tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		db, err := n.GetMasterConn()
+		if err != nil {
+			return nil, err
+		}
+		rows, err = db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	images := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[column] = string(b)
+			} else {
+				row[column] = values[i]
+			}
+		}
+		images = append(images, row)
+	}
+	return images, rows.Err()
+}
+
+func opOf(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[0])
+}
+
+func traceIdOf(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if traceIds, ok := md["trace_id"]; ok && len(traceIds) > 0 {
+		return traceIds[0]
+	}
+	return ""
+}
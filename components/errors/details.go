@@ -0,0 +1,187 @@
+package errors
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/joselee214/j7f/proto/common"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// FieldViolation describes an invalid field of a request
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// details are sent to grpc clients as google.rpc.Status details
+type details struct {
+	grpcCode   *codes.Code
+	violations []FieldViolation
+	retryAfter time.Duration
+	debug      *errdetails.DebugInfo
+	traceId    string
+
+	// remote errors come rendered from FromGRPC
+	remote bool
+}
+
+// WithGrpcCode sets the grpc code, overriding the one registered for the business code
+func (e *Error) WithGrpcCode(c codes.Code) *Error {
+	e.grpcCode = &c
+	return e
+}
+
+func (e *Error) WithFieldViolation(field, description string) *Error {
+	e.violations = append(e.violations, FieldViolation{Field: field, Description: description})
+	return e
+}
+
+// WithRetryAfter tells the client when to retry
+func (e *Error) WithRetryAfter(d time.Duration) *Error {
+	e.retryAfter = d
+	return e
+}
+
+// WithDebugInfo sends detail and the stack of the error to the client, keep it off for external callers
+func (e *Error) WithDebugInfo(detail string) *Error {
+	e.debug = &errdetails.DebugInfo{Detail: detail}
+	if e.stack != nil {
+		for _, f := range e.stack.StackTrace() {
+			e.debug.StackEntries = append(e.debug.StackEntries, fmt.Sprintf("%+v", f))
+		}
+	}
+	return e
+}
+
+func (e *Error) WithTraceId(traceId string) *Error {
+	e.traceId = traceId
+	return e
+}
+
+// GrpcCode returns the grpc code sent to clients
+func (e *Error) GrpcCode() codes.Code {
+	if e.grpcCode != nil {
+		return *e.grpcCode
+	}
+	return DefaultRegistry.GrpcCode(e.code)
+}
+
+func (e *Error) FieldViolations() []FieldViolation { return e.violations }
+
+func (e *Error) RetryAfter() time.Duration { return e.retryAfter }
+
+// DebugInfo returns the debug detail and the stack entries, of the remote side for FromGRPC
+func (e *Error) DebugInfo() (string, []string) {
+	if e.debug == nil {
+		return "", nil
+	}
+	return e.debug.Detail, e.debug.StackEntries
+}
+
+func (e *Error) TraceId() string { return e.traceId }
+
+// GRPCStatus encodes the error as a grpc status, the business code, message
+// and trace id go in a common.BusinessStatus detail, the others in the
+// google.rpc error details. The message is in the default locale, see StatusIn
+func (e *Error) GRPCStatus() *status.Status {
+	return e.GRPCStatusIn(DefaultRegistry.DefaultLocale())
+}
+
+// GRPCStatusIn is GRPCStatus with the message in locale
+func (e *Error) GRPCStatusIn(locale string) *status.Status {
+	msg := e.Message(locale)
+	s := status.New(e.GrpcCode(), msg)
+
+	ds := []proto.Message{
+		&common.BusinessStatus{
+			MsgCode: &common.BusinessStatus_Code{Code: int32(e.code)},
+			Msg:     msg,
+			TraceId: e.traceId,
+		},
+	}
+	if len(e.violations) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, v := range e.violations {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Field,
+				Description: v.Description,
+			})
+		}
+		ds = append(ds, br)
+	}
+	if e.retryAfter > 0 {
+		ds = append(ds, &errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(e.retryAfter)})
+	}
+	if e.debug != nil {
+		ds = append(ds, e.debug)
+	}
+
+	sd, err := s.WithDetails(ds...)
+	if err != nil {
+		return s
+	}
+	return sd
+}
+
+// StatusIn is the grpc status of err with the message of its *Error in locale,
+// e.g. errors.StatusIn(err, errors.LocaleFromContext(ctx)) in a server interceptor
+func StatusIn(err error, locale string) *status.Status {
+	if e, ok := err.(*Error); ok {
+		return e.GRPCStatusIn(locale)
+	}
+	var e *Error
+	if As(err, &e) {
+		return wrappedStatus(err, locale)
+	}
+	s, _ := status.FromError(err)
+	return s
+}
+
+// FromGRPC restores the *Error sent by GRPCStatus from the error of a grpc call,
+// errors without a status get codes.Unknown, nil gives nil
+func FromGRPC(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if As(err, &e) {
+		return e
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return NewFromError(err)
+	}
+
+	c := s.Code()
+	e = &Error{
+		code: int64(c),
+		err:  s.Message(),
+	}
+	e.grpcCode = &c
+	e.remote = true
+	for _, d := range s.Details() {
+		switch d := d.(type) {
+		case *common.BusinessStatus:
+			e.code = int64(d.GetCode())
+			if d.GetMsg() != "" {
+				e.err = d.GetMsg()
+			}
+			e.traceId = d.GetTraceId()
+		case *errdetails.BadRequest:
+			for _, v := range d.GetFieldViolations() {
+				e.violations = append(e.violations, FieldViolation{Field: v.GetField(), Description: v.GetDescription()})
+			}
+		case *errdetails.RetryInfo:
+			if d.GetRetryDelay() != nil {
+				e.retryAfter, _ = ptypes.Duration(d.GetRetryDelay())
+			}
+		case *errdetails.DebugInfo:
+			e.debug = d
+		}
+	}
+	return e
+}
@@ -0,0 +1,51 @@
+package errors
+
+import (
+	stderrors "errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestFromGRPCRoundTrip(t *testing.T) {
+	sent := New("invalid user").
+		WithGrpcCode(codes.InvalidArgument).
+		WithFieldViolation("name", "required").
+		WithRetryAfter(3 * time.Second).
+		WithDebugInfo("lookup failed").
+		WithTraceId("t1")
+
+	got := FromGRPC(sent.GRPCStatus().Err())
+	if got.GrpcCode() != codes.InvalidArgument {
+		t.Errorf("grpc code = %s", got.GrpcCode())
+	}
+	if got.Code() != sent.Code() || got.Message("") != "invalid user" {
+		t.Errorf("code, message = %d %q", got.Code(), got.Message(""))
+	}
+	if v := got.FieldViolations(); len(v) != 1 || v[0].Field != "name" || v[0].Description != "required" {
+		t.Errorf("violations = %+v", v)
+	}
+	if got.RetryAfter() != 3*time.Second {
+		t.Errorf("retry after = %s", got.RetryAfter())
+	}
+	if detail, stack := got.DebugInfo(); detail != "lookup failed" || len(stack) == 0 {
+		t.Errorf("debug = %q %d entries", detail, len(stack))
+	}
+	if got.TraceId() != "t1" {
+		t.Errorf("trace id = %q", got.TraceId())
+	}
+}
+
+func TestFromGRPCPlainErrors(t *testing.T) {
+	if FromGRPC(nil) != nil {
+		t.Fatal("FromGRPC(nil) != nil")
+	}
+	e := FromGRPC(status.Error(codes.NotFound, "no user"))
+	if e.Code() != int64(codes.NotFound) || e.GrpcCode() != codes.NotFound || e.Message("") != "no user" {
+		t.Fatalf("status error = %d %s %q", e.Code(), e.GrpcCode(), e.Message(""))
+	}
+	if e = FromGRPC(stderrors.New("boom")); e.GrpcCode() != codes.Unknown {
+		t.Fatalf("plain error grpc code = %s", e.GrpcCode())
+	}
+}
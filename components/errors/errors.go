@@ -1,9 +1,9 @@
 package errors
 
 import (
+	stderrors "errors"
 	"fmt"
 	"github.com/joselee214/j7f/proto/common"
-	"google.golang.org/genproto/googleapis/rpc/status"
 	"google.golang.org/grpc/codes"
 	"io"
 	"reflect"
@@ -18,8 +18,24 @@ const (
 	CommonError_INIT CommonError = 0
 	// 处理超时
 	CommonError_PROCESSING_TIMEOUT CommonError = 10001
+	// 请求过于频繁
+	CommonError_RATE_LIMITED CommonError = 10002
 )
 
+var CommonError_name = map[int32]string{
+	0:     "INIT",
+	10001: "PROCESSING_TIMEOUT",
+	10002: "RATE_LIMITED",
+}
+
+// String is the name of the code, like the generated enums, NewFromCode needs it
+func (x CommonError) String() string {
+	if name, ok := CommonError_name[int32(x)]; ok {
+		return name
+	}
+	return fmt.Sprint(int32(x))
+}
+
 type errorCode interface {
 	String() string
 }
@@ -27,27 +43,71 @@ type errorCode interface {
 type Error struct {
 	code int64
 	err  string
+	args []interface{}
+
+	// cause is the error wrapped by Errorf with %w
+	cause error
+
+	details
 
 	*stack
 }
 
-func (e *Error) ResHeader() *common.BusinessStatus {
+// WithArgs sets the args rendering the message template of the code
+func (e *Error) WithArgs(args ...interface{}) *Error {
+	e.args = args
+	return e
+}
+
+func (e *Error) Code() int64 { return e.code }
+
+// Message renders the registered message of the code in locale,
+// the error string when the code has no message
+func (e *Error) Message(locale string) string {
+	if e.remote {
+		return e.err
+	}
+	if msg, ok := DefaultRegistry.Message(e.code, locale, e.args...); ok {
+		return msg
+	}
+	return e.err
+}
+
+// ResHeader renders the message in the optional locale, the default locale otherwise
+func (e *Error) ResHeader(locale ...string) *common.BusinessStatus {
 	return &common.BusinessStatus{
 		MsgCode: &common.BusinessStatus_Code{Code: int32(e.code)},
-		Msg:     e.err,
+		Msg:     e.Message(firstLocale(locale)),
+		TraceId: e.traceId,
+	}
+}
+
+func firstLocale(locale []string) string {
+	if len(locale) > 0 {
+		return locale[0]
 	}
+	return DefaultRegistry.DefaultLocale()
 }
 
-func GetResHeader(err error) *common.BusinessStatus {
+// Message renders err for end users in locale
+func Message(err error, locale string) string {
+	var e *Error
+	if stderrors.As(err, &e) {
+		return e.Message(locale)
+	}
+	return err.Error()
+}
+
+func GetResHeader(err error, locale ...string) *common.BusinessStatus {
 	if err == nil {
 		return &common.BusinessStatus{
 			MsgCode: &common.BusinessStatus_Code{Code: OK},
 			Msg:     SUCCESS,
 		}
 	}
-	e, ok := err.(*Error)
-	if ok {
-		return e.ResHeader()
+	var e *Error
+	if stderrors.As(err, &e) {
+		return e.ResHeader(locale...)
 	}
 
 	return &common.BusinessStatus{
@@ -84,11 +144,38 @@ func New(s string) *Error {
 	return e
 }
 
+// Errorf formats like fmt.Errorf, an *Error wrapped with %w passes its code on, the first
+// one with several %w, the code is codes.Unknown otherwise
 func Errorf(format string, args ...interface{}) *Error {
-	return &Error{
-		err:   fmt.Sprintf(format, args...),
+	err := fmt.Errorf(format, args...)
+	e := &Error{
+		code:  int64(codes.Unknown),
+		err:   err.Error(),
+		cause: stderrors.Unwrap(err),
 		stack: callers(),
 	}
+	if _, ok := err.(interface{ Unwrap() []error }); ok {
+		//多个 %w 时 errors.Unwrap 返回 nil, 保留 fmt 的错误让 Is/As 遍历所有被包装的错误
+		e.cause = err
+	}
+	var inner *Error
+	if e.cause != nil && stderrors.As(e.cause, &inner) {
+		e.code = inner.code
+		e.grpcCode = inner.grpcCode
+		e.traceId = inner.traceId
+	}
+	return e
+}
+
+func (e *Error) Unwrap() error { return e.cause }
+
+// Is reports whether target is an *Error with the same code, codes.Unknown never matches
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return t.code == e.code && t.code != int64(codes.Unknown)
 }
 
 func (e *Error) Error() string { return e.err }
@@ -111,13 +198,6 @@ func (e *Error) Format(s fmt.State, verb rune) {
 	}
 }
 
-func (e *Error) GRPCStatus() *status.Status {
-	return &status.Status{
-		Code:    int32(e.code),
-		Message: e.err,
-	}
-}
-
 func WithStack(err error) error {
 	if err == nil {
 		return nil
@@ -135,6 +215,8 @@ type withStack struct {
 
 func (w *withStack) Cause() error { return w.error }
 
+func (w *withStack) Unwrap() error { return w.error }
+
 func (w *withStack) Format(s fmt.State, verb rune) {
 	switch verb {
 	case 'v':
@@ -216,6 +298,7 @@ type withMessage struct {
 
 func (w *withMessage) Error() string { return w.msg + ": " + w.cause.Error() }
 func (w *withMessage) Cause() error  { return w.cause }
+func (w *withMessage) Unwrap() error { return w.cause }
 
 func (w *withMessage) Format(s fmt.State, verb rune) {
 	switch verb {
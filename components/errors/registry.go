@@ -0,0 +1,342 @@
+package errors
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"io/ioutil"
+	"net/http"
+	"sigs.k8s.io/yaml"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const DEFAULT_LOCALE = "zh-CN"
+
+// Definition describes a business error code, Messages maps a locale to a
+// fmt template rendered with the args of the error, e.g.
+//
+//	# errors.yaml
+//	- code: 10001
+//	  http_status: 504
+//	  grpc_code: DeadlineExceeded
+//	  log_level: warn
+//	  messages:
+//	    zh-CN: "处理超时"
+//	    en: "processing timeout"
+type Definition struct {
+	Code       int64             `json:"code"`
+	HttpStatus int               `json:"http_status"`
+	GrpcCode   string            `json:"grpc_code"`
+	LogLevel   string            `json:"log_level"`
+	Messages   map[string]string `json:"messages"`
+}
+
+// Registry keeps the definitions of the error codes, it is safe for concurrent use
+type Registry struct {
+	l             sync.RWMutex
+	defs          map[int64]*Definition
+	locales       map[string]bool
+	defaultLocale string
+}
+
+// DefaultRegistry is used by Error to render messages and map codes, it has the
+// definitions of the CommonError codes, registering a code again replaces them
+var DefaultRegistry = newDefaultRegistry()
+
+// commonDefinitions are the built-in definitions of the CommonError codes
+func commonDefinitions() []*Definition {
+	return []*Definition{
+		{
+			Code:       int64(CommonError_PROCESSING_TIMEOUT),
+			HttpStatus: http.StatusGatewayTimeout,
+			GrpcCode:   "DeadlineExceeded",
+			LogLevel:   "warn",
+			Messages:   map[string]string{"zh-CN": "处理超时", "en": "processing timeout"},
+		},
+		{
+			Code:       int64(CommonError_RATE_LIMITED),
+			HttpStatus: http.StatusTooManyRequests,
+			GrpcCode:   "ResourceExhausted",
+			LogLevel:   "warn",
+			Messages:   map[string]string{"zh-CN": "请求过于频繁", "en": "too many requests"},
+		},
+	}
+}
+
+func newDefaultRegistry() *Registry {
+	r := NewRegistry()
+	r.Register(commonDefinitions()...)
+	return r
+}
+
+var grpcCodes = map[string]codes.Code{
+	"OK":                 codes.OK,
+	"Canceled":           codes.Canceled,
+	"Unknown":            codes.Unknown,
+	"InvalidArgument":    codes.InvalidArgument,
+	"DeadlineExceeded":   codes.DeadlineExceeded,
+	"NotFound":           codes.NotFound,
+	"AlreadyExists":      codes.AlreadyExists,
+	"PermissionDenied":   codes.PermissionDenied,
+	"ResourceExhausted":  codes.ResourceExhausted,
+	"FailedPrecondition": codes.FailedPrecondition,
+	"Aborted":            codes.Aborted,
+	"OutOfRange":         codes.OutOfRange,
+	"Unimplemented":      codes.Unimplemented,
+	"Internal":           codes.Internal,
+	"Unavailable":        codes.Unavailable,
+	"DataLoss":           codes.DataLoss,
+	"Unauthenticated":    codes.Unauthenticated,
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		defs:          make(map[int64]*Definition),
+		locales:       make(map[string]bool),
+		defaultLocale: DEFAULT_LOCALE,
+	}
+}
+
+// DefaultLocale is the locale of the messages when the requested one has none
+func (r *Registry) DefaultLocale() string {
+	r.l.RLock()
+	defer r.l.RUnlock()
+	return r.defaultLocale
+}
+
+func (r *Registry) SetDefaultLocale(locale string) {
+	r.l.Lock()
+	r.defaultLocale = locale
+	r.l.Unlock()
+}
+
+// copy is a copy of def with its own Messages
+func (def *Definition) copy() *Definition {
+	c := *def
+	c.Messages = make(map[string]string, len(def.Messages))
+	for locale, msg := range def.Messages {
+		c.Messages[locale] = msg
+	}
+	return &c
+}
+
+// Register adds definitions to the DefaultRegistry
+func Register(defs ...*Definition) {
+	DefaultRegistry.Register(defs...)
+}
+
+// Register keeps a copy of defs, the messages of a code registered again are merged
+func (r *Registry) Register(defs ...*Definition) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	for _, def := range defs {
+		def = def.copy()
+		if old, ok := r.defs[def.Code]; ok {
+			for locale, msg := range old.Messages {
+				if _, ok := def.Messages[locale]; !ok {
+					def.Messages[locale] = msg
+				}
+			}
+		}
+		r.defs[def.Code] = def
+		for locale := range def.Messages {
+			r.locales[locale] = true
+		}
+	}
+}
+
+// LoadFile registers the definitions of a yaml or json file holding a list of Definition
+func (r *Registry) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var defs []*Definition
+	if err = yaml.Unmarshal(data, &defs); err != nil {
+		return fmt.Errorf("errors: load %s: %s", path, err)
+	}
+	for _, def := range defs {
+		if def.GrpcCode != "" {
+			if _, ok := grpcCodes[def.GrpcCode]; !ok {
+				return fmt.Errorf("errors: load %s: code %d has unknown grpc_code %s", path, def.Code, def.GrpcCode)
+			}
+		}
+	}
+	r.Register(defs...)
+	return nil
+}
+
+// LoadLocaleFile sets the messages of a locale from a yaml or json file mapping codes to templates, e.g.
+//
+//	10001: "processing timeout"
+func (r *Registry) LoadLocaleFile(locale, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var msgs map[string]string
+	if err = yaml.Unmarshal(data, &msgs); err != nil {
+		return fmt.Errorf("errors: load %s: %s", path, err)
+	}
+	//整个文件有效才应用
+	codes := make(map[int64]string, len(msgs))
+	for k, msg := range msgs {
+		code, err := strconv.ParseInt(k, 10, 64)
+		if err != nil {
+			return fmt.Errorf("errors: load %s: invalid code %s", path, k)
+		}
+		codes[code] = msg
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+	for code, msg := range codes {
+		def, ok := r.defs[code]
+		if !ok {
+			def = &Definition{Code: code, Messages: make(map[string]string)}
+			r.defs[code] = def
+		}
+		def.Messages[locale] = msg
+	}
+	r.locales[locale] = true
+	return nil
+}
+
+// Lookup returns a copy of the definition of code
+func (r *Registry) Lookup(code int64) (*Definition, bool) {
+	r.l.RLock()
+	defer r.l.RUnlock()
+	def, ok := r.defs[code]
+	if !ok {
+		return nil, false
+	}
+	return def.copy(), true
+}
+
+// Message renders the template of code in locale, falling back to the default locale
+func (r *Registry) Message(code int64, locale string, args ...interface{}) (string, bool) {
+	tpl, ok := r.template(code, locale)
+	if !ok {
+		return "", false
+	}
+	if len(args) == 0 {
+		return tpl, true
+	}
+	return fmt.Sprintf(tpl, args...), true
+}
+
+func (r *Registry) template(code int64, locale string) (string, bool) {
+	r.l.RLock()
+	defer r.l.RUnlock()
+	def, ok := r.defs[code]
+	if !ok {
+		return "", false
+	}
+	if tpl, ok := def.Messages[r.match(locale)]; ok {
+		return tpl, true
+	}
+	tpl, ok := def.Messages[r.defaultLocale]
+	return tpl, ok
+}
+
+// Match returns the registered locale best matching an Accept-Language like value,
+// "en-US,en;q=0.9" gives "en" when only "en" is registered
+func (r *Registry) Match(accept string) string {
+	r.l.RLock()
+	defer r.l.RUnlock()
+	return r.match(accept)
+}
+
+// match is Match, r.l is held
+func (r *Registry) match(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		tag := strings.TrimSpace(strings.Split(part, ";")[0])
+		if tag == "" {
+			continue
+		}
+		if r.locales[tag] {
+			return tag
+		}
+		for locale := range r.locales {
+			if strings.EqualFold(locale, tag) {
+				return locale
+			}
+		}
+		lang := strings.Split(tag, "-")[0]
+		for locale := range r.locales {
+			if strings.EqualFold(strings.Split(locale, "-")[0], lang) {
+				return locale
+			}
+		}
+	}
+	return r.defaultLocale
+}
+
+// HttpStatus returns the http status of code, http.StatusOK when not defined
+func (r *Registry) HttpStatus(code int64) int {
+	if def, ok := r.Lookup(code); ok && def.HttpStatus != 0 {
+		return def.HttpStatus
+	}
+	return http.StatusOK
+}
+
+// GrpcCode returns the grpc code of code, when not defined the code itself
+// if it is a valid grpc code, codes.Unknown otherwise
+func (r *Registry) GrpcCode(code int64) codes.Code {
+	if def, ok := r.Lookup(code); ok && def.GrpcCode != "" {
+		return grpcCodes[def.GrpcCode]
+	}
+	if code >= 0 && code <= int64(codes.Unauthenticated) {
+		return codes.Code(code)
+	}
+	return codes.Unknown
+}
+
+// LogLevel returns the log level of code, error when not defined
+func (r *Registry) LogLevel(code int64) zapcore.Level {
+	if def, ok := r.Lookup(code); ok && def.LogLevel != "" {
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(def.LogLevel)); err == nil {
+			return level
+		}
+	}
+	return zapcore.ErrorLevel
+}
+
+type localeKey struct{}
+
+// WithLocale stores the locale of the request in ctx
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+// LocaleFromContext returns the locale of WithLocale, or of the "locale" or
+// "accept-language" incoming metadata, or the default locale
+func LocaleFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return DefaultRegistry.DefaultLocale()
+	}
+	if locale, ok := ctx.Value(localeKey{}).(string); ok {
+		return DefaultRegistry.Match(locale)
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, key := range []string{"locale", "accept-language"} {
+			if v, ok := md[key]; ok && len(v) > 0 {
+				return DefaultRegistry.Match(v[0])
+			}
+		}
+	}
+	return DefaultRegistry.DefaultLocale()
+}
+
+// LocaleFromRequest returns the locale of the "lang" query or the Accept-Language header
+func LocaleFromRequest(r *http.Request) string {
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		return DefaultRegistry.Match(lang)
+	}
+	return DefaultRegistry.Match(r.Header.Get("Accept-Language"))
+}
@@ -0,0 +1,127 @@
+package errors
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestCommonDefinitions(t *testing.T) {
+	cases := []struct {
+		code   CommonError
+		grpc   codes.Code
+		http   int
+		zh, en string
+	}{
+		{CommonError_PROCESSING_TIMEOUT, codes.DeadlineExceeded, http.StatusGatewayTimeout, "处理超时", "processing timeout"},
+		{CommonError_RATE_LIMITED, codes.ResourceExhausted, http.StatusTooManyRequests, "请求过于频繁", "too many requests"},
+	}
+	for _, c := range cases {
+		e := NewFromCode(c.code)
+		if e.GrpcCode() != c.grpc {
+			t.Errorf("%s grpc code = %s, want %s", c.code, e.GrpcCode(), c.grpc)
+		}
+		if status := DefaultRegistry.HttpStatus(e.Code()); status != c.http {
+			t.Errorf("%s http status = %d, want %d", c.code, status, c.http)
+		}
+		if msg := e.Message("zh-CN"); msg != c.zh {
+			t.Errorf("%s zh-CN message = %q", c.code, msg)
+		}
+		if msg := e.Message("en-US"); msg != c.en {
+			t.Errorf("%s en message = %q", c.code, msg)
+		}
+	}
+}
+
+func TestStatusInLocale(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("accept-language", "en-US,en;q=0.9"))
+	err := Wrap(NewFromCode(CommonError_RATE_LIMITED), "limit")
+
+	s := StatusIn(err, LocaleFromContext(ctx))
+	if s.Code() != codes.ResourceExhausted {
+		t.Fatalf("code = %s", s.Code())
+	}
+	if e := FromGRPC(s.Err()); e.Code() != int64(CommonError_RATE_LIMITED) || e.Message("") != "too many requests" {
+		t.Fatalf("FromGRPC = %d %q", e.Code(), e.Message(""))
+	}
+	if s = StatusIn(NewFromCode(CommonError_RATE_LIMITED), LocaleFromContext(context.Background())); s.Message() != "请求过于频繁" {
+		t.Fatalf("default locale message = %q", s.Message())
+	}
+}
+
+func writeLocaleFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "locale*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestRegisterCopiesDefinitions(t *testing.T) {
+	r := NewRegistry()
+	msgs := map[string]string{"en": "gone"}
+	r.Register(&Definition{Code: 1, Messages: msgs})
+	r.Register(&Definition{Code: 1, Messages: map[string]string{"zh-CN": "没了"}})
+	msgs["en"] = "changed"
+	if len(msgs) != 1 {
+		t.Fatalf("caller map modified: %v", msgs)
+	}
+	def, _ := r.Lookup(1)
+	def.Messages["en"] = "changed"
+	if msg, _ := r.Message(1, "en"); msg != "gone" {
+		t.Fatalf("message = %q", msg)
+	}
+	if msg, _ := r.Message(1, "fr"); msg != "没了" {
+		t.Fatalf("default locale message = %q", msg)
+	}
+}
+
+func TestLoadLocaleFileIsAtomic(t *testing.T) {
+	r := NewRegistry()
+	path := writeLocaleFile(t, "1: one\nnope: bad\n")
+	defer os.Remove(path)
+	if err := r.LoadLocaleFile("en", path); err == nil {
+		t.Fatal("invalid code accepted")
+	}
+	if _, ok := r.Lookup(1); ok {
+		t.Fatal("invalid file partially applied")
+	}
+}
+
+func TestRegistryConcurrentUse(t *testing.T) {
+	r := NewRegistry()
+	r.Register(&Definition{Code: 1, Messages: map[string]string{"en": "one"}})
+	path := writeLocaleFile(t, "1: un\n")
+	defer os.Remove(path)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.Message(1, "fr")
+				r.DefaultLocale()
+			}
+		}()
+	}
+	for j := 0; j < 20; j++ {
+		if err := r.LoadLocaleFile("fr", path); err != nil {
+			t.Fatal(err)
+		}
+		r.SetDefaultLocale("en")
+	}
+	wg.Wait()
+	if msg, _ := r.Message(1, "fr"); msg != "un" {
+		t.Fatalf("message = %q", msg)
+	}
+}
@@ -0,0 +1,145 @@
+package errors
+
+import (
+	stderrors "errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
+)
+
+// Is reports whether any error in err's chain matches target, see errors.Is
+func Is(err, target error) bool { return stderrors.Is(err, target) }
+
+// As finds the first error in err's chain that matches target, see errors.As
+func As(err error, target interface{}) bool { return stderrors.As(err, target) }
+
+// Unwrap returns the error wrapped by err, see errors.Unwrap
+func Unwrap(err error) error { return stderrors.Unwrap(err) }
+
+// Code returns the code of the first *Error in err's chain,
+// OK for nil and codes.Unknown when there is none
+func Code(err error) int64 {
+	if err == nil {
+		return OK
+	}
+	var e *Error
+	if stderrors.As(err, &e) {
+		return e.code
+	}
+	return int64(codes.Unknown)
+}
+
+// GRPCStatus lets the wrapped *Error reach the grpc client, its status is the one of the *Error,
+// the wrapping messages are for the logs and stay on the server
+func (w *withStack) GRPCStatus() *status.Status {
+	return wrappedStatus(w, DefaultRegistry.DefaultLocale())
+}
+
+func (w *withMessage) GRPCStatus() *status.Status {
+	return wrappedStatus(w, DefaultRegistry.DefaultLocale())
+}
+
+func wrappedStatus(err error, locale string) *status.Status {
+	var e *Error
+	if stderrors.As(err, &e) {
+		return e.GRPCStatusIn(locale)
+	}
+	return status.New(codes.Unknown, err.Error())
+}
+
+// MultiError aggregates the failures of fan-out operations, e.g. scatter queries
+// over shards, it is safe for concurrent Append
+type MultiError struct {
+	l    sync.Mutex
+	errs []error
+}
+
+func NewMultiError() *MultiError {
+	return &MultiError{}
+}
+
+// Combine returns nil when all errs are nil, the error itself when only one is not
+// and a *MultiError otherwise
+func Combine(errs ...error) error {
+	m := NewMultiError()
+	for _, err := range errs {
+		m.Append(err)
+	}
+	return m.ErrorOrNil()
+}
+
+// Append adds err, nil is ignored and a *MultiError is flattened
+func (m *MultiError) Append(err error) {
+	if err == nil {
+		return
+	}
+	if other, ok := err.(*MultiError); ok {
+		for _, e := range other.Errors() {
+			m.Append(e)
+		}
+		return
+	}
+	m.l.Lock()
+	m.errs = append(m.errs, err)
+	m.l.Unlock()
+}
+
+// Errors returns a copy of the aggregated errors
+func (m *MultiError) Errors() []error {
+	m.l.Lock()
+	defer m.l.Unlock()
+	errs := make([]error, len(m.errs))
+	copy(errs, m.errs)
+	return errs
+}
+
+func (m *MultiError) Len() int {
+	m.l.Lock()
+	defer m.l.Unlock()
+	return len(m.errs)
+}
+
+// ErrorOrNil returns nil without errors, the single error or m
+func (m *MultiError) ErrorOrNil() error {
+	errs := m.Errors()
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return m
+}
+
+func (m *MultiError) Error() string {
+	errs := m.Errors()
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the errors, for errors.Is and errors.As of go 1.20 and later
+func (m *MultiError) Unwrap() []error { return m.Errors() }
+
+// Is reports whether one of the errors matches target
+func (m *MultiError) Is(target error) bool {
+	for _, err := range m.Errors() {
+		if stderrors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors matching target
+func (m *MultiError) As(target interface{}) bool {
+	for _, err := range m.Errors() {
+		if stderrors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
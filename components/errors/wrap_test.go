@@ -0,0 +1,56 @@
+package errors
+
+import (
+	stderrors "errors"
+	"google.golang.org/grpc/codes"
+	"testing"
+)
+
+var errNotFound = stderrors.New("not found")
+
+func TestErrorfSingleWrap(t *testing.T) {
+	inner := NewFromCode(CommonError_RATE_LIMITED)
+	e := Errorf("get user: %w", inner)
+	if e.Code() != int64(CommonError_RATE_LIMITED) {
+		t.Fatalf("code = %d", e.Code())
+	}
+	if !Is(e, inner) || Unwrap(e) != inner {
+		t.Fatal("inner error lost")
+	}
+}
+
+func TestErrorfMultipleWraps(t *testing.T) {
+	inner := NewFromCode(CommonError_PROCESSING_TIMEOUT)
+	e := Errorf("get user: %w, %w", errNotFound, inner)
+	if !Is(e, errNotFound) || !Is(e, inner) {
+		t.Fatal("wrapped errors lost")
+	}
+	if e.Code() != int64(CommonError_PROCESSING_TIMEOUT) {
+		t.Fatalf("code = %d", e.Code())
+	}
+}
+
+func TestMultiErrorUnwrap(t *testing.T) {
+	err := Combine(errNotFound, NewFromCode(CommonError_RATE_LIMITED))
+	m, ok := err.(interface{ Unwrap() []error })
+	if !ok || len(m.Unwrap()) != 2 {
+		t.Fatalf("Unwrap() []error missing on %T", err)
+	}
+	if !stderrors.Is(err, errNotFound) || Code(err) != int64(CommonError_RATE_LIMITED) {
+		t.Fatal("errors not reachable")
+	}
+	if Combine(nil, errNotFound) != errNotFound {
+		t.Fatal("single error not returned as is")
+	}
+}
+
+func TestWrappedStatus(t *testing.T) {
+	err := Wrap(NewFromCode(CommonError_RATE_LIMITED).WithTraceId("t1"), "select user")
+	s := err.(*withStack).GRPCStatus()
+	if s.Code() != codes.ResourceExhausted || s.Message() != "请求过于频繁" {
+		t.Fatalf("status = %s %q, want the status of the *Error", s.Code(), s.Message())
+	}
+	if FromGRPC(s.Err()).TraceId() != "t1" {
+		t.Fatal("trace id lost")
+	}
+}
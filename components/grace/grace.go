@@ -1,8 +1,9 @@
 package grace
 
 import (
+	"context"
+	"github.com/joselee214/j7f/components/lifecycle"
 	"github.com/joselee214/j7f/internal/log"
-	flag "github.com/spf13/pflag"
 	"net"
 	"os"
 	"sync"
@@ -21,6 +22,12 @@ type graceListener interface {
 	StartServ() error
 }
 
+// shutdowner is a graceListener draining its in-flight requests until ctx is done,
+// then force stopping
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
 const (
 	// PreSignal is the position to add filter before signal
 	PreSignal = iota
@@ -35,14 +42,15 @@ var (
 	hookableSignals      []os.Signal
 	runningServersForked bool
 
-	// DefaultTimeout is the shutdown server's timeout. default is 60s
+	// DefaultTimeout is the drain deadline of the shutdown, the listeners are force stopped after it. default is 60s
 	DefaultTimeout = 60 * time.Second
 )
 
 func init() {
-	flag.BoolVar(&isChild, "graceful", false, "listen on open fd (after forking)")
+	isChild = IsChild()
 
 	regLock = &sync.Mutex{}
+	runningServers = make(map[string]*Server)
 
 	hookableSignals = []os.Signal{
 		syscall.SIGHUP,
@@ -51,6 +59,23 @@ func init() {
 		syscall.SIGKILL,
 	}
 }
+// FLAG_GRACEFUL marked the children of the versions passing the listeners without ENV_LISTENERS,
+// it is still accepted, command lines should drop it
+const FLAG_GRACEFUL = "--graceful"
+
+// IsChild tells if the process was forked by a graceful restart
+func IsChild() bool {
+	if _, ok := os.LookupEnv(ENV_LISTENERS); ok {
+		return true
+	}
+	for _, arg := range os.Args[1:] {
+		if arg == FLAG_GRACEFUL {
+			return true
+		}
+	}
+	return false
+}
+
 func NewServer(grace graceListener) (srv *Server) {
 	regLock.Lock()
 	defer regLock.Unlock()
@@ -58,13 +83,16 @@ func NewServer(grace graceListener) (srv *Server) {
 	srv = &Server{
 		GraceListener: grace,
 		sigChan:       make(chan os.Signal),
+		SignalHooks: map[int]map[os.Signal][]func(){
+			PreSignal: make(map[os.Signal][]func()),
+			SufSignal: make(map[os.Signal][]func()),
+		},
 		isChild:       isChild,
 		//wg:            sync.WaitGroup{},
 		log:           log.NewLoggerDefault(),
-		runing:			false,
+		Lifecycle:     lifecycle.Default,
 	}
-	runningServers := make(map[string]*Server)
-	runningServers[grace.GetAddress().String()] = srv
+	runningServers[listenerKey(grace)] = srv
 
 	return
 }
@@ -0,0 +1,56 @@
+package grace
+
+import (
+	"context"
+	"sync"
+)
+
+// InFlight counts the requests being served, Wait returns as soon as there is none
+type InFlight struct {
+	l    sync.Mutex
+	n    int64
+	idle chan struct{}
+}
+
+func (f *InFlight) Begin() {
+	f.l.Lock()
+	f.n++
+	f.l.Unlock()
+}
+
+func (f *InFlight) Done() {
+	f.l.Lock()
+	f.n--
+	if f.n == 0 && f.idle != nil {
+		close(f.idle)
+		f.idle = nil
+	}
+	f.l.Unlock()
+}
+
+func (f *InFlight) Count() int64 {
+	f.l.Lock()
+	defer f.l.Unlock()
+	return f.n
+}
+
+// Wait blocks until no request is in flight or ctx is done
+func (f *InFlight) Wait(ctx context.Context) error {
+	f.l.Lock()
+	if f.n <= 0 {
+		f.l.Unlock()
+		return nil
+	}
+	if f.idle == nil {
+		f.idle = make(chan struct{})
+	}
+	idle := f.idle
+	f.l.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,38 @@
+package grace
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestInFlightWait(t *testing.T) {
+	f := &InFlight{}
+	if err := f.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Begin()
+	f.Begin()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := f.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Wait with requests in flight = %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- f.Wait(context.Background()) }()
+	f.Done()
+	if f.Count() != 1 {
+		t.Fatalf("count = %d", f.Count())
+	}
+	f.Done()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait not released when idle")
+	}
+}
@@ -0,0 +1,283 @@
+package grace
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ENV_LISTENERS lists the addresses of the listeners passed to the child,
+// in the order of their fds starting at 3
+const ENV_LISTENERS = "J7F_GRACE_LISTENERS"
+
+const listenFdsStart = 3
+
+// Manager runs several graceListeners, e.g. a GrpcServer and an HttpServer,
+// under one Server: they are forked, restarted and shut down together
+type Manager struct {
+	*Server
+	group *listenerGroup
+}
+
+func NewManager(gls ...graceListener) *Manager {
+	g := &listenerGroup{}
+	g.gls = append(g.gls, gls...)
+	return &Manager{
+		Server: NewServer(g),
+		group:  g,
+	}
+}
+
+// Add registers one more listener, before ListenAndServe
+func (m *Manager) Add(gl graceListener) {
+	regLock.Lock()
+	defer regLock.Unlock()
+	delete(runningServers, listenerKey(m.group))
+	m.group.gls = append(m.group.gls, gl)
+	runningServers[listenerKey(m.group)] = m.Server
+}
+
+// listenerGroup is a graceListener made of several ones
+type listenerGroup struct {
+	gls []graceListener
+}
+
+func (g *listenerGroup) GetAddress() *net.TCPAddr {
+	if len(g.gls) == 0 {
+		return &net.TCPAddr{}
+	}
+	return g.gls[0].GetAddress()
+}
+
+func (g *listenerGroup) GetListener() *net.TCPListener {
+	if len(g.gls) == 0 {
+		return nil
+	}
+	return g.gls[0].GetListener()
+}
+
+func (g *listenerGroup) GracefulStop() {
+	g.each(graceListener.GracefulStop)
+}
+
+func (g *listenerGroup) Stop() {
+	g.each(graceListener.Stop)
+}
+
+// Shutdown drains all the listeners concurrently, the first error is returned
+func (g *listenerGroup) Shutdown(ctx context.Context) error {
+	errs := make([]error, len(g.gls))
+	wg := sync.WaitGroup{}
+	for i, gl := range g.gls {
+		wg.Add(1)
+		go func(i int, gl graceListener) {
+			defer wg.Done()
+			if s, ok := gl.(shutdowner); ok {
+				errs[i] = s.Shutdown(ctx)
+			} else {
+				gl.GracefulStop()
+			}
+		}(i, gl)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartServ serves all the listeners until they all stop, the first error is returned
+func (g *listenerGroup) StartServ() error {
+	errs := make([]error, len(g.gls))
+	wg := sync.WaitGroup{}
+	for i, gl := range g.gls {
+		wg.Add(1)
+		go func(i int, gl graceListener) {
+			defer wg.Done()
+			errs[i] = gl.StartServ()
+		}(i, gl)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil && err != http.ErrServerClosed {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *listenerGroup) each(f func(gl graceListener)) {
+	wg := sync.WaitGroup{}
+	for _, gl := range g.gls {
+		wg.Add(1)
+		go func(gl graceListener) {
+			defer wg.Done()
+			f(gl)
+		}(gl)
+	}
+	wg.Wait()
+}
+
+// listenersOf returns the listeners of gl, the members for a group
+func listenersOf(gl graceListener) []graceListener {
+	if g, ok := gl.(*listenerGroup); ok {
+		return g.gls
+	}
+	return []graceListener{gl}
+}
+
+func listenerKey(gl graceListener) string {
+	addrs := make([]string, 0)
+	for _, l := range listenersOf(gl) {
+		addrs = append(addrs, l.GetAddress().String())
+	}
+	return strings.Join(addrs, ",")
+}
+
+// inheritedFiles returns the listener files of all the running servers sorted by
+// address, and the ENV_LISTENERS value describing them
+func inheritedFiles() ([]*os.File, string, error) {
+	gls := make([]graceListener, 0)
+	for _, srv := range runningServers {
+		gls = append(gls, listenersOf(srv.GraceListener)...)
+	}
+	sort.Slice(gls, func(i, j int) bool {
+		return gls[i].GetAddress().String() < gls[j].GetAddress().String()
+	})
+
+	files := make([]*os.File, 0, len(gls))
+	addrs := make([]string, 0, len(gls))
+	for _, gl := range gls {
+		lis := gl.GetListener()
+		if lis == nil {
+			continue
+		}
+		f, err := lis.File()
+		if err != nil {
+			for _, opened := range files {
+				_ = opened.Close()
+			}
+			return nil, "", err
+		}
+		files = append(files, f)
+		addrs = append(addrs, gl.GetAddress().String())
+	}
+	return files, strings.Join(addrs, ","), nil
+}
+
+var (
+	inheritedLock sync.Mutex
+	inherited     map[string]*net.TCPListener
+)
+
+// ListenTCP returns the listener of addr inherited from the parent on a graceful
+// restart, or passed by systemd, or a new one
+func ListenTCP(addr *net.TCPAddr) (*net.TCPListener, error) {
+	return ListenTCPNamed("", addr)
+}
+
+// ListenTCPNamed is ListenTCP adopting first the systemd socket named name, see FileDescriptorName=
+func ListenTCPNamed(name string, addr *net.TCPAddr) (*net.TCPListener, error) {
+	if lis, err := inheritedListener(addr.String()); lis != nil || err != nil {
+		return lis, err
+	}
+	if lis := activatedListenerOf(name, addr); lis != nil {
+		return lis, nil
+	}
+	if ReusePort {
+		return ListenTCPReusePort(addr)
+	}
+	return net.ListenTCP("tcp", addr)
+}
+
+// ListenTCPReusePort listens on addr with SO_REUSEPORT, the processes listening
+// on the same port share its connections
+func ListenTCPReusePort(addr *net.TCPAddr) (*net.TCPListener, error) {
+	lc := net.ListenConfig{Control: reusePortControl}
+	l, err := lc.Listen(context.Background(), "tcp", addr.String())
+	if err != nil {
+		return nil, err
+	}
+	return l.(*net.TCPListener), nil
+}
+
+func inheritedListener(addr string) (*net.TCPListener, error) {
+	inheritedLock.Lock()
+	defer inheritedLock.Unlock()
+
+	if err := loadInherited(); err != nil {
+		return nil, err
+	}
+	lis, ok := inherited[addr]
+	if !ok {
+		return nil, nil
+	}
+	delete(inherited, addr)
+	return lis, nil
+}
+
+// loadInherited adopts the listeners passed by the parent, once, inheritedLock is held
+func loadInherited() error {
+	if inherited != nil {
+		return nil
+	}
+	inherited = make(map[string]*net.TCPListener)
+	env := os.Getenv(ENV_LISTENERS)
+	if env == "" {
+		return nil
+	}
+	addrs := strings.Split(env, ",")
+	for i, a := range addrs {
+		f := os.NewFile(uintptr(listenFdsStart+i), "grace-listener-"+strconv.Itoa(i))
+		l, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			//其余的fd也不再使用
+			for j := i + 1; j < len(addrs); j++ {
+				_ = os.NewFile(uintptr(listenFdsStart+j), "grace-listener-"+strconv.Itoa(j)).Close()
+			}
+			return err
+		}
+		tl, ok := l.(*net.TCPListener)
+		if !ok {
+			_ = l.Close()
+			continue
+		}
+		inherited[a] = tl
+	}
+	return nil
+}
+
+// closeUnclaimed closes the listeners inherited from the parent or passed by systemd that
+// no server adopted, once the servers listen, so this process does not hold their ports.
+// It returns their addresses
+func closeUnclaimed() []string {
+	closed := make([]string, 0)
+	inheritedLock.Lock()
+	if err := loadInherited(); err == nil {
+		for addr, lis := range inherited {
+			_ = lis.Close()
+			delete(inherited, addr)
+			closed = append(closed, addr)
+		}
+	}
+	inheritedLock.Unlock()
+
+	activatedSockets()
+	activatedLock.Lock()
+	for _, a := range activated {
+		_ = a.lis.Close()
+		closed = append(closed, a.lis.Addr().String())
+	}
+	activated = nil
+	activatedLock.Unlock()
+	sort.Strings(closed)
+	return closed
+}
@@ -0,0 +1,114 @@
+package grace
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testListener struct {
+	lis *net.TCPListener
+
+	l       sync.Mutex
+	stopped chan struct{}
+	drained bool
+	err     error
+}
+
+func newTestListener(t *testing.T) *testListener {
+	lis, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testListener{lis: lis, stopped: make(chan struct{})}
+}
+
+func (l *testListener) GetAddress() *net.TCPAddr      { return l.lis.Addr().(*net.TCPAddr) }
+func (l *testListener) GetListener() *net.TCPListener { return l.lis }
+
+func (l *testListener) StartServ() error {
+	<-l.stopped
+	return l.err
+}
+
+func (l *testListener) GracefulStop() { l.Stop() }
+
+func (l *testListener) Stop() {
+	l.l.Lock()
+	defer l.l.Unlock()
+	select {
+	case <-l.stopped:
+	default:
+		close(l.stopped)
+		_ = l.lis.Close()
+	}
+}
+
+func (l *testListener) Shutdown(ctx context.Context) error {
+	l.l.Lock()
+	l.drained = true
+	l.l.Unlock()
+	l.Stop()
+	return nil
+}
+
+func TestListenerGroupServesAndShutsDown(t *testing.T) {
+	a, b := newTestListener(t), newTestListener(t)
+	b.err = errors.New("serve failed")
+	g := &listenerGroup{gls: []graceListener{a, b}}
+
+	served := make(chan error, 1)
+	go func() { served <- g.StartServ() }()
+
+	if err := g.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != b.err {
+		t.Fatalf("StartServ error = %v, want %v", err, b.err)
+	}
+	if !a.drained || !b.drained {
+		t.Fatal("listeners not drained")
+	}
+	if g.GetAddress() != a.GetAddress() {
+		t.Fatal("group address is not the one of its first listener")
+	}
+}
+
+func TestInheritedFilesCoverTheManager(t *testing.T) {
+	a, b := newTestListener(t), newTestListener(t)
+	defer a.Stop()
+	defer b.Stop()
+
+	m := NewManager(a)
+	m.Add(b)
+	defer func() {
+		regLock.Lock()
+		delete(runningServers, listenerKey(m.group))
+		regLock.Unlock()
+	}()
+
+	files, env, err := inheritedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		_ = f.Close()
+	}
+	addrs := strings.Split(env, ",")
+	if len(files) != len(addrs) {
+		t.Fatalf("%d files for %s", len(files), env)
+	}
+	for _, l := range []*testListener{a, b} {
+		if !strings.Contains(env, l.GetAddress().String()) {
+			t.Fatalf("%s missing in %s", l.GetAddress(), env)
+		}
+	}
+	for i := 1; i < len(addrs); i++ {
+		if addrs[i-1] > addrs[i] {
+			t.Fatalf("addresses not sorted: %s", env)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package grace
+
+import (
+	"bufio"
+	"errors"
+	"github.com/joselee214/j7f/components/lifecycle"
+	"github.com/joselee214/j7f/internal/log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ENV_WORKER is the index of a worker forked by a Master
+const ENV_WORKER = "J7F_GRACE_WORKER"
+
+const DEFAULT_RESTART_DELAY = time.Second
+
+// ReusePort makes ListenTCP listen with SO_REUSEPORT, it is on in the workers of a Master
+var ReusePort = os.Getenv(ENV_WORKER) != ""
+
+// IsWorker tells if the process is a worker forked by a Master
+func IsWorker() bool {
+	return os.Getenv(ENV_WORKER) != ""
+}
+
+// WorkerId is the index of the worker, -1 when the process is not a worker
+func WorkerId() int {
+	id, err := strconv.Atoi(os.Getenv(ENV_WORKER))
+	if err != nil {
+		return -1
+	}
+	return id
+}
+
+// Master forks Workers copies of the process sharing their ports with SO_REUSEPORT.
+// A worker which exits is restarted after RestartDelay, SIGHUP rolls the workers
+// one at a time, each one being stopped only once its replacement is ready.
+// The listeners of the master itself, e.g. the admin one, are not shared.
+//
+//	if !grace.IsWorker() {
+//		err := grace.NewMaster(4).Run()
+//		...
+//	}
+type Master struct {
+	Workers      int
+	RestartDelay time.Duration
+	// ReadyTimeout is how long a new worker has to be ready, DefaultReadyTimeout when 0
+	ReadyTimeout time.Duration
+	// Args are the arguments of the workers, those of the master by default
+	Args []string
+
+	log     log.Logger
+	workers []*worker
+	exited  chan *worker
+	restart chan *worker
+	quit    chan struct{}
+}
+
+type worker struct {
+	id    int
+	cmd   *exec.Cmd
+	ready chan struct{}
+	done  chan struct{}
+	err   error
+}
+
+// NewMaster builds a Master of n workers, runtime.NumCPU() when n <= 0
+func NewMaster(n int) *Master {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	return &Master{
+		Workers:      n,
+		RestartDelay: DEFAULT_RESTART_DELAY,
+		Args:         os.Args[1:],
+		log:          log.NewLoggerDefault(),
+		exited:       make(chan *worker),
+		restart:      make(chan *worker),
+		quit:         make(chan struct{}),
+	}
+}
+
+// Run starts the workers and supervises them until SIGINT or SIGTERM, which is
+// forwarded to the workers
+func (m *Master) Run() error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	defer close(m.quit)
+
+	m.workers = make([]*worker, m.Workers)
+	for i := range m.workers {
+		w, err := m.start(i)
+		if err != nil {
+			m.stop(syscall.SIGTERM)
+			return err
+		}
+		m.workers[i] = w
+	}
+	for _, w := range m.workers {
+		if !m.waitReady(w) {
+			m.log.Errorf(" => worker %d pid %d not ready", w.id, w.cmd.Process.Pid)
+		}
+	}
+	_, _ = SdNotify(SD_NOTIFY_READY)
+
+	for {
+		select {
+		case sig := <-sigs:
+			m.log.Infof(" => master Received %v", sig)
+			if sig == syscall.SIGHUP {
+				_, _ = SdNotify(SD_NOTIFY_RELOADING)
+				m.roll()
+				_, _ = SdNotify(SD_NOTIFY_READY)
+				continue
+			}
+			_, _ = SdNotify(SD_NOTIFY_STOPPING)
+			m.stop(sig)
+			return nil
+		case w := <-m.exited:
+			if m.workers[w.id] != w {
+				// replaced by a roll
+				continue
+			}
+			m.log.Errorf(" => worker %d pid %d exited: %v, restart in %s", w.id, w.cmd.Process.Pid, w.err, m.RestartDelay)
+			m.restartLater(w)
+		case w := <-m.restart:
+			if m.workers[w.id] != w {
+				// replaced by a roll meanwhile
+				continue
+			}
+			nw, err := m.start(w.id)
+			if err != nil {
+				m.log.Errorf(" => worker %d restart error: %s, retry in %s", w.id, err, m.RestartDelay)
+				m.restartLater(w)
+				continue
+			}
+			m.workers[w.id] = nw
+		}
+	}
+}
+
+// restartLater hands w to the restart case of Run after RestartDelay, the signals
+// are handled meanwhile
+func (m *Master) restartLater(w *worker) {
+	time.AfterFunc(m.RestartDelay, func() {
+		select {
+		case m.restart <- w:
+		case <-m.quit:
+		}
+	})
+}
+
+// start forks worker id, it reports its readiness on a pipe like a graceful restart child
+func (m *Master) start(id int) (*worker, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(path, m.Args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{readyW}
+	cmd.Env = append(workerEnv(),
+		ENV_WORKER+"="+strconv.Itoa(id),
+		ENV_READY_FD+"="+strconv.Itoa(listenFdsStart),
+	)
+	err = cmd.Start()
+	_ = readyW.Close()
+	if err != nil {
+		_ = readyR.Close()
+		return nil, err
+	}
+	m.log.Infof(" => worker %d started, pid %d", id, cmd.Process.Pid)
+
+	w := &worker{
+		id:    id,
+		cmd:   cmd,
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer readyR.Close()
+		line, _ := bufio.NewReader(readyR).ReadString('\n')
+		if line == readyMsg {
+			close(w.ready)
+		}
+	}()
+	go func() {
+		w.err = cmd.Wait()
+		close(w.done)
+		select {
+		case m.exited <- w:
+		case <-m.quit:
+		}
+	}()
+	return w, nil
+}
+
+func (m *Master) waitReady(w *worker) bool {
+	timeout := m.ReadyTimeout
+	if timeout <= 0 {
+		timeout = DefaultReadyTimeout
+	}
+	select {
+	case <-w.ready:
+		return true
+	case <-w.done:
+		return false
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// roll replaces the workers one at a time, it stops at the first replacement not ready
+func (m *Master) roll() {
+	for i, old := range m.workers {
+		nw, err := m.start(i)
+		if err == nil && !m.waitReady(nw) {
+			err = errors.New("not ready")
+			_ = nw.cmd.Process.Kill()
+		}
+		if err != nil {
+			m.log.Errorf(" => roll worker %d error: %s, keep the old workers", i, err)
+			return
+		}
+		m.workers[i] = nw
+		m.signal(old, syscall.SIGTERM)
+		m.log.Infof(" => worker %d rolled, pid %d -> %d", i, old.cmd.Process.Pid, nw.cmd.Process.Pid)
+	}
+}
+
+func (m *Master) stop(sig os.Signal) {
+	for _, w := range m.workers {
+		if w != nil {
+			go m.signal(w, sig)
+		}
+	}
+	for _, w := range m.workers {
+		if w != nil {
+			<-w.done
+		}
+	}
+}
+
+// signal sends sig to w and waits for it to exit, it is killed after the drain deadline
+func (m *Master) signal(w *worker, sig os.Signal) {
+	_ = w.cmd.Process.Signal(sig)
+	select {
+	case <-w.done:
+	case <-time.After(DefaultTimeout + lifecycle.DEFAULT_HOOK_TIMEOUT):
+		m.log.Errorf(" => worker %d pid %d not stopped, killed", w.id, w.cmd.Process.Pid)
+		_ = w.cmd.Process.Kill()
+		<-w.done
+	}
+}
+
+// workerEnv is the env of the master without the grace and systemd ones, the master
+// talks to systemd for its workers
+func workerEnv() []string {
+	env := make([]string, 0)
+	for _, e := range childEnv() {
+		if strings.HasPrefix(e, ENV_NOTIFY_SOCKET+"=") {
+			continue
+		}
+		env = append(env, e)
+	}
+	return env
+}
@@ -0,0 +1,88 @@
+package grace
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const envWorkerLog = "J7F_GRACE_TEST_WORKER_LOG"
+
+// TestWorkerProcess is the worker forked by the Master tests, it reports ready and exits
+func TestWorkerProcess(t *testing.T) {
+	if !IsWorker() {
+		t.Skip("run by the Master tests")
+	}
+	f, err := os.OpenFile(os.Getenv(envWorkerLog), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		os.Exit(2)
+	}
+	_, _ = f.WriteString(strconv.Itoa(WorkerId()) + "\n")
+	_ = f.Close()
+	_ = notifyParent()
+	os.Exit(1)
+}
+
+func newTestMaster(t *testing.T, delay time.Duration) (*Master, string) {
+	f, err := ioutil.TempFile("", "workers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	_ = os.Setenv(envWorkerLog, f.Name())
+
+	m := NewMaster(1)
+	m.Args = []string{"-test.run=^TestWorkerProcess$"}
+	m.RestartDelay = delay
+	return m, f.Name()
+}
+
+func starts(path string) int {
+	data, _ := ioutil.ReadFile(path)
+	return strings.Count(string(data), "\n")
+}
+
+func runMaster(t *testing.T, m *Master, until func() bool) {
+	done := make(chan error, 1)
+	go func() { done <- m.Run() }()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !until() {
+		if time.Now().After(deadline) {
+			t.Fatal("workers not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_ = syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("master did not stop on SIGTERM")
+	}
+}
+
+func TestMasterRestartsWorkers(t *testing.T) {
+	m, path := newTestMaster(t, 10*time.Millisecond)
+	defer os.Remove(path)
+	runMaster(t, m, func() bool { return starts(path) >= 3 })
+}
+
+func TestMasterHandlesSignalsDuringRestartDelay(t *testing.T) {
+	m, path := newTestMaster(t, time.Hour)
+	defer os.Remove(path)
+	runMaster(t, m, func() bool {
+		if starts(path) < 1 {
+			return false
+		}
+		//worker 退出后在等待重启中
+		time.Sleep(100 * time.Millisecond)
+		return true
+	})
+}
@@ -0,0 +1,107 @@
+package grace
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ENV_READY_FD is the fd of the pipe the child reports its readiness on
+const ENV_READY_FD = "J7F_GRACE_READY_FD"
+
+const readyMsg = "ready\n"
+
+// DefaultReadyTimeout is how long the parent waits for the child to be ready
+var DefaultReadyTimeout = 30 * time.Second
+
+// ready runs the ReadyHooks, registers the service and, in a child,
+// tells the parent to drain and exit, in a worker tells the Master
+func (srv *Server) ready() error {
+	for _, h := range srv.ReadyHooks {
+		if err := h(); err != nil {
+			return err
+		}
+	}
+
+	if srv.Rr.RegisterData != nil && srv.Rr.RegisterFunc != nil {
+		if err := srv.Rr.Register(); err != nil {
+			return err
+		}
+	}
+
+	if IsWorker() {
+		return notifyParent()
+	}
+	if srv.isChild {
+		if err := notifyParent(); err != nil {
+			return err
+		}
+		// the child is the main process of the unit from now on
+		srv.sdNotify("MAINPID=" + strconv.Itoa(os.Getpid()) + "\n" + SD_NOTIFY_READY)
+		return nil
+	}
+	srv.sdNotify(SD_NOTIFY_READY)
+	return nil
+}
+
+func notifyParent() error {
+	fd, err := strconv.Atoi(os.Getenv(ENV_READY_FD))
+	if err != nil {
+		// started by a parent without the readiness pipe
+		process, err := os.FindProcess(os.Getppid())
+		if err != nil {
+			return err
+		}
+		return process.Signal(syscall.SIGTERM)
+	}
+
+	f := os.NewFile(uintptr(fd), "grace-ready")
+	defer f.Close()
+	_, err = f.Write([]byte(readyMsg))
+	return err
+}
+
+// waitChild shuts the parent down once the child is ready, the parent keeps
+// serving if the child exits or is not ready in time
+func (srv *Server) waitChild(cmd *exec.Cmd, r *os.File) {
+	defer r.Close()
+
+	timeout := srv.ReadyTimeout
+	if timeout <= 0 {
+		timeout = DefaultReadyTimeout
+	}
+
+	ready := make(chan bool, 1)
+	go func() {
+		buf := make([]byte, len(readyMsg))
+		n, _ := r.Read(buf)
+		ready <- string(buf[:n]) == readyMsg
+	}()
+
+	select {
+	case ok := <-ready:
+		if ok {
+			srv.log.Infof(" => child %d is ready, shutting down %d", cmd.Process.Pid, syscall.Getpid())
+			go func() {
+				_ = cmd.Wait()
+			}()
+			atomic.StoreInt32(&srv.handedOff, 1)
+			srv.shutdown()
+			return
+		}
+		err := cmd.Wait()
+		srv.log.Errorf("Restart: child %d exited before ready: %v, keep serving", cmd.Process.Pid, err)
+	case <-time.After(timeout):
+		srv.log.Errorf("Restart: child %d not ready in %s, killed, keep serving", cmd.Process.Pid, timeout)
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}
+	srv.sdNotify(SD_NOTIFY_READY)
+
+	regLock.Lock()
+	runningServersForked = false
+	regLock.Unlock()
+}
@@ -0,0 +1,35 @@
+package grace
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestNotifyParentWritesTheReadyPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	old, had := os.LookupEnv(ENV_READY_FD)
+	_ = os.Setenv(ENV_READY_FD, strconv.Itoa(int(w.Fd())))
+	defer func() {
+		if had {
+			_ = os.Setenv(ENV_READY_FD, old)
+		} else {
+			_ = os.Unsetenv(ENV_READY_FD)
+		}
+	}()
+
+	//notifyParent 关闭 w 的 fd
+	if err = notifyParent(); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, _ := r.Read(buf)
+	if string(buf[:n]) != readyMsg {
+		t.Fatalf("read %q, want %q", buf[:n], readyMsg)
+	}
+}
@@ -1,14 +1,19 @@
 package grace
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/joselee214/j7f/components/lifecycle"
 	"github.com/joselee214/j7f/internal/log"
 	"github.com/joselee214/j7f/components/service_register"
 	"os"
 	"os/exec"
 	"os/signal"
+	"strconv"
+	"strings"
 	//"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -25,27 +30,99 @@ type Server struct {
 
 	log log.Logger
 
-	runing bool
+	//以下标记在信号、子进程和服务的goroutine之间读写, 用atomic访问
+	runing int32
+
+	// ReadyHooks run after the listener serves, e.g. health checks, the first error aborts the start
+	ReadyHooks []func() error
+	// ReadyTimeout is how long the parent waits for the child to be ready on a restart
+	ReadyTimeout time.Duration
+
+	// handedOff is set when a ready child took over, the parent must not deregister then
+	handedOff int32
+
+	// Lifecycle runs the start hooks before serving and the stop hooks on shutdown, lifecycle.Default by default
+	Lifecycle *lifecycle.Lifecycle
+	stopping  int32
 }
 
 func (srv *Server) ListenAndServe() (err error) {
-	srv.runing = true
+	atomic.StoreInt32(&srv.runing, 1)
 	go srv.handleSignals()
 
 	srv.log.Info( " => server run as child : ",srv.isChild )
 
-	if srv.isChild {
-		process, err := os.FindProcess(os.Getppid())
-		if err != nil {
-			return err
-		}
-		err = process.Signal(syscall.SIGTERM)  //os.Interrupt
-		if err != nil {
-			return err
-		}
+	if err = srv.Lifecycle.Start(context.Background()); err != nil {
+		atomic.StoreInt32(&srv.runing, 0)
+		return err
+	}
+	srv.stopHooks()
+	for _, addr := range closeUnclaimed() {
+		srv.log.Infof(" => closed the inherited listener %s, no server listens on it", addr)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve()
+	}()
+
+	//服务、注册都就绪后才通知父进程退出
+	if err = srv.ready(); err != nil {
+		srv.log.Errorf(" => server not ready : %s", err)
+		srv.GraceListener.Stop()
+		<-served
+		return err
+	}
+
+	err = <-served
+	//等待其他组件关闭
+	if atomic.LoadInt32(&srv.stopping) == 1 {
+		<-srv.Lifecycle.Stopped()
 	}
+	return err
+}
+
+// stopHooks adds the deregistration and the listener close to the Lifecycle
+func (srv *Server) stopHooks() {
+	srv.Lifecycle.OnStop(lifecycle.Hook{
+		Name:     "grace.deregister",
+		Priority: lifecycle.PRIORITY_DEREGISTER,
+		Func: func(ctx context.Context) error {
+			if srv.Rr.RegisterData == nil || atomic.LoadInt32(&srv.handedOff) == 1 {
+				return nil
+			}
+			return srv.Rr.DeRegister()
+		},
+	})
+	srv.Lifecycle.OnStop(lifecycle.Hook{
+		Name:     "grace.drain " + listenerKey(srv.GraceListener),
+		Priority: lifecycle.PRIORITY_DRAIN,
+		Timeout:  DefaultTimeout + lifecycle.DEFAULT_HOOK_TIMEOUT,
+		Func: func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+			defer cancel()
+			return srv.drain(ctx)
+		},
+	})
+}
 
-	return srv.Serve()
+// drain stops accepting and waits for the in-flight requests until ctx is done,
+// the listener is force stopped then
+func (srv *Server) drain(ctx context.Context) (err error) {
+	start := time.Now()
+	if DefaultTimeout <= 0 {
+		srv.GraceListener.Stop()
+	} else if s, ok := srv.GraceListener.(shutdowner); ok {
+		err = s.Shutdown(ctx)
+	} else {
+		err = srv.Close()
+	}
+	if err != nil {
+		srv.log.Errorf("%d drain error: %s", syscall.Getpid(), err)
+	} else {
+		srv.log.Infof(" => Server %s drained in %s / pid %d ", listenerKey(srv.GraceListener), time.Since(start), syscall.Getpid())
+	}
+	return err
 }
 
 func (srv *Server) Serve() (err error) {
@@ -53,7 +130,7 @@ func (srv *Server) Serve() (err error) {
 	err = srv.GraceListener.StartServ()
 	//srv.wg.Wait()
 	//fmt.Println("==================af StartServ")
-	srv.runing = false
+	atomic.StoreInt32(&srv.runing, 0)
 	return
 }
 
@@ -66,7 +143,7 @@ func (srv *Server) handleSignals() {
 
 
 	for {
-		if srv.runing == false {
+		if atomic.LoadInt32(&srv.runing) == 0 {
 			break
 		}
 		sig = <-srv.sigChan
@@ -77,9 +154,11 @@ func (srv *Server) handleSignals() {
 
 		switch sig {
 		case syscall.SIGHUP:
+			srv.sdNotify(SD_NOTIFY_RELOADING)
 			err := srv.fork()
 			if err != nil {
 				srv.log.Errorf("Fork err: %s", err)
+				srv.sdNotify(SD_NOTIFY_READY)
 			}
 		case syscall.SIGINT:
 			DefaultTimeout = 0
@@ -108,22 +187,21 @@ func (srv *Server) signalHooks(ppFlag int, sig os.Signal) {
 
 func (srv *Server) shutdown() {
 
-	if srv.runing==false {
+	if !atomic.CompareAndSwapInt32(&srv.runing, 1, 0) {
 		return
 	}
-	srv.runing = false
+	atomic.StoreInt32(&srv.stopping, 1)
 
-	if srv.Rr.RegisterData != nil {
-		srv.Rr.DeRegister()
+	if atomic.LoadInt32(&srv.handedOff) == 0 {
+		srv.sdNotify(SD_NOTIFY_STOPPING)
 	}
 
-	if DefaultTimeout > 0 {
-		time.AfterFunc( DefaultTimeout , func() {
-			srv.shutClose()
-		}  )
-	} else {
-		srv.shutClose()
-	}
+	//注销、停止监听、关闭消费者/生产者/数据库、同步日志
+	go func() {
+		if err := srv.Lifecycle.Stop(context.Background()); err != nil {
+			srv.log.Errorf(" => shutdown error : %s", err)
+		}
+	}()
 	//srv.serverTimeout(DefaultTimeout)
 	//	srv.wg.Done()
 	//else {
@@ -131,15 +209,6 @@ func (srv *Server) shutdown() {
 	//}
 }
 
-func (srv *Server) shutClose(){
-	err := srv.Close()
-	if err != nil {
-		srv.log.Errorf("%d Listener.Close() error:", syscall.Getpid(), err)
-	} else {
-		srv.log.Infof(" => Server %s closed / pid %d ", srv.GraceListener.GetAddress().String() , syscall.Getpid() )
-	}
-}
-
 //func (srv *Server) serverTimeout(d time.Duration) {
 //	defer func() {
 //		if r := recover(); r != nil {
@@ -182,41 +251,56 @@ func (srv *Server) fork() (err error) {
 	}
 	runningServersForked = true
 
-	var files = make([]*os.File, len(runningServers))
-	for _, srvPtr := range runningServers {
-		switch srvPtr.GraceListener.(type) {
-		case graceListener:
-			fl, err := srvPtr.GraceListener.GetListener().File()
-			if err != nil {
-				srv.log.Errorf("Get listener file error: %s", err)
-				continue
-			}
-			files = append(files, fl)
-		}
+	files, addrs, err := inheritedFiles()
+	if err != nil {
+		srv.log.Errorf("Get listener file error: %s", err)
+		runningServersForked = false
+		return err
 	}
 
 	path := os.Args[0]
 	var args []string
 	if len(os.Args) > 1 {
 		for _, arg := range os.Args[1:] {
-			if arg == "--graceful" {
+			if arg == FLAG_GRACEFUL {
 				continue
 			}
 			args = append(args, arg)
 		}
 	}
-	args = append(args, "--graceful")
 
 	srv.log.Info(" ==> fork run : ",path,args)
 
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		for _, f := range files {
+			_ = f.Close()
+		}
+		runningServersForked = false
+		return err
+	}
+
 	cmd := exec.Command(path, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	cmd.ExtraFiles = files
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(childEnv(),
+		ENV_LISTENERS+"="+addrs,
+		ENV_READY_FD+"="+strconv.Itoa(listenFdsStart+len(files)),
+	)
 	err = cmd.Start()
+	for _, f := range files {
+		_ = f.Close()
+	}
+	_ = readyW.Close()
 	if err != nil {
+		_ = readyR.Close()
+		runningServersForked = false
 		srv.log.Errorf("Restart: Failed to launch, error: %s", err)
+		return
 	}
+
+	go srv.waitChild(cmd, readyR)
 	return
 }
 
@@ -235,3 +319,15 @@ func (srv *Server) RegisterSignalHook(ppFlag int, sig os.Signal, f func()) (err
 	err = fmt.Errorf("signal '%+v' is not supported", sig)
 	return
 }
+
+// childEnv is the environment of the process without the grace variables
+func childEnv() []string {
+	env := make([]string, 0)
+	for _, e := range os.Environ() {
+		if strings.HasPrefix(e, ENV_LISTENERS+"=") || strings.HasPrefix(e, ENV_READY_FD+"=") {
+			continue
+		}
+		env = append(env, e)
+	}
+	return env
+}
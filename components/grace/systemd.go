@@ -0,0 +1,133 @@
+package grace
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// systemd socket activation, see sd_listen_fds(3) and sd_notify(3)
+const (
+	ENV_LISTEN_FDS      = "LISTEN_FDS"
+	ENV_LISTEN_PID      = "LISTEN_PID"
+	ENV_LISTEN_FDNAMES  = "LISTEN_FDNAMES"
+	ENV_NOTIFY_SOCKET   = "NOTIFY_SOCKET"
+	SD_NOTIFY_READY     = "READY=1"
+	SD_NOTIFY_RELOADING = "RELOADING=1"
+	SD_NOTIFY_STOPPING  = "STOPPING=1"
+)
+
+var (
+	activatedOnce sync.Once
+	activatedLock sync.Mutex
+	activated     []*activatedListener
+)
+
+type activatedListener struct {
+	name string
+	lis  *net.TCPListener
+}
+
+// activatedSockets adopts the sockets passed by systemd, once, the LISTEN_* env is
+// unset so they are not adopted again by the children
+func activatedSockets() {
+	activatedOnce.Do(func() {
+		defer func() {
+			_ = os.Unsetenv(ENV_LISTEN_FDS)
+			_ = os.Unsetenv(ENV_LISTEN_PID)
+			_ = os.Unsetenv(ENV_LISTEN_FDNAMES)
+		}()
+
+		pid, err := strconv.Atoi(os.Getenv(ENV_LISTEN_PID))
+		if err != nil || pid != os.Getpid() {
+			return
+		}
+		n, err := strconv.Atoi(os.Getenv(ENV_LISTEN_FDS))
+		if err != nil || n <= 0 {
+			return
+		}
+		names := strings.Split(os.Getenv(ENV_LISTEN_FDNAMES), ":")
+
+		for i := 0; i < n; i++ {
+			name := ""
+			if i < len(names) {
+				name = names[i]
+			}
+			f := os.NewFile(uintptr(listenFdsStart+i), "systemd-"+name)
+			l, err := net.FileListener(f)
+			_ = f.Close()
+			if err != nil {
+				continue
+			}
+			tl, ok := l.(*net.TCPListener)
+			if !ok {
+				_ = l.Close()
+				continue
+			}
+			activated = append(activated, &activatedListener{name: name, lis: tl})
+		}
+	})
+}
+
+// activatedListenerOf returns the systemd socket named name, or else bound to addr
+func activatedListenerOf(name string, addr *net.TCPAddr) *net.TCPListener {
+	activatedSockets()
+
+	activatedLock.Lock()
+	defer activatedLock.Unlock()
+
+	match := -1
+	for i, a := range activated {
+		if name != "" && a.name == name {
+			match = i
+			break
+		}
+		if match < 0 && sameAddr(a.lis.Addr().(*net.TCPAddr), addr) {
+			match = i
+		}
+	}
+	if match < 0 {
+		return nil
+	}
+	lis := activated[match].lis
+	activated = append(activated[:match], activated[match+1:]...)
+	return lis
+}
+
+func sameAddr(a, b *net.TCPAddr) bool {
+	if b == nil || a.Port != b.Port {
+		return false
+	}
+	return a.IP.Equal(b.IP) || (a.IP.IsUnspecified() && (b.IP == nil || b.IP.IsUnspecified()))
+}
+
+// SdNotify sends state to the systemd notify socket, it returns false when
+// NOTIFY_SOCKET is not set, e.g. not run by systemd
+func SdNotify(state string) (bool, error) {
+	socket := os.Getenv(ENV_NOTIFY_SOCKET)
+	if socket == "" {
+		return false, nil
+	}
+	addr := &net.UnixAddr{Name: socket, Net: "unixgram"}
+	if strings.HasPrefix(socket, "@") {
+		// abstract namespace
+		addr.Name = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix(addr.Net, nil, addr)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (srv *Server) sdNotify(state string) {
+	if _, err := SdNotify(state); err != nil {
+		srv.log.Errorf(" => sd_notify %q error : %s", state, err)
+	}
+}
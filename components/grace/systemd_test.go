@@ -0,0 +1,123 @@
+package grace
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSdNotify(t *testing.T) {
+	old := os.Getenv(ENV_NOTIFY_SOCKET)
+	defer os.Setenv(ENV_NOTIFY_SOCKET, old)
+	_ = os.Unsetenv(ENV_NOTIFY_SOCKET)
+	if ok, err := SdNotify(SD_NOTIFY_READY); ok || err != nil {
+		t.Fatalf("SdNotify without socket = %v, %v", ok, err)
+	}
+
+	dir, err := ioutil.TempDir("", "sdnotify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_ = os.Setenv(ENV_NOTIFY_SOCKET, path)
+
+	if ok, err := SdNotify(SD_NOTIFY_STOPPING); !ok || err != nil {
+		t.Fatalf("SdNotify = %v, %v", ok, err)
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != SD_NOTIFY_STOPPING {
+		t.Fatalf("state = %q", buf[:n])
+	}
+}
+
+func TestActivatedListenerOf(t *testing.T) {
+	activatedSockets()
+	lis, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	addr := lis.Addr().(*net.TCPAddr)
+
+	activatedLock.Lock()
+	activated = append(activated, &activatedListener{name: "grpc", lis: lis})
+	activatedLock.Unlock()
+
+	if activatedListenerOf("http", &net.TCPAddr{Port: addr.Port + 1}) != nil {
+		t.Fatal("adopted a socket of another name and port")
+	}
+	if activatedListenerOf("", addr) != lis {
+		t.Fatal("socket not adopted by port")
+	}
+	if activatedListenerOf("grpc", nil) != nil {
+		t.Fatal("socket adopted twice")
+	}
+}
+
+func TestSameAddr(t *testing.T) {
+	cases := []struct {
+		a, b *net.TCPAddr
+		same bool
+	}{
+		{&net.TCPAddr{IP: net.IPv4zero, Port: 80}, &net.TCPAddr{Port: 80}, true},
+		{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}, true},
+		{&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}, &net.TCPAddr{Port: 80}, false},
+		{&net.TCPAddr{IP: net.IPv4zero, Port: 80}, &net.TCPAddr{Port: 81}, false},
+		{&net.TCPAddr{Port: 80}, nil, false},
+	}
+	for _, c := range cases {
+		if sameAddr(c.a, c.b) != c.same {
+			t.Errorf("sameAddr(%s, %v) = %v", c.a, c.b, !c.same)
+		}
+	}
+}
+
+func TestCloseUnclaimed(t *testing.T) {
+	activatedSockets()
+	listen := func() *net.TCPListener {
+		lis, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lis
+	}
+	fromParent, fromSystemd := listen(), listen()
+	defer fromParent.Close()
+	defer fromSystemd.Close()
+
+	inheritedLock.Lock()
+	if err := loadInherited(); err != nil {
+		inheritedLock.Unlock()
+		t.Fatal(err)
+	}
+	inherited[fromParent.Addr().String()] = fromParent
+	inheritedLock.Unlock()
+	activatedLock.Lock()
+	activated = append(activated, &activatedListener{name: "http", lis: fromSystemd})
+	activatedLock.Unlock()
+
+	if closed := closeUnclaimed(); len(closed) != 2 {
+		t.Fatalf("closed = %v", closed)
+	}
+	for _, lis := range []*net.TCPListener{fromParent, fromSystemd} {
+		if _, err := lis.Accept(); err == nil {
+			t.Fatalf("%s still open", lis.Addr())
+		}
+	}
+	if closed := closeUnclaimed(); len(closed) != 0 {
+		t.Fatalf("closed again = %v", closed)
+	}
+}
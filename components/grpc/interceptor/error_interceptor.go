@@ -2,19 +2,24 @@ package interceptor
 
 import (
 	"context"
+	"github.com/joselee214/j7f/components/errors"
 	"github.com/joselee214/j7f/components/log"
+	"github.com/joselee214/j7f/components/reporter"
+	"github.com/joselee214/j7f/components/secret"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"strings"
 )
 
 func UnaryServerErrorInterceptor(l *log.Logger) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (_ interface{}, err error) {
 		res, err := handler(ctx, req)
 		if err != nil {
-			s, _ := status.FromError(err)
-			l.Error(info.FullMethod, zap.Error(err))
-			return nil, s.Err()
+			stampTraceId(ctx, err)
+			logError(ctx, l, info.FullMethod, err)
+			return nil, errors.StatusIn(err, errors.LocaleFromContext(ctx)).Err()
 		}
 
 		return res, err
@@ -26,11 +31,74 @@ func StreamServerErrorInterceptor(l *log.Logger) grpc.StreamServerInterceptor {
 	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
 		err = handler(srv, stream)
 		if err != nil {
-			s, _ := status.FromError(err)
-			l.Error(info.FullMethod, zap.Error(err))
-			return s.Err()
+			stampTraceId(stream.Context(), err)
+			logError(stream.Context(), l, info.FullMethod, err)
+			return errors.StatusIn(err, errors.LocaleFromContext(stream.Context())).Err()
 		}
 
 		return err
 	}
 }
+
+//按错误码注册的日志级别输出, error 及以上级别的上报给 reporter
+func logError(ctx context.Context, l *log.Logger, method string, err error) {
+	level := zap.ErrorLevel
+	var e *errors.Error
+	if errors.As(err, &e) {
+		level = errors.DefaultRegistry.LogLevel(e.Code())
+	}
+	if ce := l.Check(level, method); ce != nil {
+		ce.Write(zap.Error(err))
+	}
+	if level >= zap.ErrorLevel {
+		reporter.ReportError(ctx, err, reportMetadata(ctx, method))
+	}
+}
+
+// reportedSecrets are the metadata never reported, besides the ones of secret.IsKey
+var reportedSecrets = []string{"authorization", "cookie", "session", "api-key"}
+
+// reportMetadata is the incoming metadata of the request without the credentials
+func reportMetadata(ctx context.Context, method string) map[string]string {
+	md := map[string]string{"method": method}
+	if in, ok := metadata.FromIncomingContext(ctx); ok {
+		for k, v := range in {
+			if len(v) == 0 || isSecretMetadata(k) {
+				continue
+			}
+			md[k] = v[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		md["peer"] = p.Addr.String()
+	}
+	return md
+}
+
+func isSecretMetadata(key string) bool {
+	if secret.IsKey(key) {
+		return true
+	}
+	key = strings.ToLower(key)
+	for _, s := range reportedSecrets {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+//把请求的 trace_id 带给客户端
+func stampTraceId(ctx context.Context, err error) {
+	var e *errors.Error
+	if !errors.As(err, &e) || e.TraceId() != "" {
+		return
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return
+	}
+	if traceIds, ok := md["trace_id"]; ok && len(traceIds) > 0 {
+		e.WithTraceId(traceIds[0])
+	}
+}
@@ -0,0 +1,40 @@
+package interceptor
+
+import (
+	"context"
+	"github.com/joselee214/j7f/components/errors"
+	"github.com/joselee214/j7f/components/log"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func nopLogger() *log.Logger {
+	return &log.Logger{Logger: zap.NewNop(), Level: zap.NewAtomicLevel()}
+}
+
+func TestErrorInterceptorLocalizes(t *testing.T) {
+	i := UnaryServerErrorInterceptor(nopLogger())
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.User/Get"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, errors.NewFromCode(errors.CommonError_PROCESSING_TIMEOUT)
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("locale", "en", "trace_id", "t1"))
+	_, err := i(ctx, nil, info, handler)
+	s, _ := status.FromError(err)
+	if s.Code() != codes.DeadlineExceeded || s.Message() != "processing timeout" {
+		t.Fatalf("status = %s %q", s.Code(), s.Message())
+	}
+	if e := errors.FromGRPC(err); e.TraceId() != "t1" {
+		t.Fatalf("trace id = %q", e.TraceId())
+	}
+
+	_, err = i(context.Background(), nil, info, handler)
+	if s, _ = status.FromError(err); s.Message() != "处理超时" {
+		t.Fatalf("default locale message = %q", s.Message())
+	}
+}
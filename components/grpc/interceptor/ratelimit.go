@@ -0,0 +1,219 @@
+package interceptor
+
+import (
+	"context"
+	"github.com/joselee214/j7f/components/errors"
+	"github.com/joselee214/j7f/components/grpc/server"
+	"github.com/joselee214/j7f/components/limiter"
+	"github.com/joselee214/j7f/components/log"
+	"github.com/joselee214/j7f/lib/gopkg.in/redsync.v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DEFAULT_RATE_LIMIT_PREFIX = "ratelimit:"
+
+// DEFAULT_RETRY_AFTER is the retry delay of the calls rejected by a concurrency limit
+const DEFAULT_RETRY_AFTER = time.Second
+
+// RateLimitConfig are the limits of the calls of each caller, by method. Methods is a list like
+// for TimeoutConfig, the limit of a service is shared by its methods:
+//
+//	key: x-app-id
+//	default:
+//	  rate: 100
+//	  burst: 200
+//	methods:
+//	  - {method: /user.User/Export, rate: 1, concurrency: 2, redis: true}
+//	  - {method: report.Report, concurrency: 10}
+type RateLimitConfig struct {
+	// Key is the metadata key naming the caller, the peer ip when "" or missing
+	Key     string
+	Default *RateLimit
+	Methods []*RateLimit
+}
+
+type RateLimit struct {
+	// Method is the full method or the service of the limit in Methods, matched case-insensitively
+	Method string
+	// Rate is the calls per second, 0 for none, Burst the calls at once above it, Rate rounded up by default
+	Rate  float64
+	Burst int
+	// Concurrency is the calls in progress at once, 0 for none, a stream is in progress until it ends
+	Concurrency int
+	// Redis shares Rate among the nodes through the pool of SetRedis, Concurrency stays per node
+	Redis bool
+}
+
+// RateLimiter applies a RateLimitConfig, the limits are built on the first call of their methods
+type RateLimiter struct {
+	cfg *RateLimitConfig
+	l   *log.Logger
+
+	mu     sync.Mutex
+	pool   redsync.Pool
+	prefix string
+	limits map[string]*methodLimit
+}
+
+type methodLimit struct {
+	rate        limiter.Limiter
+	concurrency *limiter.Concurrency
+}
+
+func NewRateLimiter(l *log.Logger, cfg *RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		cfg:    cfg,
+		l:      l,
+		prefix: DEFAULT_RATE_LIMIT_PREFIX,
+		limits: make(map[string]*methodLimit),
+	}
+}
+
+// SetRedis sets the pool of the limits with Redis, before serving, prefix defaults to DEFAULT_RATE_LIMIT_PREFIX.
+// Without a pool they are per node
+func (r *RateLimiter) SetRedis(pool redsync.Pool, prefix string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pool = pool
+	if prefix != "" {
+		r.prefix = prefix
+	}
+}
+
+// UnaryServerRateLimitInterceptor rejects the calls over the limits of r with CommonError_RATE_LIMITED,
+// ResourceExhausted unless registered otherwise, telling when to retry. Register it after
+// UnaryServerTraceInterceptor and before UnaryServerErrorInterceptor, the rejections are logged as warnings
+func UnaryServerRateLimitInterceptor(r *RateLimiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		release, err := r.take(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerRateLimitInterceptor limits the opening of the streams like UnaryServerRateLimitInterceptor,
+// the messages of a stream are limited by its PerRequest
+func StreamServerRateLimitInterceptor(r *RateLimiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := r.take(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, stream)
+	}
+}
+
+// take takes a token and a place of the caller for method, release gives the place back
+func (r *RateLimiter) take(ctx context.Context, method string) (release func(), err error) {
+	release = func() {}
+	name, rl := r.lookup(method)
+	if rl == nil {
+		return release, nil
+	}
+	ml := r.methodLimit(name, rl)
+	key := name + "|" + r.caller(ctx)
+
+	if ml.concurrency != nil {
+		var ok bool
+		if release, ok = ml.concurrency.Acquire(key); !ok {
+			return nil, r.reject(ctx, method, key, DEFAULT_RETRY_AFTER)
+		}
+	}
+	if ml.rate != nil {
+		ok, after, err := ml.rate.Allow(key)
+		if err != nil {
+			//redis 不可用时放行
+			r.l.Warn(method, zap.String("key", key), zap.Error(err))
+			return release, nil
+		}
+		if !ok {
+			release()
+			if after <= 0 {
+				after = DEFAULT_RETRY_AFTER
+			}
+			return nil, r.reject(ctx, method, key, after)
+		}
+	}
+	return release, nil
+}
+
+func (r *RateLimiter) reject(ctx context.Context, method, key string, after time.Duration) error {
+	err := withGrpcCode(errors.NewFromCode(errors.CommonError_RATE_LIMITED), codes.ResourceExhausted).WithRetryAfter(after)
+	stampTraceId(ctx, err)
+	l, ok := ctx.Value(server.UTO_CONTEXT_LOG_KEY).(*zap.Logger)
+	if !ok {
+		l = r.l.Logger
+	}
+	l.Warn(method, zap.String("rate_limited", key), zap.Duration("retry_after", after))
+	return err
+}
+
+// lookup is the limit of method and its name, the method, else the service of its limit, nil when none
+func (r *RateLimiter) lookup(method string) (string, *RateLimit) {
+	if r.cfg == nil {
+		return "", nil
+	}
+	service := methodService(method)
+	name, rl := method, r.cfg.Default
+	for _, m := range r.cfg.Methods {
+		if strings.EqualFold(m.Method, method) {
+			return method, m
+		}
+		if strings.EqualFold(m.Method, service) {
+			name, rl = service, m
+		}
+	}
+	return name, rl
+}
+
+func (r *RateLimiter) methodLimit(name string, rl *RateLimit) *methodLimit {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ml, ok := r.limits[name]; ok {
+		return ml
+	}
+	ml := &methodLimit{}
+	if rl.Rate > 0 {
+		if rl.Redis && r.pool != nil {
+			ml.rate = limiter.NewRedisBucket(r.pool, r.prefix, rl.Rate, rl.Burst)
+		} else {
+			ml.rate = limiter.NewTokenBucket(rl.Rate, rl.Burst)
+		}
+	}
+	if rl.Concurrency > 0 {
+		ml.concurrency = limiter.NewConcurrency(rl.Concurrency)
+	}
+	r.limits[name] = ml
+	return ml
+}
+
+// caller is the value of the metadata Key, else the peer ip
+func (r *RateLimiter) caller(ctx context.Context) string {
+	if r.cfg.Key != "" {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get(r.cfg.Key); len(values) > 0 && values[0] != "" {
+				return values[0]
+			}
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr := p.Addr.String()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			return host
+		}
+		return addr
+	}
+	return ""
+}
@@ -0,0 +1,155 @@
+package interceptor
+
+import (
+	"context"
+	stderrors "errors"
+	"github.com/gomodule/redigo/redis"
+	"github.com/joselee214/j7f/components/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+)
+
+func TestRateLimitLookup(t *testing.T) {
+	def := &RateLimit{Rate: 100}
+	service := &RateLimit{Method: "report.Report", Concurrency: 10}
+	method := &RateLimit{Method: "/report.Report/Export", Rate: 1}
+	r := NewRateLimiter(nopLogger(), &RateLimitConfig{Default: def, Methods: []*RateLimit{service, method}})
+
+	cases := []struct {
+		method, name string
+		limit        *RateLimit
+	}{
+		{"/report.Report/Export", "/report.Report/Export", method},
+		{"/report.report/export", "/report.report/export", method},
+		{"/report.Report/Daily", "report.Report", service},
+		{"/user.User/Get", "/user.User/Get", def},
+	}
+	for _, c := range cases {
+		if name, rl := r.lookup(c.method); name != c.name || rl != c.limit {
+			t.Errorf("%s = %s %+v", c.method, name, rl)
+		}
+	}
+	if _, rl := NewRateLimiter(nopLogger(), nil).lookup("/user.User/Get"); rl != nil {
+		t.Fatal("limit without config")
+	}
+}
+
+func TestRateLimitCaller(t *testing.T) {
+	r := NewRateLimiter(nopLogger(), &RateLimitConfig{Key: "x-app-id"})
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 5000}})
+	if c := r.caller(ctx); c != "10.0.0.7" {
+		t.Fatalf("peer caller = %s", c)
+	}
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-app-id", "billing"))
+	if c := r.caller(ctx); c != "billing" {
+		t.Fatalf("metadata caller = %s", c)
+	}
+}
+
+func TestUnaryRateLimit(t *testing.T) {
+	r := NewRateLimiter(nopLogger(), &RateLimitConfig{
+		Key: "x-app-id",
+		Methods: []*RateLimit{
+			{Method: "/user.User/Get", Rate: 1, Burst: 1},
+			{Method: "/user.User/Export", Concurrency: 1},
+		},
+	})
+	i := UnaryServerRateLimitInterceptor(r)
+	ok := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	caller := func(id string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-app-id", id))
+	}
+	get := &grpc.UnaryServerInfo{FullMethod: "/user.User/Get"}
+
+	if _, err := i(caller("a"), nil, get, ok); err != nil {
+		t.Fatal(err)
+	}
+	_, err := i(caller("a"), nil, get, ok)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("over the rate = %v", err)
+	}
+	e := errors.FromGRPC(err)
+	if e.Code() != int64(errors.CommonError_RATE_LIMITED) || e.RetryAfter() <= 0 {
+		t.Fatalf("rejection = code %d, retry after %s", e.Code(), e.RetryAfter())
+	}
+	if _, err = i(caller("b"), nil, get, ok); err != nil {
+		t.Fatalf("callers share their limit: %v", err)
+	}
+	if _, err = i(caller("a"), nil, &grpc.UnaryServerInfo{FullMethod: "/user.User/Other"}, ok); err != nil {
+		t.Fatalf("method without limit: %v", err)
+	}
+
+	//并发限制: 处理中的调用占着名额, 结束后释放
+	export := &grpc.UnaryServerInfo{FullMethod: "/user.User/Export"}
+	_, err = i(caller("a"), nil, export, func(ctx context.Context, req interface{}) (interface{}, error) {
+		_, inner := i(caller("a"), nil, export, ok)
+		return nil, inner
+	})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("over the concurrency = %v", err)
+	}
+	if _, err = i(caller("a"), nil, export, ok); err != nil {
+		t.Fatalf("place not released: %v", err)
+	}
+}
+
+// failConn is a redis.Conn failing every command
+type failConn struct {
+	redis.Conn
+}
+
+func (failConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return nil, stderrors.New("connection refused")
+}
+
+func (failConn) Close() error {
+	return nil
+}
+
+type failPool struct{}
+
+func (failPool) Get() redis.Conn {
+	return failConn{}
+}
+
+func TestRateLimitLetsThroughWithoutRedis(t *testing.T) {
+	r := NewRateLimiter(nopLogger(), &RateLimitConfig{
+		Default: &RateLimit{Rate: 1, Burst: 1, Redis: true},
+	})
+	r.SetRedis(failPool{}, "")
+	i := UnaryServerRateLimitInterceptor(r)
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.User/Get"}
+	for n := 0; n < 3; n++ {
+		if _, err := i(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		}); err != nil {
+			t.Fatalf("call %d: %v", n, err)
+		}
+	}
+}
+
+func TestStreamRateLimit(t *testing.T) {
+	r := NewRateLimiter(nopLogger(), &RateLimitConfig{Default: &RateLimit{Concurrency: 1}})
+	i := StreamServerRateLimitInterceptor(r)
+	info := &grpc.StreamServerInfo{FullMethod: "/user.User/Chat", IsClientStream: true, IsServerStream: true}
+	err := i(nil, &countStream{}, info, func(srv interface{}, stream grpc.ServerStream) error {
+		return i(nil, &countStream{}, info, func(srv interface{}, stream grpc.ServerStream) error {
+			return nil
+		})
+	})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("second stream = %v", err)
+	}
+	if err = i(nil, &countStream{}, info, func(srv interface{}, stream grpc.ServerStream) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("place not released: %v", err)
+	}
+}
@@ -0,0 +1,27 @@
+package interceptor
+
+import (
+	"context"
+	"google.golang.org/grpc/metadata"
+	"testing"
+)
+
+func TestReportMetadataDropsCredentials(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"authorization", "Bearer x",
+		"cookie", "sid=1",
+		"x-api-key", "k",
+		"x-access-token", "t",
+		"trace_id", "t1",
+		"x-app-id", "app",
+	))
+	md := reportMetadata(ctx, "/user.User/Get")
+	for _, k := range []string{"authorization", "cookie", "x-api-key", "x-access-token"} {
+		if _, ok := md[k]; ok {
+			t.Errorf("%s reported", k)
+		}
+	}
+	if md["trace_id"] != "t1" || md["x-app-id"] != "app" || md["method"] != "/user.User/Get" {
+		t.Fatalf("metadata = %v", md)
+	}
+}
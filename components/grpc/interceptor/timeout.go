@@ -0,0 +1,123 @@
+package interceptor
+
+import (
+	"context"
+	"fmt"
+	"github.com/joselee214/j7f/components/errors"
+	"github.com/joselee214/j7f/components/log"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+)
+
+// TimeoutConfig are the processing timeouts of the unary methods in seconds, 0 for none.
+// Methods is a list, viper splitting the keys of the maps on the dots of the names:
+//
+//	default: 5
+//	methods:
+//	  - {method: /user.User/Export, timeout: 60}
+//	  - {method: report.Report, timeout: 30}
+type TimeoutConfig struct {
+	Default int
+	Methods []MethodTimeout
+}
+
+// MethodTimeout is the timeout of Method, a full method or a service, matched case-insensitively
+type MethodTimeout struct {
+	Method  string
+	Timeout int
+}
+
+// Timeout is the timeout of method, the one of the method, else of its service, else Default
+func (c *TimeoutConfig) Timeout(method string) time.Duration {
+	if c == nil {
+		return 0
+	}
+	seconds := c.Default
+	service := methodService(method)
+	for _, m := range c.Methods {
+		if strings.EqualFold(m.Method, method) {
+			seconds = m.Timeout
+			break
+		}
+		if strings.EqualFold(m.Method, service) {
+			seconds = m.Timeout
+		}
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// methodService is the service of the full method, /user.User/Get gives user.User
+func methodService(method string) string {
+	service := strings.TrimPrefix(method, "/")
+	if i := strings.LastIndex(service, "/"); i >= 0 {
+		service = service[:i]
+	}
+	return service
+}
+
+// withGrpcCode sets the grpc code of err unless the registry defines one for its code
+func withGrpcCode(err *errors.Error, code codes.Code) *errors.Error {
+	if def, ok := errors.DefaultRegistry.Lookup(err.Code()); !ok || def.GrpcCode == "" {
+		err.WithGrpcCode(code)
+	}
+	return err
+}
+
+// UnaryServerTimeoutInterceptor cancels the context of the handler after the timeout of the method
+// and replies CommonError_PROCESSING_TIMEOUT without waiting for it, like the GrpcStream does for
+// each message, with DeadlineExceeded unless the registry has another grpc code for it.
+// The deadline of the client is kept when shorter, the outgoing calls of the handler get the
+// shorter one, and its expiry replies DeadlineExceeded. Register it after
+// UnaryServerErrorInterceptor so the timeouts are logged with the trace id
+func UnaryServerTimeoutInterceptor(l *log.Logger, cfg *TimeoutConfig) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		d := cfg.Timeout(info.FullMethod)
+		if d <= 0 {
+			return handler(ctx, req)
+		}
+
+		tctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+
+		type result struct {
+			res   interface{}
+			err   error
+			panic interface{}
+		}
+		done := make(chan result, 1)
+		go func() {
+			r := result{}
+			defer func() {
+				if p := recover(); p != nil {
+					r.panic = p
+				}
+				done <- r
+			}()
+			r.res, r.err = handler(tctx, req)
+		}()
+
+		select {
+		case r := <-done:
+			if r.panic != nil {
+				panic(r.panic)
+			}
+			return r.res, r.err
+		case <-tctx.Done():
+			//handler 继续在后台运行, 结束时记录其 panic
+			go func() {
+				if r := <-done; r.panic != nil {
+					l.Error(info.FullMethod, zap.String("panic", fmt.Sprint(r.panic)), zap.Duration("timeout", d))
+				}
+			}()
+			if err := ctx.Err(); err != nil {
+				//客户端先超时或取消
+				return nil, status.FromContextError(err).Err()
+			}
+			return nil, withGrpcCode(errors.NewFromCode(errors.CommonError_PROCESSING_TIMEOUT), codes.DeadlineExceeded)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package interceptor
+
+import (
+	"context"
+	"github.com/joselee214/j7f/components/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestTimeoutOfMethod(t *testing.T) {
+	cfg := &TimeoutConfig{
+		Default: 5,
+		Methods: []MethodTimeout{
+			{Method: "report.Report", Timeout: 30},
+			{Method: "/user.User/Export", Timeout: 60},
+			{Method: "/report.Report/Quick", Timeout: 1},
+		},
+	}
+	cases := map[string]time.Duration{
+		"/user.User/Export":    60 * time.Second,
+		"/user.user/export":    60 * time.Second,
+		"/user.User/Get":       5 * time.Second,
+		"/report.Report/Daily": 30 * time.Second,
+		"/report.Report/Quick": time.Second,
+	}
+	for method, want := range cases {
+		if d := cfg.Timeout(method); d != want {
+			t.Errorf("%s = %s, want %s", method, d, want)
+		}
+	}
+	if d := (*TimeoutConfig)(nil).Timeout("/user.User/Get"); d != 0 {
+		t.Fatalf("nil config = %s", d)
+	}
+}
+
+func TestUnaryTimeout(t *testing.T) {
+	i := UnaryServerTimeoutInterceptor(nopLogger(), &TimeoutConfig{
+		Methods: []MethodTimeout{{Method: "/user.User/Slow", Timeout: 1}},
+	})
+	slow := &grpc.UnaryServerInfo{FullMethod: "/user.User/Slow"}
+	canceled := make(chan struct{})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		select {
+		case <-ctx.Done():
+			close(canceled)
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(req.(int)) * time.Millisecond):
+			return "done", nil
+		}
+	}
+
+	res, err := i(context.Background(), 10, slow, handler)
+	if err != nil || res != "done" {
+		t.Fatalf("fast handler = %v %v", res, err)
+	}
+
+	start := time.Now()
+	_, err = i(context.Background(), 5000, slow, handler)
+	if time.Since(start) > 2*time.Second {
+		t.Fatal("waited for the handler")
+	}
+	e := errors.FromGRPC(err)
+	if e.Code() != int64(errors.CommonError_PROCESSING_TIMEOUT) || status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("timeout = %v", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("handler context not canceled")
+	}
+
+	//客户端的deadline更短时返回DeadlineExceeded而不是处理超时
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = i(ctx, 5000, slow, handler)
+	if status.Code(err) != codes.DeadlineExceeded || errors.FromGRPC(err).Code() == int64(errors.CommonError_PROCESSING_TIMEOUT) {
+		t.Fatalf("client deadline = %v", err)
+	}
+
+	//没有配置超时的方法直接调用
+	other := &grpc.UnaryServerInfo{FullMethod: "/user.User/Get"}
+	if res, err = i(context.Background(), 1, other, handler); res != "done" {
+		t.Fatalf("no timeout = %v %v", res, err)
+	}
+}
+
+func TestUnaryTimeoutRepanics(t *testing.T) {
+	i := UnaryServerTimeoutInterceptor(nopLogger(), &TimeoutConfig{Default: 1})
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Fatalf("recovered %v", p)
+		}
+	}()
+	_, _ = i(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/user.User/Get"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+}
@@ -1,18 +1,65 @@
 package interceptor
 
 import (
+	"context"
 	"github.com/joselee214/j7f/components/grpc/server"
 	"github.com/joselee214/j7f/components/log"
+	"github.com/rs/xid"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
+// StreamServerTraceInterceptor tracks the messages of the bidirectional streams with a GrpcStream,
+// the other streams get a TracedStream
 func StreamServerTraceInterceptor(l *log.Logger, cfg *server.Config) grpc.StreamServerInterceptor {
 	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		//只有双向流的请求和回复一一对应
+		if !info.IsClientStream || !info.IsServerStream {
+			return handler(srv, server.NewTraced(stream.Context(), l, stream))
+		}
 		ts := server.New(stream.Context(), l, stream, cfg)
 		return handler(srv, ts)
 	}
 }
 
-//TODO:: 非流式trace_id拦截器
-////func UnaryServerTraceInterceptor(l *log.Logger) grpc.UnaryServerInterceptor{
-////}
\ No newline at end of file
+// UnaryServerTraceInterceptor puts the trace id of the request in the incoming metadata, and the
+// logger traced with it under UTO_CONTEXT_LOG_KEY, like the GrpcStream does for each message.
+// The trace id is the one of the CommonHeader of the request, else of the metadata, else a new
+// one, it is stamped on the ResponseStatus replies. Register it before UnaryServerErrorInterceptor
+// so the errors carry it too
+func UnaryServerTraceInterceptor(l *log.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		traceId := ""
+		if r, ok := req.(server.RequestTrace); ok {
+			traceId = r.GetHeader().GetTraceId()
+		}
+		md, ok := metadata.FromIncomingContext(ctx)
+		if ok {
+			md = md.Copy()
+		} else {
+			md = metadata.MD{}
+		}
+		if traceIds := md["trace_id"]; traceId == "" && len(traceIds) > 0 {
+			traceId = traceIds[0]
+		}
+		if traceId == "" {
+			traceId = xid.New().String()
+		}
+		md["trace_id"] = []string{traceId}
+		ctx = metadata.NewIncomingContext(ctx, md)
+
+		tl := l.Trace(ctx)
+		ctx = context.WithValue(ctx, server.UTO_CONTEXT_LOG_KEY, tl)
+		tl.Debug(info.FullMethod, zap.Any("request", req))
+
+		res, err := handler(ctx, req)
+		if r, ok := res.(server.ResponseStatus); ok {
+			if status := r.GetStatus(); status != nil {
+				status.TraceId = traceId
+			}
+		}
+		tl.Debug(info.FullMethod, zap.Any("response", res))
+		return res, err
+	}
+}
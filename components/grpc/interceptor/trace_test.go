@@ -0,0 +1,136 @@
+package interceptor
+
+import (
+	"context"
+	"github.com/joselee214/j7f/components/grpc/server"
+	"github.com/joselee214/j7f/proto/common"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"testing"
+	"time"
+)
+
+type traceReq struct {
+	header *common.CommonHeader
+}
+
+func (r *traceReq) GetHeader() *common.CommonHeader {
+	return r.header
+}
+
+type traceRes struct {
+	status *common.BusinessStatus
+}
+
+func (r *traceRes) GetStatus() *common.BusinessStatus {
+	return r.status
+}
+
+func TestUnaryTraceId(t *testing.T) {
+	i := UnaryServerTraceInterceptor(nopLogger())
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.User/Get"}
+	var seen string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		seen = md["trace_id"][0]
+		if ctx.Value(server.UTO_CONTEXT_LOG_KEY) == nil {
+			t.Fatal("no traced logger")
+		}
+		return &traceRes{status: &common.BusinessStatus{}}, nil
+	}
+	mdCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("trace_id", "from-md"))
+
+	cases := []struct {
+		name string
+		ctx  context.Context
+		req  interface{}
+		want string
+	}{
+		{"header first", mdCtx, &traceReq{header: &common.CommonHeader{TraceId: "from-header"}}, "from-header"},
+		{"metadata", mdCtx, &traceReq{}, "from-md"},
+		{"no header", mdCtx, "plain", "from-md"},
+	}
+	for _, c := range cases {
+		res, err := i(c.ctx, c.req, info, handler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen != c.want || res.(*traceRes).status.TraceId != c.want {
+			t.Fatalf("%s: trace id = %s, reply %s, want %s", c.name, seen, res.(*traceRes).status.TraceId, c.want)
+		}
+	}
+
+	res, _ := i(context.Background(), &traceReq{}, info, handler)
+	if seen == "" || res.(*traceRes).status.TraceId != seen {
+		t.Fatalf("new trace id = %q, reply %q", seen, res.(*traceRes).status.TraceId)
+	}
+	first := seen
+	_, _ = i(context.Background(), &traceReq{}, info, handler)
+	if seen == first {
+		t.Fatal("same new trace id twice")
+	}
+
+	//不修改调用方的metadata
+	md, _ := metadata.FromIncomingContext(mdCtx)
+	if md["trace_id"][0] != "from-md" {
+		t.Fatalf("caller metadata changed: %v", md)
+	}
+}
+
+// countStream is a client stream of n messages
+type countStream struct {
+	grpc.ServerStream
+	n int
+}
+
+func (s *countStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *countStream) RecvMsg(m interface{}) error {
+	if s.n == 0 {
+		return io.EOF
+	}
+	s.n--
+	return nil
+}
+
+func TestStreamTraceTracksOnlyBidi(t *testing.T) {
+	i := StreamServerTraceInterceptor(nopLogger(), &server.Config{MaxInFlight: 2})
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		if _, ok := stream.(*server.TracedStream); !ok {
+			t.Fatalf("stream = %T", stream)
+		}
+		for {
+			if err := stream.RecvMsg(&traceReq{}); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- i(nil, &countStream{n: 5}, &grpc.StreamServerInfo{FullMethod: "/user.User/Upload", IsClientStream: true}, handler)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client stream blocked on MaxInFlight")
+	}
+
+	err := i(nil, &countStream{}, &grpc.StreamServerInfo{FullMethod: "/user.User/Chat", IsClientStream: true, IsServerStream: true},
+		func(srv interface{}, stream grpc.ServerStream) error {
+			if _, ok := stream.(*server.GrpcStream); !ok {
+				t.Fatalf("bidi stream = %T", stream)
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
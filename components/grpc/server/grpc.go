@@ -5,31 +5,61 @@ import (
 	"github.com/joselee214/j7f/components/errors"
 	"github.com/joselee214/j7f/components/log"
 	"github.com/joselee214/j7f/proto/common"
+	"github.com/rs/xid"
 	"go.uber.org/ratelimit"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"sync"
 	"time"
 )
 
 const DEFAULT_RATE_LIMIT = 1000
 
+const DEFAULT_MAX_IN_FLIGHT = 100
+
 const UTO_CONTEXT_LOG_KEY = "UTO_CONTEXT_LOG_KEY"
 
+// GrpcStream tracks each message of a bidirectional stream until its reply, so the messages can be
+// processed concurrently. The correlation id of a message is the trace id of its CommonHeader,
+// a new one when empty, set in the header, each message has its own context, timeout and traced logger.
+// A reply is matched to its message by the trace id of its BusinessStatus, a reply without one
+// to the oldest message in flight. The late reply of a timed out message is dropped: by its trace id,
+// else the untraced replies are taken for the late ones of the timeouts not replied yet, oldest first.
+// The messages matching nothing, e.g. the pushes of the server, are sent as they are.
+// RecvMsg blocks while MaxInFlight messages are in flight.
 type GrpcStream struct {
-	ctx            context.Context
-	parentCtx      context.Context
-	cancel         context.CancelFunc
-	l              *log.Logger
-	g              grpc.ServerStream
-	r              ratelimit.Limiter
-	processingDone chan int
-	cfg            *Config
+	parentCtx context.Context
+	l         *log.Logger
+	g         grpc.ServerStream
+	r         ratelimit.Limiter
+	cfg       *Config
+
+	// slots bounds the messages in flight
+	slots chan struct{}
+
+	mu       sync.Mutex
+	inflight []*message
+	last     *message
+	// expired are the trace ids of the timed out messages not replied yet, at most MaxInFlight
+	expired []string
+
+	sendMu sync.Mutex
+}
+
+// message is a message in flight
+type message struct {
+	traceId string
+	ctx     context.Context
+	cancel  context.CancelFunc
 }
 
 type Config struct {
 	PerRequest        int
 	ProcessingTimeout int
+	// MaxInFlight is the number of messages of a bidirectional stream processed at once, DEFAULT_MAX_IN_FLIGHT
+	// when 0. A message is in flight until its reply or its timeout
+	MaxInFlight int
 }
 
 type RequestTrace interface {
@@ -40,18 +70,23 @@ type ResponseStatus interface {
 	GetStatus() *common.BusinessStatus
 }
 
-func New(ctx context.Context, l *log.Logger, g grpc.ServerStream, cfg *Config) *GrpcStream {
+func New(ctx context.Context, l *log.Logger, g grpc.ServerStream, c *Config) *GrpcStream {
+	//cfg 由所有流共享, 不能修改
+	cfg := *c
 	if cfg.PerRequest == 0 {
 		cfg.PerRequest = DEFAULT_RATE_LIMIT
 	}
+	if cfg.MaxInFlight == 0 {
+		cfg.MaxInFlight = DEFAULT_MAX_IN_FLIGHT
+	}
 
 	s := &GrpcStream{
 		parentCtx: ctx,
-		ctx:       ctx,
 		l:         l,
 		g:         g,
+		slots:     make(chan struct{}, cfg.MaxInFlight),
 
-		cfg: cfg,
+		cfg: &cfg,
 	}
 
 	s.r = ratelimit.New(s.cfg.PerRequest)
@@ -71,72 +106,208 @@ func (s *GrpcStream) SetTrailer(m metadata.MD) {
 	s.g.SetTrailer(m)
 }
 
+// Context is the context of the last message received while it is in flight, else of the stream
 func (s *GrpcStream) Context() context.Context {
-	return s.ctx
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last != nil {
+		return s.last.ctx
+	}
+	return s.parentCtx
+}
+
+// MessageContext is the context of the message in flight of traceId, for the handlers
+// processing several messages at once, else the context of the stream
+func (s *GrpcStream) MessageContext(traceId string) context.Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, msg := range s.inflight {
+		if msg.traceId == traceId {
+			return msg.ctx
+		}
+	}
+	return s.parentCtx
 }
 
 func (s *GrpcStream) SendMsg(m interface{}) error {
-	s.processingDone <- 1
-	traceId := ""
+	var status *common.BusinessStatus
 	if r, ok := m.(ResponseStatus); ok {
-		md, ok := metadata.FromIncomingContext(s.ctx)
-		if ok {
-			if traceIds, ok := md["trace_id"]; ok {
-				status := r.GetStatus()
-				status.TraceId = traceIds[0]
-				traceId = traceIds[0]
-			}
-		}
+		status = r.GetStatus()
 	}
-	s.ctx = s.parentCtx
-	s.l.Debug(traceId, zap.Any("response", m))
-	return s.g.SendMsg(m)
+
+	traceId := ""
+	if status != nil {
+		traceId = status.TraceId
+	}
+	msg, late := s.done(traceId)
+	if late {
+		s.l.Warn(traceId, zap.String("dropped", "late reply of a timed out message"), zap.Any("response", m))
+		return nil
+	}
+	if msg == nil {
+		s.l.Debug(traceId, zap.Any("push", m))
+		return s.send(m)
+	}
+	if status != nil {
+		status.TraceId = msg.traceId
+	}
+
+	s.l.Debug(msg.traceId, zap.Any("response", m))
+	return s.send(m)
 }
 
 func (s *GrpcStream) RecvMsg(m interface{}) error {
+	select {
+	case s.slots <- struct{}{}:
+	case <-s.parentCtx.Done():
+		return s.parentCtx.Err()
+	}
+
 	s.r.Take()
 
 	if err := s.g.RecvMsg(m); err != nil {
+		<-s.slots
 		return err
 	}
 
-	s.ctx, s.cancel = context.WithTimeout(s.parentCtx, time.Duration(s.cfg.ProcessingTimeout)*time.Second)
-
+	md := incomingMD(s.parentCtx)
+	//每条消息一个id, 不用流的trace_id, 否则无法对应回复
 	traceId := ""
+	var header *common.CommonHeader
 	if r, ok := m.(RequestTrace); ok {
-		header := r.GetHeader()
+		header = r.GetHeader()
 		traceId = header.GetTraceId()
-
-		md, ok := metadata.FromIncomingContext(s.ctx)
-		if ok {
-			md["trace_id"] = []string{traceId}
-			s.ctx = metadata.NewIncomingContext(s.ctx, md)
+	}
+	if traceId == "" {
+		traceId = xid.New().String()
+		if header != nil {
+			header.TraceId = traceId
 		}
+	}
+	md["trace_id"] = []string{traceId}
 
+	msg := &message{traceId: traceId}
+	msg.ctx = metadata.NewIncomingContext(s.parentCtx, md)
+	if s.cfg.ProcessingTimeout > 0 {
+		msg.ctx, msg.cancel = context.WithTimeout(msg.ctx, time.Duration(s.cfg.ProcessingTimeout)*time.Second)
+	} else {
+		msg.ctx, msg.cancel = context.WithCancel(msg.ctx)
 	}
-	l := s.l.Trace(s.ctx)
-	s.ctx = context.WithValue(s.ctx, UTO_CONTEXT_LOG_KEY, l)
+	l := s.l.Trace(msg.ctx)
+	msg.ctx = context.WithValue(msg.ctx, UTO_CONTEXT_LOG_KEY, l)
 
-	s.processingDone = make(chan int, 1)
+	s.mu.Lock()
+	s.inflight = append(s.inflight, msg)
+	s.last = msg
+	s.mu.Unlock()
 
-	go func() {
-		select {
-		case <-s.ctx.Done():
-			err := errors.NewFromCode(errors.CommonError_PROCESSING_TIMEOUT)
-			t := &common.TimeoutResponse{
-				Status: errors.GetResHeader(err),
-			}
-			s.cancel()
-			sendErr := s.SendMsg(t)
-			if sendErr != nil {
-				s.l.Error(traceId, zap.String("send", sendErr.Error()))
+	go s.watch(msg)
+
+	l.Debug(traceId, zap.Any("request", m))
+	return nil
+}
+
+// watch replies a TimeoutResponse when msg times out in flight
+func (s *GrpcStream) watch(msg *message) {
+	<-msg.ctx.Done()
+	if msg.ctx.Err() != context.DeadlineExceeded {
+		return
+	}
+	s.mu.Lock()
+	ok := s.finish(msg, true)
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	err := errors.NewFromCode(errors.CommonError_PROCESSING_TIMEOUT).WithTraceId(msg.traceId)
+	t := &common.TimeoutResponse{
+		Status: errors.GetResHeader(err, errors.LocaleFromContext(msg.ctx)),
+	}
+	if sendErr := s.send(t); sendErr != nil {
+		s.l.Error(msg.traceId, zap.String("send", sendErr.Error()))
+	}
+}
+
+// done finishes the message in flight of traceId, the oldest one when traceId is "". late is set
+// for the late reply of a timed out message, msg is nil when the reply matches nothing
+func (s *GrpcStream) done(traceId string) (msg *message, late bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, id := range s.expired {
+		//没有trace_id的回复先当作最早超时消息的迟到回复
+		if traceId == "" || id == traceId {
+			s.expired = append(s.expired[:i], s.expired[i+1:]...)
+			return nil, true
+		}
+	}
+	for _, m := range s.inflight {
+		if traceId == "" || m.traceId == traceId {
+			s.finish(m, false)
+			return m, false
+		}
+	}
+	return nil, false
+}
+
+// finish removes msg from the messages in flight and frees its slot, recording its trace id
+// when it timed out, false when done already. s.mu is held
+func (s *GrpcStream) finish(msg *message, timedOut bool) bool {
+	for i, m := range s.inflight {
+		if m != msg {
+			continue
+		}
+		s.inflight = append(s.inflight[:i], s.inflight[i+1:]...)
+		if s.last == msg {
+			s.last = nil
+		}
+		if timedOut {
+			//超时后不回复的handler不能让记录无限增长
+			if len(s.expired) >= s.cfg.MaxInFlight {
+				s.expired = s.expired[1:]
 			}
-		case <-s.processingDone:
-			s.cancel()
-			return
+			s.expired = append(s.expired, msg.traceId)
 		}
-	}()
+		msg.cancel()
+		<-s.slots
+		return true
+	}
+	return false
+}
 
-	s.l.Debug(traceId, zap.Any("request", m))
-	return nil
+// send serializes the replies, the handlers and the timeouts send concurrently
+func (s *GrpcStream) send(m interface{}) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	return s.g.SendMsg(m)
+}
+
+// TracedStream is a client or a server stream. Its messages are not replied one by one so they
+// are not tracked, its context carries the trace id of the metadata, a new one when none, and the
+// traced logger under UTO_CONTEXT_LOG_KEY
+type TracedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func NewTraced(ctx context.Context, l *log.Logger, g grpc.ServerStream) *TracedStream {
+	md := incomingMD(ctx)
+	if len(md["trace_id"]) == 0 || md["trace_id"][0] == "" {
+		md["trace_id"] = []string{xid.New().String()}
+	}
+	ctx = metadata.NewIncomingContext(ctx, md)
+	ctx = context.WithValue(ctx, UTO_CONTEXT_LOG_KEY, l.Trace(ctx))
+	return &TracedStream{ServerStream: g, ctx: ctx}
+}
+
+func (s *TracedStream) Context() context.Context {
+	return s.ctx
+}
+
+// incomingMD is a copy of the incoming metadata of ctx
+func incomingMD(ctx context.Context) metadata.MD {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		return md.Copy()
+	}
+	return metadata.MD{}
 }
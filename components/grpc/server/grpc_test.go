@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"github.com/joselee214/j7f/components/log"
+	"github.com/joselee214/j7f/proto/common"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testReq struct {
+	header *common.CommonHeader
+}
+
+func (r *testReq) GetHeader() *common.CommonHeader {
+	return r.header
+}
+
+type testRes struct {
+	status *common.BusinessStatus
+}
+
+func (r *testRes) GetStatus() *common.BusinessStatus {
+	return r.status
+}
+
+// testStream is a grpc.ServerStream receiving the requests queued in recv
+type testStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	recv chan *testReq
+
+	l    sync.Mutex
+	sent []interface{}
+}
+
+func newTestStream(traceId string) *testStream {
+	return &testStream{
+		ctx:  metadata.NewIncomingContext(context.Background(), metadata.Pairs("trace_id", traceId)),
+		recv: make(chan *testReq, 16),
+	}
+}
+
+func (s *testStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testStream) RecvMsg(m interface{}) error {
+	r, ok := <-s.recv
+	if !ok {
+		return io.EOF
+	}
+	*m.(*testReq) = *r
+	return nil
+}
+
+func (s *testStream) SendMsg(m interface{}) error {
+	s.l.Lock()
+	s.sent = append(s.sent, m)
+	s.l.Unlock()
+	return nil
+}
+
+func (s *testStream) Sent() []interface{} {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return append([]interface{}{}, s.sent...)
+}
+
+func nopLogger() *log.Logger {
+	return &log.Logger{Logger: zap.NewNop(), Level: zap.NewAtomicLevel()}
+}
+
+func recv(t *testing.T, gs *GrpcStream, ts *testStream, header *common.CommonHeader) *testReq {
+	ts.recv <- &testReq{header: header}
+	req := &testReq{}
+	if err := gs.RecvMsg(req); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestStreamMessageIds(t *testing.T) {
+	ts := newTestStream("stream")
+	cfg := &Config{}
+	gs := New(ts.ctx, nopLogger(), ts, cfg)
+	if cfg.PerRequest != 0 || cfg.MaxInFlight != 0 {
+		t.Fatalf("New changed the shared config: %+v", cfg)
+	}
+
+	a := recv(t, gs, ts, &common.CommonHeader{})
+	b := recv(t, gs, ts, &common.CommonHeader{})
+	c := recv(t, gs, ts, &common.CommonHeader{TraceId: "c"})
+	ida, idb := a.header.TraceId, b.header.TraceId
+	if ida == "" || ida == "stream" || ida == idb || c.header.TraceId != "c" {
+		t.Fatalf("ids = %q %q %q", ida, idb, c.header.TraceId)
+	}
+	md, _ := metadata.FromIncomingContext(gs.MessageContext(idb))
+	if md["trace_id"][0] != idb {
+		t.Fatalf("message metadata = %v", md)
+	}
+
+	//乱序回复按trace_id对应
+	if err := gs.SendMsg(&testRes{status: &common.BusinessStatus{TraceId: idb}}); err != nil {
+		t.Fatal(err)
+	}
+	if gs.MessageContext(idb) != gs.parentCtx || gs.MessageContext(ida) == gs.parentCtx {
+		t.Fatal("reply finished the wrong message")
+	}
+	//没有trace_id的回复对应最早的消息
+	res := &testRes{status: &common.BusinessStatus{}}
+	if err := gs.SendMsg(res); err != nil {
+		t.Fatal(err)
+	}
+	if res.status.TraceId != ida {
+		t.Fatalf("untraced reply stamped %q, want %q", res.status.TraceId, ida)
+	}
+	//不对应任何消息的推送照常发送
+	if err := gs.SendMsg(&testRes{status: &common.BusinessStatus{TraceId: "unknown"}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ts.Sent()); n != 3 {
+		t.Fatalf("sent %d replies, the unmatched one dropped", n)
+	}
+}
+
+func TestStreamLateReplies(t *testing.T) {
+	ts := newTestStream("stream")
+	gs := New(ts.ctx, nopLogger(), ts, &Config{ProcessingTimeout: 1})
+
+	a := recv(t, gs, ts, &common.CommonHeader{})
+	c := recv(t, gs, ts, &common.CommonHeader{})
+	deadline := time.Now().Add(3 * time.Second)
+	for len(ts.Sent()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("no timeout reply")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	timedOut := map[string]bool{}
+	for _, m := range ts.Sent() {
+		if tr, ok := m.(*common.TimeoutResponse); ok {
+			timedOut[tr.Status.TraceId] = true
+		}
+	}
+	if !timedOut[a.header.TraceId] || !timedOut[c.header.TraceId] {
+		t.Fatalf("timeout replies = %v", ts.Sent())
+	}
+
+	b := recv(t, gs, ts, &common.CommonHeader{})
+	//a和c的迟到回复, 无论有没有trace_id, 都不能结束b
+	_ = gs.SendMsg(&testRes{status: &common.BusinessStatus{TraceId: a.header.TraceId}})
+	_ = gs.SendMsg(&testRes{status: &common.BusinessStatus{}})
+	if n := len(ts.Sent()); n != 2 {
+		t.Fatalf("sent %d replies, the late ones not dropped", n)
+	}
+	if ctx := gs.MessageContext(b.header.TraceId); ctx == gs.parentCtx || ctx.Err() != nil {
+		t.Fatal("late reply finished the next message")
+	}
+	//迟到回复都已丢弃, 之后没有trace_id的回复照常对应b
+	res := &testRes{status: &common.BusinessStatus{}}
+	_ = gs.SendMsg(res)
+	if n := len(ts.Sent()); n != 3 || res.status.TraceId != b.header.TraceId {
+		t.Fatalf("sent %d replies, the reply of b stamped %q", n, res.status.TraceId)
+	}
+}
+
+func TestTracedStream(t *testing.T) {
+	ts := newTestStream("")
+	s := NewTraced(ts.ctx, nopLogger(), ts)
+	md, _ := metadata.FromIncomingContext(s.Context())
+	if len(md["trace_id"]) != 1 || md["trace_id"][0] == "" {
+		t.Fatalf("metadata = %v", md)
+	}
+	if s.Context().Value(UTO_CONTEXT_LOG_KEY) == nil {
+		t.Fatal("no traced logger")
+	}
+
+	ts = newTestStream("t1")
+	s = NewTraced(ts.ctx, nopLogger(), ts)
+	if md, _ = metadata.FromIncomingContext(s.Context()); md["trace_id"][0] != "t1" {
+		t.Fatalf("metadata = %v", md)
+	}
+}
@@ -1,12 +1,22 @@
 package server
 
 import (
+	"context"
 	"github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/joselee214/j7f/components/grace"
+	"github.com/joselee214/j7f/components/health"
 	"github.com/joselee214/j7f/components/service_register"
 	"google.golang.org/grpc"
 	"net"
+	"time"
 )
 
+// LISTENER_NAME is the name of the systemd socket adopted, FileDescriptorName=grpc
+const LISTENER_NAME = "grpc"
+
+// DEFAULT_SHUTDOWN_TIMEOUT is the drain deadline of GracefulStop
+const DEFAULT_SHUTDOWN_TIMEOUT = 15 * time.Second
+
 type GrpcServer struct {
 	addr *net.TCPAddr
 
@@ -15,11 +25,21 @@ type GrpcServer struct {
 	//grpc
 	s *grpc.Server
 
-	opts []grpc.ServerOption
+	opts   []grpc.ServerOption
+	unary  []grpc.UnaryServerInterceptor
+	stream []grpc.StreamServerInterceptor
 
 	cb []GrpcCallback
 
 	Config map[string]interface{}
+
+	// ShutdownTimeout is the drain deadline of GracefulStop, DEFAULT_SHUTDOWN_TIMEOUT when 0
+	ShutdownTimeout time.Duration
+
+	// Health is served as grpc.health.v1.Health after the callbacks, unless one of them registered it
+	Health *health.Health
+
+	inFlight grace.InFlight
 }
 
 type GrpcCallback func(s *GrpcServer) error
@@ -30,7 +50,7 @@ func NewGrpcServer(addr *net.TCPAddr, opts ...grpc.ServerOption) (*GrpcServer, e
 
 	g.addr = addr
 
-	g.lis, err = net.ListenTCP("tcp", g.addr)
+	g.lis, err = grace.ListenTCPNamed(LISTENER_NAME, g.addr)
 	if err != nil {
 		return nil, err
 	}
@@ -42,25 +62,20 @@ func NewGrpcServer(addr *net.TCPAddr, opts ...grpc.ServerOption) (*GrpcServer, e
 
 //注册流拦截器
 func (g *GrpcServer) RegisterStreamInterceptors(fs ...interface{}) {
-	var tmp []grpc.StreamServerInterceptor
 	for _, f := range fs {
 		if f, ok := f.(grpc.StreamServerInterceptor); ok {
-			tmp = append(tmp, f)
+			g.stream = append(g.stream, f)
 		}
 	}
-	g.opts = append(g.opts, grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(tmp...)))
-
 }
 
 //注册一元RPC拦截器
 func (g *GrpcServer) RegisterUnaryInterceptors(fs ...interface{}) {
-	var tmp []grpc.UnaryServerInterceptor
 	for _, f := range fs {
 		if f, ok := f.(grpc.UnaryServerInterceptor); ok {
-			tmp = append(tmp, f)
+			g.unary = append(g.unary, f)
 		}
 	}
-	g.opts = append(g.opts, grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(tmp...)))
 }
 
 func (g *GrpcServer) RegisterCb(cbs ...interface{}) {
@@ -74,7 +89,15 @@ func (g *GrpcServer) RegisterCb(cbs ...interface{}) {
 func (g *GrpcServer) NewServ() error {
 	var err error
 
-	g.s = grpc.NewServer(g.opts...)
+	//在途请求计数放在最外层
+	unary := append([]grpc.UnaryServerInterceptor{g.countUnary}, g.unary...)
+	stream := append([]grpc.StreamServerInterceptor{g.countStream}, g.stream...)
+	opts := append(append([]grpc.ServerOption{}, g.opts...),
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unary...)),
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(stream...)),
+	)
+
+	g.s = grpc.NewServer(opts...)
 
 	for _, f := range g.cb {
 		err = f(g)
@@ -82,6 +105,10 @@ func (g *GrpcServer) NewServ() error {
 			return err
 		}
 	}
+	//grpc.health.v1.Health, 在业务服务之后注册
+	if g.Health != nil {
+		health.RegisterGrpc(g.s, g.Health)
+	}
 
 	return nil
 }
@@ -99,9 +126,50 @@ func (g *GrpcServer) Stop() {
 	g.s.Stop()
 }
 
-//GracefulStop stops the gRPC server gracefully
+//GracefulStop stops the gRPC server gracefully, it is force stopped after ShutdownTimeout
 func (g *GrpcServer) GracefulStop() {
-	g.s.Stop()
+	timeout := g.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DEFAULT_SHUTDOWN_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_ = g.Shutdown(ctx)
+}
+
+// Shutdown stops accepting and waits for the in-flight rpcs, unary and streaming,
+// until ctx is done, the server is force stopped then
+func (g *GrpcServer) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		g.s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		g.s.Stop()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// InFlight returns the number of rpcs being served
+func (g *GrpcServer) InFlight() int64 {
+	return g.inFlight.Count()
+}
+
+func (g *GrpcServer) countUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	g.inFlight.Begin()
+	defer g.inFlight.Done()
+	return handler(ctx, req)
+}
+
+func (g *GrpcServer) countStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	g.inFlight.Begin()
+	defer g.inFlight.Done()
+	return handler(srv, ss)
 }
 
 func (g *GrpcServer) GetServicesInfo() map[string]service_register.ServerInfo {
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"github.com/joselee214/j7f/components/health"
+	"net"
+	"testing"
+)
+
+func TestHealthIsOptIn(t *testing.T) {
+	for _, h := range []*health.Health{nil, health.New()} {
+		g, err := NewGrpcServer(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.Health = h
+		if err := g.NewServ(); err != nil {
+			t.Fatal(err)
+		}
+		_, ok := g.GetEngine().GetServiceInfo()[health.GRPC_SERVICE]
+		_ = g.GetListener().Close()
+		if ok != (h != nil) {
+			t.Fatalf("Health = %v, health service registered = %v", h, ok)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package health
+
+import (
+	"context"
+	"github.com/gomodule/redigo/redis"
+	"github.com/joselee214/j7f/lib/gopkg.in/redsync.v1"
+)
+
+// Redis pings a redis pool, e.g. one of lock.RedisLockConfig.Pools
+func Redis(pool redsync.Pool) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		conn := pool.Get()
+		defer conn.Close()
+		_, err := redis.String(conn.Do("PING"))
+		return err
+	})
+}
@@ -0,0 +1,83 @@
+package health
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+const DEFAULT_GRPC_WATCH_INTERVAL = time.Second
+
+const GRPC_SERVICE = "grpc.health.v1.Health"
+
+// GrpcServer is the grpc.health.v1.Health service of a Health. The "" service is the
+// readiness of the process, the registered grpc services share it, a check name gives that check.
+type GrpcServer struct {
+	h        *Health
+	services map[string]bool
+}
+
+// RegisterGrpc registers the grpc.health.v1.Health service of h on s, after the other services.
+// It does nothing if s already has one
+func RegisterGrpc(s *grpc.Server, h *Health) {
+	infos := s.GetServiceInfo()
+	if _, ok := infos[GRPC_SERVICE]; ok {
+		return
+	}
+	srv := &GrpcServer{h: h, services: make(map[string]bool)}
+	for name := range infos {
+		srv.services[name] = true
+	}
+	grpc_health_v1.RegisterHealthServer(s, srv)
+}
+
+func (s *GrpcServer) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	st, ok := s.status(ctx, req.Service)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown service %s", req.Service)
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch sends the status of the service, then each change of it
+func (s *GrpcServer) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	t := time.NewTicker(DEFAULT_GRPC_WATCH_INTERVAL)
+	defer t.Stop()
+
+	last := grpc_health_v1.HealthCheckResponse_ServingStatus(-1)
+	for {
+		st, ok := s.status(stream.Context(), req.Service)
+		if !ok {
+			st = grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		if st != last {
+			if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-t.C:
+		}
+	}
+}
+
+func (s *GrpcServer) status(ctx context.Context, service string) (grpc_health_v1.HealthCheckResponse_ServingStatus, bool) {
+	var st Status
+	if service == "" || s.services[service] {
+		st = s.h.Readiness(ctx).Status
+	} else if res, ok := s.h.CheckOne(ctx, service); ok {
+		st = res.Status
+	} else {
+		return grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN, false
+	}
+	if st == StatusDown {
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING, true
+	}
+	return grpc_health_v1.HealthCheckResponse_SERVING, true
+}
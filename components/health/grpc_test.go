@@ -0,0 +1,90 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpchealth "google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+)
+
+func serveHealth(t *testing.T, register func(s *grpc.Server)) (grpc_health_v1.HealthClient, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	register(s)
+	go func() {
+		_ = s.Serve(lis)
+	}()
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return grpc_health_v1.NewHealthClient(conn), func() {
+		_ = conn.Close()
+		s.Stop()
+	}
+}
+
+func TestGrpcCheck(t *testing.T) {
+	h := New()
+	h.CacheTTL = 0
+	h.Register(&Check{Name: "db", Checker: CheckerFunc(func(ctx context.Context) error {
+		return errors.New("gone")
+	})})
+	client, stop := serveHealth(t, func(s *grpc.Server) {
+		RegisterGrpc(s, h)
+	})
+	defer stop()
+	ctx := context.Background()
+
+	check := func(service string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+		res, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Status
+	}
+	if st := check(""); st != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("readiness with a degraded check = %s", st)
+	}
+	if st := check("db"); st != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("degraded db = %s", st)
+	}
+	_, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "cache"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("unknown service: %v", err)
+	}
+
+	if err := h.StopHook().Func(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if st := check(""); st != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("readiness after stop = %s", st)
+	}
+}
+
+func TestRegisterGrpcKeepsExistingService(t *testing.T) {
+	h := New()
+	h.SetShuttingDown()
+	client, stop := serveHealth(t, func(s *grpc.Server) {
+		grpc_health_v1.RegisterHealthServer(s, grpchealth.NewServer())
+		//重复注册时grpc会直接退出进程
+		RegisterGrpc(s, h)
+	})
+	defer stop()
+
+	res, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("status = %s, the service of h replaced the existing one", res.Status)
+	}
+}
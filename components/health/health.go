@@ -0,0 +1,287 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"github.com/joselee214/j7f/components/lifecycle"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_CHECK_TIMEOUT  = 2 * time.Second
+	DEFAULT_CACHE_TTL      = time.Second
+	DEFAULT_WATCH_INTERVAL = 5 * time.Second
+)
+
+// Status of a check or of the process, ordered by severity
+type Status int
+
+const (
+	StatusUp Status = iota
+	// StatusDegraded is set by the failing checks which are not Critical
+	StatusDegraded
+	StatusDown
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusUp:
+		return "up"
+	case StatusDegraded:
+		return "degraded"
+	default:
+		return "down"
+	}
+}
+
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// ErrShuttingDown is the readiness error of a process being shut down
+var ErrShuttingDown = errors.New("shutting down")
+
+// Checker checks a dependency, e.g. pings a db
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Check is a registered Checker
+type Check struct {
+	Name    string
+	Checker Checker
+	// Timeout bounds one run, DEFAULT_CHECK_TIMEOUT when 0
+	Timeout time.Duration
+	// Critical failures make the process down, the others degraded
+	Critical bool
+	// Liveness checks are part of the liveness too, a failure there restarts the process
+	Liveness bool
+
+	l      sync.Mutex
+	last   *Result
+	lastAt time.Time
+}
+
+// Result is the outcome of a Check
+type Result struct {
+	Name      string        `json:"name"`
+	Status    Status        `json:"status"`
+	Error     string        `json:"error,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+// Report is the status of the process and of its checks
+type Report struct {
+	Status Status    `json:"status"`
+	Checks []*Result `json:"checks"`
+}
+
+// Health runs the checks, their results are cached for CacheTTL
+type Health struct {
+	l        sync.RWMutex
+	checks   map[string]*Check
+	shutting bool
+
+	CacheTTL time.Duration
+
+	onChange []func(old, new *Report)
+}
+
+// Default is the Health of the components added by ApplicationManager.Add, the manager adds its
+// StopHook too
+var Default = New()
+
+func New() *Health {
+	return &Health{
+		checks:   make(map[string]*Check),
+		CacheTTL: DEFAULT_CACHE_TTL,
+	}
+}
+
+// Register adds c to Default
+func Register(c *Check) func() {
+	return Default.Register(c)
+}
+
+// Register adds c, replacing a check of the same name, the returned func removes it
+func (h *Health) Register(c *Check) func() {
+	h.l.Lock()
+	h.checks[c.Name] = c
+	h.l.Unlock()
+	return func() {
+		h.l.Lock()
+		if h.checks[c.Name] == c {
+			delete(h.checks, c.Name)
+		}
+		h.l.Unlock()
+	}
+}
+
+// SetShuttingDown makes the readiness down
+func (h *Health) SetShuttingDown() {
+	h.l.Lock()
+	h.shutting = true
+	h.l.Unlock()
+}
+
+// StopHook makes the readiness down once the lifecycle stops, before the components are closed
+func (h *Health) StopHook() lifecycle.Hook {
+	return lifecycle.Hook{
+		Name:     "health",
+		Priority: lifecycle.PRIORITY_DEREGISTER,
+		Func: func(ctx context.Context) error {
+			h.SetShuttingDown()
+			return nil
+		},
+	}
+}
+
+// OnChange adds f, called by Watch when the readiness status changes
+func (h *Health) OnChange(f func(old, new *Report)) {
+	h.l.Lock()
+	h.onChange = append(h.onChange, f)
+	h.l.Unlock()
+}
+
+// Readiness runs all the checks
+func (h *Health) Readiness(ctx context.Context) *Report {
+	r := h.run(ctx, false)
+	h.l.RLock()
+	shutting := h.shutting
+	h.l.RUnlock()
+	if shutting {
+		r.Status = StatusDown
+		r.Checks = append(r.Checks, &Result{Name: "shutdown", Status: StatusDown, Error: ErrShuttingDown.Error(), CheckedAt: time.Now()})
+	}
+	return r
+}
+
+// Liveness runs the Liveness checks only
+func (h *Health) Liveness(ctx context.Context) *Report {
+	return h.run(ctx, true)
+}
+
+// CheckOne runs the check name, false when there is none
+func (h *Health) CheckOne(ctx context.Context, name string) (*Result, bool) {
+	h.l.RLock()
+	c, ok := h.checks[name]
+	h.l.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return h.check(ctx, c), true
+}
+
+// Watch runs Readiness every interval until ctx is done, calling the OnChange funcs
+// when the status changes
+func (h *Health) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DEFAULT_WATCH_INTERVAL
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	last := &Report{Status: StatusUp}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		r := h.Readiness(ctx)
+		if r.Status == last.Status {
+			last = r
+			continue
+		}
+		h.l.RLock()
+		fs := h.onChange
+		h.l.RUnlock()
+		for _, f := range fs {
+			f(last, r)
+		}
+		last = r
+	}
+}
+
+func (h *Health) run(ctx context.Context, liveness bool) *Report {
+	h.l.RLock()
+	checks := make([]*Check, 0, len(h.checks))
+	for _, c := range h.checks {
+		if !liveness || c.Liveness {
+			checks = append(checks, c)
+		}
+	}
+	h.l.RUnlock()
+
+	r := &Report{Status: StatusUp, Checks: make([]*Result, len(checks))}
+	wg := sync.WaitGroup{}
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *Check) {
+			defer wg.Done()
+			r.Checks[i] = h.check(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	sort.Slice(r.Checks, func(i, j int) bool {
+		return r.Checks[i].Name < r.Checks[j].Name
+	})
+	for _, res := range r.Checks {
+		if res.Status > r.Status {
+			r.Status = res.Status
+		}
+	}
+	return r
+}
+
+// check runs c, or returns its cached result
+func (h *Health) check(ctx context.Context, c *Check) *Result {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.last != nil && time.Since(c.lastAt) < h.CacheTTL {
+		return c.last
+	}
+
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_CHECK_TIMEOUT
+	}
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Checker.Check(cctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-cctx.Done():
+		err = cctx.Err()
+	}
+
+	res := &Result{Name: c.Name, Status: StatusUp, Duration: time.Since(start), CheckedAt: start}
+	if err != nil {
+		res.Error = err.Error()
+		res.Status = StatusDegraded
+		if c.Critical {
+			res.Status = StatusDown
+		}
+	}
+	//请求取消导致的失败不缓存, 否则下一个请求拿到的是它的结果
+	if err == nil || ctx.Err() == nil {
+		c.last, c.lastAt = res, time.Now()
+	}
+	return res
+}
@@ -0,0 +1,32 @@
+package health
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCancelledCheckNotCached(t *testing.T) {
+	h := New()
+	h.CacheTTL = time.Minute
+	var calls int32
+	h.Register(&Check{Name: "db", Critical: true, Checker: CheckerFunc(func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return ctx.Err()
+	})})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if res, _ := h.CheckOne(ctx, "db"); res.Status != StatusDown {
+		t.Fatalf("cancelled check = %s", res.Status)
+	}
+	//取消的结果不缓存, 下一个请求重新检查
+	if res, _ := h.CheckOne(context.Background(), "db"); res.Status != StatusUp {
+		t.Fatalf("check = %s", res.Status)
+	}
+	n := atomic.LoadInt32(&calls)
+	if res, _ := h.CheckOne(context.Background(), "db"); res.Status != StatusUp || atomic.LoadInt32(&calls) != n {
+		t.Fatalf("check = %s, the result not cached", res.Status)
+	}
+}
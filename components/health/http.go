@@ -0,0 +1,35 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	LIVENESS_PATH  = "/health/live"
+	READINESS_PATH = "/health/ready"
+)
+
+// LivenessHandler serves the Liveness report, 503 when down
+func LivenessHandler(h *Health) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, h.Liveness(r.Context()))
+	})
+}
+
+// ReadinessHandler serves the Readiness report, 503 when down, degraded is still ready
+func ReadinessHandler(h *Health) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, h.Readiness(r.Context()))
+	})
+}
+
+func writeReport(w http.ResponseWriter, report *Report) {
+	code := http.StatusOK
+	if report.Status == StatusDown {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/joselee214/j7f/components/log"
+	"github.com/joselee214/j7f/components/reporter"
 	"io/ioutil"
 	"net/http/httputil"
 	"runtime"
@@ -28,6 +29,12 @@ func Recovery(l *log.Logger) gin.HandlerFunc {
 						string(httpRequest), err, stack,
 					))
 				}
+				reporter.ReportPanic(c.Request.Context(), err, map[string]string{
+					"method":    c.Request.Method,
+					"path":      c.Request.URL.Path,
+					"client_ip": c.ClientIP(),
+					"trace_id":  c.GetHeader("trace_id"),
+				})
 				c.AbortWithStatus(500)
 			}
 		}()
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/joselee214/j7f/components/health"
+)
+
+// HealthInit serves the liveness and the readiness of h, 503 when down.
+// A path already routed is kept, gin panics on a duplicate route
+func HealthInit(g *gin.Engine, h *health.Health) {
+	routed := map[string]bool{}
+	for _, r := range g.Routes() {
+		if r.Method == "GET" {
+			routed[r.Path] = true
+		}
+	}
+	if !routed[health.LIVENESS_PATH] {
+		g.GET(health.LIVENESS_PATH, gin.WrapH(health.LivenessHandler(h)))
+	}
+	if !routed[health.READINESS_PATH] {
+		g.GET(health.READINESS_PATH, gin.WrapH(health.ReadinessHandler(h)))
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/joselee214/j7f/components/errors"
 	"net/http"
 )
 
@@ -20,9 +21,32 @@ func ResultOkMsg(ctx * gin.Context,data interface{},msg string){
 }
 
 func ResultFail(ctx * gin.Context,err interface{}){
-	ctx.JSON(http.StatusOK, gin.H{"code": http.StatusBadRequest, "data": nil, "msg":err})
+	ctx.JSON(http.StatusOK, gin.H{"code": http.StatusBadRequest, "data": nil, "msg":Message(ctx, err)})
 }
 
 func ResultFailData(ctx * gin.Context,data interface{},err interface{}){
-	ctx.JSON(http.StatusOK, gin.H{"code": http.StatusBadRequest, "data": data, "msg":err})
+	ctx.JSON(http.StatusOK, gin.H{"code": http.StatusBadRequest, "data": data, "msg":Message(ctx, err)})
+}
+
+//按错误码注册的http状态和业务码返回, 消息按请求的语言渲染
+func ResultError(ctx * gin.Context,err error){
+	var e *errors.Error
+	if !errors.As(err, &e) {
+		ResultFail(ctx, err)
+		return
+	}
+	ctx.JSON(errors.DefaultRegistry.HttpStatus(e.Code()), gin.H{"code": e.Code(), "data": nil, "msg":e.Message(Locale(ctx))})
+}
+
+//请求的语言, 取 lang 参数或 Accept-Language 头
+func Locale(ctx * gin.Context) string {
+	return errors.LocaleFromRequest(ctx.Request)
+}
+
+//error 按请求的语言渲染, 其他类型原样返回
+func Message(ctx * gin.Context,err interface{}) interface{} {
+	if e, ok := err.(error); ok {
+		return errors.Message(e, Locale(ctx))
+	}
+	return err
 }
\ No newline at end of file
@@ -3,6 +3,8 @@ package server
 import (
 	"context"
 	"github.com/gin-gonic/gin"
+	"github.com/joselee214/j7f/components/grace"
+	"github.com/joselee214/j7f/components/health"
 	"github.com/joselee214/j7f/components/log"
 	"github.com/joselee214/j7f/components/service_register"
 	"go.uber.org/zap"
@@ -13,6 +15,12 @@ import (
 	"time"
 )
 
+// LISTENER_NAME is the name of the systemd socket adopted, FileDescriptorName=http
+const LISTENER_NAME = "http"
+
+// DEFAULT_SHUTDOWN_TIMEOUT is the drain deadline of GracefulStop
+const DEFAULT_SHUTDOWN_TIMEOUT = 15 * time.Second
+
 type HttpServer struct {
 	addr *net.TCPAddr
 
@@ -26,6 +34,14 @@ type HttpServer struct {
 
 	cb []HttpCallback
 
+	// ShutdownTimeout is the drain deadline of GracefulStop, DEFAULT_SHUTDOWN_TIMEOUT when 0
+	ShutdownTimeout time.Duration
+
+	inFlight grace.InFlight
+
+	// Health is served on the liveness and the readiness paths after the callbacks, unless one of them routed them
+	Health *health.Health
+
 	Config map[string]interface{}
 }
 
@@ -38,20 +54,11 @@ func NewHttpServer(addr *net.TCPAddr, log *log.Logger, env string) (*HttpServer,
 	g.addr = addr
 
 
-	g.lis, err = net.ListenTCP("tcp", g.addr)
+	g.lis, err = grace.ListenTCPNamed(LISTENER_NAME, g.addr)
 	if err != nil {
 		return nil, err
 	}
 
-	//多进程复用...
-	//gs,e11 := greuse.Listen("tcp", g.addr.String())
-	//if e11 != nil {
-	//	return nil, err
-	//}
-	//
-	//g.lis = gs.(*net.TCPListener)
-
-
 	gin.DefaultWriter = log
 	gin.DefaultErrorWriter = log
 
@@ -99,10 +106,13 @@ func (g *HttpServer) NewServ() error {
 	}
 
 	PingInit(g.r)
+	if g.Health != nil {
+		HealthInit(g.r, g.Health)
+	}
 
 	g.s = &http.Server{
 		Addr:    g.addr.String(),
-		Handler: g.r,
+		Handler: http.HandlerFunc(g.serveCounted),
 	}
 
 	return nil
@@ -117,11 +127,29 @@ func (g *HttpServer) StartServ() error {
 }
 
 func (g *HttpServer) Stop() {
-	g.shutdown(g.s)
+	_ = g.s.Close()
 }
 
+//GracefulStop drains the requests, the server is force stopped after ShutdownTimeout
 func (g *HttpServer) GracefulStop() {
-	g.shutdown(g.s)
+	timeout := g.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DEFAULT_SHUTDOWN_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_ = g.Shutdown(ctx)
+}
+
+// InFlight returns the number of requests being served
+func (g *HttpServer) InFlight() int64 {
+	return g.inFlight.Count()
+}
+
+func (g *HttpServer) serveCounted(w http.ResponseWriter, r *http.Request) {
+	g.inFlight.Begin()
+	defer g.inFlight.Done()
+	g.r.ServeHTTP(w, r)
 }
 
 func (g *HttpServer) GetServicesInfo() map[string]service_register.ServerInfo {
@@ -156,19 +184,21 @@ func (g *HttpServer) GetListener() *net.TCPListener {
 	return g.lis
 }
 
-func (g *HttpServer) shutdown(srv *http.Server) {
-	//quit := make(chan os.Signal)
-	//signal.Notify(quit, os.Interrupt)
-	//<-quit
-
+// Shutdown stops accepting and waits for the in-flight requests, hijacked ones included,
+// until ctx is done, the server is force stopped then
+func (g *HttpServer) Shutdown(ctx context.Context) error {
 	g.l.Logger.Debug("Shutdown Server ...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	if err := srv.Shutdown(ctx); err != nil {
-		g.l.Logger.Panic("server", zap.String("shutdown", err.Error()))
+	err := g.s.Shutdown(ctx)
+	if err == nil {
+		err = g.inFlight.Wait(ctx)
+	}
+	if err != nil {
+		g.l.Logger.Error("server", zap.String("shutdown", err.Error()), zap.Int64("in_flight", g.inFlight.Count()))
+		_ = g.s.Close()
+		return err
 	}
 
 	g.l.Logger.Debug("Server exiting")
+	return nil
 }
@@ -0,0 +1,207 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"github.com/joselee214/j7f/components/errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Priorities of the stop hooks, lower ones run first, hooks of the same priority run concurrently.
+// Start hooks run in the same order. PRIORITY_DRAIN stops accepting and waits for the in-flight requests.
+const (
+	PRIORITY_DEREGISTER      = 100
+	PRIORITY_DRAIN           = 300
+	PRIORITY_STOP_CONSUMERS  = 400
+	PRIORITY_FLUSH_PRODUCERS = 500
+	PRIORITY_CLOSE_DB        = 600
+	PRIORITY_SYNC_LOG        = 700
+)
+
+const DEFAULT_HOOK_TIMEOUT = 10 * time.Second
+
+// Hook is a start or stop step of a component
+type Hook struct {
+	Name     string
+	Priority int
+	// Timeout bounds Func, DEFAULT_HOOK_TIMEOUT when 0
+	Timeout time.Duration
+	Func    func(ctx context.Context) error
+}
+
+// Lifecycle runs the start and stop hooks of the components of a process
+type Lifecycle struct {
+	l      sync.Mutex
+	seq    int
+	starts map[int]*Hook
+	stops  map[int]*Hook
+
+	stopOnce sync.Once
+	stopped  chan struct{}
+	stopErr  error
+
+	// OnError receives the failures of the hooks, they never abort a Stop
+	OnError func(h *Hook, err error)
+}
+
+// Default is the Lifecycle of grace.Server and of the components added by ApplicationManager.Add
+var Default = New()
+
+func New() *Lifecycle {
+	return &Lifecycle{
+		starts:  make(map[int]*Hook),
+		stops:   make(map[int]*Hook),
+		stopped: make(chan struct{}),
+	}
+}
+
+// OnStart adds h to Default
+func OnStart(h Hook) func() {
+	return Default.OnStart(h)
+}
+
+// OnStop adds h to Default
+func OnStop(h Hook) func() {
+	return Default.OnStop(h)
+}
+
+// Start runs the start hooks of Default
+func Start(ctx context.Context) error {
+	return Default.Start(ctx)
+}
+
+// Stop runs the stop hooks of Default
+func Stop(ctx context.Context) error {
+	return Default.Stop(ctx)
+}
+
+// OnStart adds a start hook, the returned func removes it
+func (lc *Lifecycle) OnStart(h Hook) func() {
+	return lc.add(lc.starts, h)
+}
+
+// OnStop adds a stop hook, the returned func removes it, e.g. when the component is closed by hand
+func (lc *Lifecycle) OnStop(h Hook) func() {
+	return lc.add(lc.stops, h)
+}
+
+func (lc *Lifecycle) add(hooks map[int]*Hook, h Hook) func() {
+	lc.l.Lock()
+	defer lc.l.Unlock()
+	lc.seq++
+	id := lc.seq
+	hooks[id] = &h
+	return func() {
+		lc.l.Lock()
+		delete(hooks, id)
+		lc.l.Unlock()
+	}
+}
+
+// Start runs the start hooks, the first failing priority aborts it
+func (lc *Lifecycle) Start(ctx context.Context) error {
+	for _, group := range lc.groups(lc.starts) {
+		if err := lc.run(ctx, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop runs all the stop hooks once, the failures are combined in the returned error.
+// Concurrent and later calls wait for the first one and return its error.
+func (lc *Lifecycle) Stop(ctx context.Context) error {
+	lc.stopOnce.Do(func() {
+		m := errors.NewMultiError()
+		for _, group := range lc.groups(lc.stops) {
+			m.Append(lc.run(ctx, group))
+		}
+		lc.stopErr = m.ErrorOrNil()
+		close(lc.stopped)
+	})
+	<-lc.stopped
+	return lc.stopErr
+}
+
+// Stopped is closed once Stop is done
+func (lc *Lifecycle) Stopped() <-chan struct{} {
+	return lc.stopped
+}
+
+// groups returns the hooks by ascending priority, in the order they were added
+func (lc *Lifecycle) groups(hooks map[int]*Hook) [][]*Hook {
+	lc.l.Lock()
+	ids := make([]int, 0, len(hooks))
+	for id := range hooks {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	sorted := make([]*Hook, 0, len(ids))
+	for _, id := range ids {
+		sorted = append(sorted, hooks[id])
+	}
+	lc.l.Unlock()
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+	groups := make([][]*Hook, 0)
+	for i, h := range sorted {
+		if i == 0 || h.Priority != sorted[i-1].Priority {
+			groups = append(groups, []*Hook{})
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], h)
+	}
+	return groups
+}
+
+// run runs the hooks of one priority concurrently, each under its own timeout
+func (lc *Lifecycle) run(ctx context.Context, hooks []*Hook) error {
+	m := errors.NewMultiError()
+	wg := sync.WaitGroup{}
+	for _, h := range hooks {
+		wg.Add(1)
+		go func(h *Hook) {
+			defer wg.Done()
+			if err := lc.call(ctx, h); err != nil {
+				if lc.OnError != nil {
+					lc.OnError(h, err)
+				}
+				m.Append(err)
+			}
+		}(h)
+	}
+	wg.Wait()
+	return m.ErrorOrNil()
+}
+
+func (lc *Lifecycle) call(ctx context.Context, h *Hook) (err error) {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_HOOK_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("lifecycle: %s panic: %v", h.Name, r)
+			}
+		}()
+		done <- h.Func(ctx)
+	}()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			err = fmt.Errorf("lifecycle: %s: %w", h.Name, err)
+		}
+	case <-ctx.Done():
+		err = fmt.Errorf("lifecycle: %s: %w", h.Name, ctx.Err())
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStopOrder(t *testing.T) {
+	lc := New()
+	var l sync.Mutex
+	order := make([]string, 0)
+	hook := func(name string, priority int) Hook {
+		return Hook{Name: name, Priority: priority, Func: func(ctx context.Context) error {
+			l.Lock()
+			order = append(order, name)
+			l.Unlock()
+			return nil
+		}}
+	}
+	lc.OnStop(hook("log", PRIORITY_SYNC_LOG))
+	lc.OnStop(hook("db", PRIORITY_CLOSE_DB))
+	remove := lc.OnStop(hook("removed", PRIORITY_CLOSE_DB))
+	lc.OnStop(hook("deregister", PRIORITY_DEREGISTER))
+	remove()
+
+	if err := lc.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"deregister", "db", "log"}
+	if len(order) != len(want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order = %v, want %v", order, want)
+		}
+	}
+}
+
+func TestStopCombinesFailures(t *testing.T) {
+	lc := New()
+	failed := errors.New("flush failed")
+	ran := false
+	lc.OnStop(Hook{Name: "producer", Priority: PRIORITY_FLUSH_PRODUCERS, Func: func(ctx context.Context) error {
+		return failed
+	}})
+	lc.OnStop(Hook{Name: "slow", Priority: PRIORITY_FLUSH_PRODUCERS, Timeout: 10 * time.Millisecond, Func: func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}})
+	lc.OnStop(Hook{Name: "panic", Priority: PRIORITY_FLUSH_PRODUCERS, Func: func(ctx context.Context) error {
+		panic("boom")
+	}})
+	lc.OnStop(Hook{Name: "db", Priority: PRIORITY_CLOSE_DB, Func: func(ctx context.Context) error {
+		ran = true
+		return nil
+	}})
+
+	err := lc.Stop(context.Background())
+	if !errors.Is(err, failed) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Stop error = %v", err)
+	}
+	if !ran {
+		t.Fatal("a failure aborted the stop")
+	}
+	if lc.Stop(context.Background()) != err {
+		t.Fatal("second Stop ran again")
+	}
+	select {
+	case <-lc.Stopped():
+	default:
+		t.Fatal("Stopped not closed")
+	}
+}
@@ -0,0 +1,76 @@
+package limiter
+
+import (
+	"golang.org/x/time/rate"
+	"math"
+	"sync"
+	"time"
+)
+
+// DEFAULT_SWEEP_INTERVAL is how often the idle keys are dropped
+const DEFAULT_SWEEP_INTERVAL = time.Minute
+
+// TokenBucket is a token bucket per key in memory, refilled at Rate tokens per second up to Burst
+type TokenBucket struct {
+	Rate  float64
+	Burst int
+
+	l         sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	lim  *rate.Limiter
+	seen time.Time
+}
+
+// NewTokenBucket limits each key to r per second, burst defaults to r rounded up
+func NewTokenBucket(r float64, burst int) *TokenBucket {
+	if burst <= 0 {
+		burst = int(math.Ceil(r))
+	}
+	return &TokenBucket{
+		Rate:      r,
+		Burst:     burst,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (t *TokenBucket) Allow(key string) (bool, time.Duration, error) {
+	now := time.Now()
+	t.l.Lock()
+	t.sweep(now)
+	b, ok := t.buckets[key]
+	if !ok {
+		b = &bucket{lim: rate.NewLimiter(rate.Limit(t.Rate), t.Burst)}
+		t.buckets[key] = b
+	}
+	b.seen = now
+	t.l.Unlock()
+
+	r := b.lim.ReserveN(now, 1)
+	if !r.OK() {
+		return false, 0, nil
+	}
+	if d := r.DelayFrom(now); d > 0 {
+		r.CancelAt(now)
+		return false, d, nil
+	}
+	return true, 0, nil
+}
+
+// sweep drops the buckets full again, a new one is the same, t.l is held
+func (t *TokenBucket) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < DEFAULT_SWEEP_INTERVAL {
+		return
+	}
+	t.lastSweep = now
+	refill := time.Duration(float64(t.Burst) / t.Rate * float64(time.Second))
+	for key, b := range t.buckets {
+		if now.Sub(b.seen) > refill {
+			delete(t.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package limiter
+
+import (
+	"sync"
+)
+
+// Concurrency limits the calls of each key in progress at once
+type Concurrency struct {
+	Max int
+
+	l       sync.Mutex
+	running map[string]int
+}
+
+func NewConcurrency(max int) *Concurrency {
+	return &Concurrency{
+		Max:     max,
+		running: make(map[string]int),
+	}
+}
+
+// Acquire takes a place of key, release gives it back, ok is false when Max are in progress
+func (c *Concurrency) Acquire(key string) (release func(), ok bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.running[key] >= c.Max {
+		return nil, false
+	}
+	c.running[key]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.l.Lock()
+			defer c.l.Unlock()
+			if c.running[key]--; c.running[key] <= 0 {
+				delete(c.running, key)
+			}
+		})
+	}, true
+}
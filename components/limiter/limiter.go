@@ -0,0 +1,11 @@
+package limiter
+
+import (
+	"time"
+)
+
+// Limiter limits the rate of each key, e.g. of each caller of a method
+type Limiter interface {
+	// Allow takes a token of key, when none is left it tells when to retry
+	Allow(key string) (ok bool, retryAfter time.Duration, err error)
+}
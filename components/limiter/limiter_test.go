@@ -0,0 +1,106 @@
+package limiter
+
+import (
+	"errors"
+	"github.com/gomodule/redigo/redis"
+	"github.com/joselee214/j7f/lib/gopkg.in/redsync.v1"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := NewTokenBucket(100, 2)
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := b.Allow("a"); !ok {
+			t.Fatalf("call %d of the burst rejected", i)
+		}
+	}
+	ok, after, err := b.Allow("a")
+	if ok || err != nil || after <= 0 || after > 10*time.Millisecond {
+		t.Fatalf("over the burst = %v %s %v", ok, after, err)
+	}
+	if ok, _, _ = b.Allow("b"); !ok {
+		t.Fatal("keys share their bucket")
+	}
+	time.Sleep(after + 5*time.Millisecond)
+	if ok, _, _ = b.Allow("a"); !ok {
+		t.Fatal("bucket not refilled")
+	}
+
+	if b = NewTokenBucket(2.5, 0); b.Burst != 3 {
+		t.Fatalf("default burst = %d", b.Burst)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	c := NewConcurrency(2)
+	r1, ok1 := c.Acquire("a")
+	_, ok2 := c.Acquire("a")
+	if !ok1 || !ok2 {
+		t.Fatal("places under Max rejected")
+	}
+	if _, ok := c.Acquire("a"); ok {
+		t.Fatal("place over Max accepted")
+	}
+	if _, ok := c.Acquire("b"); !ok {
+		t.Fatal("keys share their places")
+	}
+
+	r1()
+	r1()
+	if _, ok := c.Acquire("a"); !ok {
+		t.Fatal("place not released")
+	}
+	if _, ok := c.Acquire("a"); ok {
+		t.Fatal("double release freed two places")
+	}
+}
+
+// replyConn is a redis.Conn replying reply, or err, to every command
+type replyConn struct {
+	redis.Conn
+	reply interface{}
+	err   error
+	args  []interface{}
+}
+
+func (c *replyConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	c.args = args
+	return c.reply, c.err
+}
+
+func (c *replyConn) Close() error {
+	return nil
+}
+
+type connPool struct {
+	c redis.Conn
+}
+
+func (p connPool) Get() redis.Conn {
+	return p.c
+}
+
+var _ redsync.Pool = connPool{}
+
+func TestRedisBucketReplies(t *testing.T) {
+	conn := &replyConn{reply: []interface{}{int64(0), int64(250)}}
+	b := NewRedisBucket(connPool{conn}, "rl:", 4, 0)
+	ok, after, err := b.Allow("a")
+	if ok || after != 250*time.Millisecond || err != nil {
+		t.Fatalf("rejected = %v %s %v", ok, after, err)
+	}
+	if len(conn.args) < 3 || conn.args[2] != "rl:a" {
+		t.Fatalf("script args = %v", conn.args)
+	}
+
+	conn.reply = []interface{}{int64(1), int64(0)}
+	if ok, _, _ = b.Allow("a"); !ok {
+		t.Fatal("allowed reply rejected")
+	}
+
+	conn.reply, conn.err = nil, errors.New("connection refused")
+	if _, _, err = b.Allow("a"); err == nil {
+		t.Fatal("redis error hidden")
+	}
+}
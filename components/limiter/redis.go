@@ -0,0 +1,69 @@
+package limiter
+
+import (
+	"errors"
+	"github.com/gomodule/redigo/redis"
+	"github.com/joselee214/j7f/lib/gopkg.in/redsync.v1"
+	"math"
+	"time"
+)
+
+// tokenBucketScript takes a token of the bucket KEYS[1], refilled at ARGV[1] per second up to ARGV[2],
+// on the clock of redis, it returns {1, 0} when allowed, else {0, the milliseconds to wait}
+var tokenBucketScript = redis.NewScript(1, `
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(b[1]) or burst
+local ts = tonumber(b[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return {allowed, wait}
+`)
+
+// RedisBucket is a token bucket per key shared by the nodes of a cluster, through the same pools
+// configured for lock.RedisLockConfig. A key expires once full again
+type RedisBucket struct {
+	Rate  float64
+	Burst int
+
+	pool   redsync.Pool
+	prefix string
+}
+
+// NewRedisBucket limits each key to r per second over all the nodes, burst defaults to r rounded up
+func NewRedisBucket(pool redsync.Pool, prefix string, r float64, burst int) *RedisBucket {
+	if burst <= 0 {
+		burst = int(math.Ceil(r))
+	}
+	return &RedisBucket{
+		Rate:   r,
+		Burst:  burst,
+		pool:   pool,
+		prefix: prefix,
+	}
+}
+
+func (b *RedisBucket) Allow(key string) (bool, time.Duration, error) {
+	conn := b.pool.Get()
+	defer conn.Close()
+	res, err := redis.Int64s(tokenBucketScript.Do(conn, b.prefix+key, b.Rate, b.Burst))
+	if err != nil {
+		return false, 0, err
+	}
+	if len(res) != 2 {
+		return false, 0, errors.New("limiter: unexpected reply of the token bucket script")
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
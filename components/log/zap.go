@@ -2,10 +2,13 @@ package log
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/joselee214/j7f/components/lifecycle"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc/metadata"
+	"syscall"
 	"time"
 )
 
@@ -83,6 +86,8 @@ const (
 
 type Logger struct {
 	*zap.Logger
+	// Level is the level of the logger, it can be changed at runtime, e.g. by the admin server
+	Level zap.AtomicLevel
 }
 
 func NewZap(logCfg *Config) (*Logger, error) {
@@ -154,8 +159,31 @@ func NewZap(logCfg *Config) (*Logger, error) {
 	}
 
 	l, err := zapCfg.Build(zap.AddStacktrace(zap.ErrorLevel))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Logger{Logger: l, Level: ll}, nil
+}
 
-	return &Logger{l}, err
+// StopHook flushes the logger last, see ApplicationManager.Add
+func (l *Logger) StopHook() lifecycle.Hook {
+	return lifecycle.Hook{
+		Name:     "log",
+		Priority: lifecycle.PRIORITY_SYNC_LOG,
+		Func: func(ctx context.Context) error {
+			return sync(l.Logger)
+		},
+	}
+}
+
+// sync flushes l, the errors of the consoles which can not be synced are ignored
+func sync(l *zap.Logger) error {
+	err := l.Sync()
+	if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOTTY) {
+		return nil
+	}
+	return err
 }
 
 func CSTTimeEncoder(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
@@ -164,6 +192,7 @@ func CSTTimeEncoder(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
 
 func (l *Logger) ResetLogger(logger *Logger) {
 	l.Logger = logger.Logger
+	l.Level = logger.Level
 }
 
 func (l *Logger) Write(p []byte) (n int, err error) {
@@ -8,6 +8,8 @@ import (
 	"time"
 )
 
+// Config of the producers and consumers, config.Configer.Bind applies its default tags
+// and checks its min and max ones
 type Config struct {
 	DialTimeout time.Duration `json:"dial_timeout" default:"1s"`
 
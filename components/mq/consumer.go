@@ -1,9 +1,17 @@
 package mq
 
-import "github.com/nsqio/go-nsq"
+import (
+	"context"
+	"github.com/joselee214/j7f/components/lifecycle"
+	"github.com/nsqio/go-nsq"
+	"sync"
+)
 
 type Consumer struct {
 	*nsq.Consumer
+
+	once sync.Once
+	name string
 }
 
 type logger interface {
@@ -19,7 +27,27 @@ func NewConsumer(topic string, channel string, cfg *Config) (*Consumer, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Consumer{consumer}, nil
+	return &Consumer{Consumer: consumer, name: "mq.consumer." + topic + "." + channel}, nil
+}
+
+// StopHook stops the consumer before the producers and the db are closed, see ApplicationManager.Add
+func (c *Consumer) StopHook() lifecycle.Hook {
+	return lifecycle.Hook{
+		Name:     c.name,
+		Priority: lifecycle.PRIORITY_STOP_CONSUMERS,
+		Func:     c.Shutdown,
+	}
+}
+
+// Shutdown stops the consumer and waits for the in-flight messages to be handled, or ctx
+func (c *Consumer) Shutdown(ctx context.Context) error {
+	c.once.Do(c.Consumer.Stop)
+	select {
+	case <-c.Consumer.StopChan:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // ConnectToNSQLookupd adds an nsqlookupd address to the list for this Consumer instance.
@@ -1,7 +1,10 @@
 package mq
 
 import (
+	"context"
 	"errors"
+	"github.com/joselee214/j7f/components/health"
+	"github.com/joselee214/j7f/components/lifecycle"
 	"github.com/nsqio/go-nsq"
 	"math/rand"
 	"net"
@@ -26,6 +29,9 @@ type Producer struct {
 	connections map[string]*producerPool
 
 	exitChan chan int
+
+	// Name names the stop hook and the health check, "mq.producer" by default
+	Name string
 }
 
 type producerPool struct {
@@ -47,14 +53,36 @@ type peerInfo struct {
 }
 
 func NewProducer(cfg *Config) (*Producer, error) {
-	return &Producer{
+	p := &Producer{
 		mtx:         sync.RWMutex{},
 		addrMtx:     sync.RWMutex{},
 		connMtx:     sync.Mutex{},
 		config:      cfg,
 		connections: make(map[string]*producerPool, 0),
 		exitChan:    make(chan int, 1),
-	}, nil
+		Name:        "mq.producer",
+	}
+	return p, nil
+}
+
+// StopHook flushes the producer after the consumers stopped, see ApplicationManager.Add
+func (p *Producer) StopHook() lifecycle.Hook {
+	return lifecycle.Hook{
+		Name:     p.Name,
+		Priority: lifecycle.PRIORITY_FLUSH_PRODUCERS,
+		Func: func(ctx context.Context) error {
+			p.Close()
+			return nil
+		},
+	}
+}
+
+// HealthChecks pings the nsqd of the producer
+func (p *Producer) HealthChecks() []*health.Check {
+	return []*health.Check{{
+		Name:    p.Name,
+		Checker: health.CheckerFunc(p.ping),
+	}}
 }
 
 func (p *Producer) ConnectToNSQLookupd(addr string, poolCap int) error {
@@ -189,9 +217,15 @@ exit:
 	p.wg.Done()
 }
 
+// Close stops the pooled producers, they flush the pending publishes first
 func (p *Producer) Close() {
 	p.mtx.Lock()
 	defer p.mtx.Unlock()
+	select {
+	case <-p.exitChan:
+		return
+	default:
+	}
 	for _, pool := range p.connections {
 		close(pool.conns)
 		pool.closed = true
@@ -229,6 +263,19 @@ retry:
 	return nil
 }
 
+// ping pings a pooled producer, its nsqd
+func (p *Producer) ping(ctx context.Context) error {
+	addr, producer, err := p.getProducerConn()
+	if err != nil {
+		return err
+	}
+	err = producer.Ping()
+	if e := p.putProducerConn(addr, producer); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
 func (p *Producer) getProducerConn() (string, *nsq.Producer, error) {
 	if len(p.nsqdTCPAddrs) == 0 || len(p.connections) == 0 {
 		return "", nil, errors.New("producer not exist")
@@ -0,0 +1,346 @@
+package reporter
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/joselee214/j7f/components/errors"
+	"google.golang.org/grpc/metadata"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_INTERVAL   = 60
+	DEFAULT_QUEUE_SIZE = 1024
+	MAX_FINGERPRINTS   = 10000
+)
+
+type Config struct {
+	Service string `json:"service"`
+	Version string `json:"version"`
+	// Interval in seconds, repeats of a fingerprint within it are counted instead of sent
+	Interval  int `json:"interval"`
+	QueueSize int `json:"queue_size"`
+}
+
+// Report is an error grouped by its stack fingerprint
+type Report struct {
+	Fingerprint string            `json:"fingerprint"`
+	Message     string            `json:"message"`
+	Code        int64             `json:"code"`
+	Panic       bool              `json:"panic"`
+	Stack       []string          `json:"stack"`
+	TraceId     string            `json:"trace_id"`
+	Service     string            `json:"service"`
+	Version     string            `json:"version"`
+	Metadata    map[string]string `json:"metadata"`
+	// Count is the number of occurrences since the last report of the fingerprint
+	Count int       `json:"count"`
+	Time  time.Time `json:"time"`
+}
+
+// Sink delivers the reports
+type Sink interface {
+	Send(r *Report) error
+}
+
+type occurrence struct {
+	last       time.Time
+	suppressed int
+	// rep is the last report sent, resent with the suppressed count
+	rep *Report
+}
+
+// Reporter sends the errors to its sinks in the background, a fingerprint is sent
+// at most once per interval, the repeats within it are sent after it with their count
+type Reporter struct {
+	cfg   *Config
+	sinks []Sink
+
+	l      sync.Mutex
+	seen   map[string]*occurrence
+	closed bool
+
+	ch   chan *Report
+	stop chan struct{}
+	done chan struct{}
+
+	// OnError receives the sink errors
+	OnError func(err error)
+}
+
+var (
+	defaultLock     sync.RWMutex
+	defaultReporter *Reporter
+)
+
+func New(cfg *Config, sinks ...Sink) *Reporter {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DEFAULT_INTERVAL
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DEFAULT_QUEUE_SIZE
+	}
+	r := &Reporter{
+		cfg:   cfg,
+		sinks: sinks,
+		seen:  make(map[string]*occurrence),
+		ch:    make(chan *Report, cfg.QueueSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go r.loop()
+	go r.flushLoop()
+	return r
+}
+
+// SetDefault sets the reporter used by the grpc error interceptors and the http Recovery middleware
+func SetDefault(r *Reporter) {
+	defaultLock.Lock()
+	defaultReporter = r
+	defaultLock.Unlock()
+}
+
+func Default() *Reporter {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	return defaultReporter
+}
+
+// ReportError sends err to the default reporter, if any
+func ReportError(ctx context.Context, err error, md map[string]string) {
+	if r := Default(); r != nil {
+		r.Report(ctx, err, md)
+	}
+}
+
+// ReportPanic sends a recovered panic to the default reporter, if any
+func ReportPanic(ctx context.Context, recovered interface{}, md map[string]string) {
+	if r := Default(); r != nil {
+		r.report(ctx, panicReport(recovered, 4), md)
+	}
+}
+
+func (r *Reporter) Report(ctx context.Context, err error, md map[string]string) {
+	if err == nil {
+		return
+	}
+	r.report(ctx, errorReport(err), md)
+}
+
+func (r *Reporter) ReportPanic(ctx context.Context, recovered interface{}, md map[string]string) {
+	r.report(ctx, panicReport(recovered, 4), md)
+}
+
+// Close stops the reporter after the queued reports and the suppressed counts are sent,
+// the later reports are dropped
+func (r *Reporter) Close() {
+	r.l.Lock()
+	dropped := 0
+	if !r.closed {
+		dropped = r.flush(time.Now(), true)
+		r.closed = true
+		close(r.stop)
+		close(r.ch)
+	}
+	r.l.Unlock()
+	r.dropped(dropped)
+	<-r.done
+}
+
+func (r *Reporter) report(ctx context.Context, rep *Report, md map[string]string) {
+	now := time.Now()
+
+	r.l.Lock()
+	if r.closed {
+		r.l.Unlock()
+		return
+	}
+	o, ok := r.seen[rep.Fingerprint]
+	if ok && now.Sub(o.last) < time.Duration(r.cfg.Interval)*time.Second {
+		o.suppressed++
+		r.l.Unlock()
+		return
+	}
+	if !ok {
+		if len(r.seen) >= MAX_FINGERPRINTS {
+			r.evict(now)
+		}
+		o = &occurrence{}
+		r.seen[rep.Fingerprint] = o
+	}
+	rep.Count = o.suppressed + 1
+	o.suppressed = 0
+	o.last = now
+
+	if rep.TraceId == "" {
+		rep.TraceId = traceIdOf(ctx)
+	}
+	if rep.TraceId == "" {
+		rep.TraceId = md["trace_id"]
+	}
+	rep.Service = r.cfg.Service
+	rep.Version = r.cfg.Version
+	rep.Metadata = md
+	rep.Time = now
+
+	o.rep = rep
+
+	dropped := 0
+	if !r.enqueue(rep) {
+		dropped++
+	}
+	r.l.Unlock()
+	r.dropped(dropped)
+}
+
+// enqueue queues rep unless the queue is full, r.l is held so Close does not close ch meanwhile
+func (r *Reporter) enqueue(rep *Report) bool {
+	select {
+	case r.ch <- rep:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *Reporter) dropped(n int) {
+	if n > 0 {
+		r.error(fmt.Errorf("reporter: queue is full, drop %d reports", n))
+	}
+}
+
+// flush sends the suppressed counts of the fingerprints last sent an interval ago, or all of them,
+// and returns the number of reports dropped, r.l is held
+func (r *Reporter) flush(now time.Time, all bool) int {
+	dropped := 0
+	for _, o := range r.seen {
+		if o.suppressed == 0 || (!all && now.Sub(o.last) < time.Duration(r.cfg.Interval)*time.Second) {
+			continue
+		}
+		rep := *o.rep
+		rep.Count = o.suppressed
+		rep.Time = now
+		o.suppressed = 0
+		o.last = now
+		if !r.enqueue(&rep) {
+			dropped++
+		}
+	}
+	return dropped
+}
+
+func (r *Reporter) flushLoop() {
+	t := time.NewTicker(time.Duration(r.cfg.Interval) * time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-r.stop:
+			return
+		}
+		r.l.Lock()
+		dropped := 0
+		if !r.closed {
+			dropped = r.flush(time.Now(), false)
+		}
+		r.l.Unlock()
+		r.dropped(dropped)
+	}
+}
+
+// evict drops the fingerprints not seen within the interval and without suppressed counts
+func (r *Reporter) evict(now time.Time) {
+	for k, o := range r.seen {
+		if o.suppressed == 0 && now.Sub(o.last) >= time.Duration(r.cfg.Interval)*time.Second {
+			delete(r.seen, k)
+		}
+	}
+}
+
+func (r *Reporter) loop() {
+	defer close(r.done)
+	for rep := range r.ch {
+		for _, s := range r.sinks {
+			if err := s.Send(rep); err != nil {
+				r.error(err)
+			}
+		}
+	}
+}
+
+func (r *Reporter) error(err error) {
+	if r.OnError != nil {
+		r.OnError(err)
+	}
+}
+
+type stackTracer interface {
+	StackTrace() errors.StackTrace
+}
+
+func errorReport(err error) *Report {
+	rep := &Report{
+		Message: err.Error(),
+		Code:    errors.Code(err),
+	}
+	var e *errors.Error
+	if errors.As(err, &e) {
+		rep.TraceId = e.TraceId()
+	}
+	var st stackTracer
+	if errors.As(err, &st) {
+		for _, f := range st.StackTrace() {
+			rep.Stack = append(rep.Stack, fmt.Sprintf("%+v", f))
+		}
+		rep.Fingerprint = fingerprint(rep.Stack)
+	} else {
+		rep.Fingerprint = fingerprint([]string{fmt.Sprintf("%T", err), rep.Message})
+	}
+	return rep
+}
+
+func panicReport(recovered interface{}, skip int) *Report {
+	rep := &Report{
+		Message: fmt.Sprintf("%v", recovered),
+		Panic:   true,
+	}
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(skip, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		f, more := frames.Next()
+		rep.Stack = append(rep.Stack, fmt.Sprintf("%s\n\t%s:%d", f.Function, f.File, f.Line))
+		if !more {
+			break
+		}
+	}
+	rep.Fingerprint = fingerprint(rep.Stack)
+	return rep
+}
+
+func fingerprint(parts []string) string {
+	h := sha1.New()
+	for _, p := range parts {
+		_, _ = h.Write([]byte(p))
+		_, _ = h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func traceIdOf(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if traceIds, ok := md["trace_id"]; ok && len(traceIds) > 0 {
+		return traceIds[0]
+	}
+	return ""
+}
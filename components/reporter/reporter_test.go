@@ -0,0 +1,99 @@
+package reporter
+
+import (
+	"context"
+	"github.com/joselee214/j7f/components/errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memorySink struct {
+	l       sync.Mutex
+	reports []*Report
+}
+
+func (s *memorySink) Send(r *Report) error {
+	s.l.Lock()
+	s.reports = append(s.reports, r)
+	s.l.Unlock()
+	return nil
+}
+
+func (s *memorySink) Len() int {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return len(s.reports)
+}
+
+func TestReportSuppressesRepeats(t *testing.T) {
+	sink := &memorySink{}
+	r := New(&Config{Service: "user"}, sink)
+	err := errors.New("boom")
+	for i := 0; i < 3; i++ {
+		r.Report(context.Background(), err, map[string]string{"trace_id": "t1"})
+	}
+	r.Close()
+
+	//Close 发送被抑制的次数
+	if sink.Len() != 2 {
+		t.Fatalf("sent %d reports, want 2", sink.Len())
+	}
+	rep := sink.reports[0]
+	if rep.Service != "user" || rep.TraceId != "t1" || rep.Count != 1 || len(rep.Stack) == 0 {
+		t.Fatalf("report = %+v", rep)
+	}
+	if rep = sink.reports[1]; rep.Fingerprint != sink.reports[0].Fingerprint || rep.Count != 2 {
+		t.Fatalf("suppressed report = %+v", rep)
+	}
+}
+
+func TestReportFlushesSuppressedCounts(t *testing.T) {
+	sink := &memorySink{}
+	r := New(&Config{Interval: 1}, sink)
+	defer r.Close()
+	err := errors.New("boom")
+	for i := 0; i < 4; i++ {
+		r.Report(context.Background(), err, nil)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for sink.Len() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("suppressed count not flushed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sink.l.Lock()
+	defer sink.l.Unlock()
+	if rep := sink.reports[1]; rep.Count != 3 {
+		t.Fatalf("flushed count = %d, want 3", rep.Count)
+	}
+}
+
+func TestReportAfterClose(t *testing.T) {
+	sink := &memorySink{}
+	r := New(&Config{}, sink)
+	r.Close()
+	r.Close()
+	r.Report(context.Background(), errors.New("late"), nil)
+	r.ReportPanic(context.Background(), "late", nil)
+	if sink.Len() != 0 {
+		t.Fatalf("sent %d reports after close", sink.Len())
+	}
+}
+
+func TestReportDuringClose(t *testing.T) {
+	r := New(&Config{}, &memorySink{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.Report(context.Background(), errors.Errorf("error %d %d", i, j), nil)
+			}
+		}(i)
+	}
+	r.Close()
+	wg.Wait()
+}
@@ -0,0 +1,94 @@
+package reporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const DEFAULT_WEBHOOK_TIMEOUT = 3 * time.Second
+
+// FileSink appends the reports to a file, one json per line
+type FileSink struct {
+	l sync.Mutex
+	f *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{f: f}, nil
+}
+
+func (s *FileSink) Send(r *Report) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	_, err = s.f.Write(append(data, '\n'))
+	return err
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+// WebhookSink posts the reports as json to an url
+type WebhookSink struct {
+	url    string
+	client *http.Client
+	header http.Header
+}
+
+func NewWebhookSink(url string, timeout time.Duration) *WebhookSink {
+	if timeout <= 0 {
+		timeout = DEFAULT_WEBHOOK_TIMEOUT
+	}
+	return &WebhookSink{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+		header: make(http.Header),
+	}
+}
+
+// SetHeader adds a header to every request, e.g. a token
+func (s *WebhookSink) SetHeader(key, value string) {
+	s.header.Set(key, value)
+}
+
+func (s *WebhookSink) Send(r *Report) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for k, v := range s.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	//读完响应才能复用连接
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("reporter: webhook %s returned %d", s.url, resp.StatusCode)
+	}
+	return nil
+}
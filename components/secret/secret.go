@@ -0,0 +1,18 @@
+// Package secret names the values kept out of the config dumps, the logs and the error reports
+package secret
+
+import "strings"
+
+// Keys are the parts of the names whose values are secrets, matched case-insensitively
+var Keys = []string{"password", "passwd", "secret", "token", "credential", "private", "apikey", "api_key", "accesskey", "access_key"}
+
+// IsKey tells if the value named key is a secret
+func IsKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range Keys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"github.com/joselee214/j7f/components/health"
+	"github.com/joselee214/j7f/components/lifecycle"
 	"go.etcd.io/etcd/clientv3"
+	"sync"
 	"time"
 )
 
@@ -43,6 +46,12 @@ type EtcdCli struct {
 	watcher clientv3.Watcher
 	hbch    <-chan *clientv3.LeaseKeepAliveResponse
 	c       *clientv3.Client
+
+	// Name names the stop hook and the health check, "etcd" by default, e.g. "etcd.remote" for a second client
+	Name string
+
+	closeOnce sync.Once
+	closeErr  error
 }
 
 func NewEtcd(c *Config) (*EtcdCli, error) {
@@ -61,7 +70,38 @@ func NewEtcd(c *Config) (*EtcdCli, error) {
 		return nil, err
 	}
 
-	return &EtcdCli{c: cli}, nil
+	return &EtcdCli{c: cli, Name: "etcd"}, nil
+}
+
+// StopHook closes the client after the deregistration, with the other connections
+func (e *EtcdCli) StopHook() lifecycle.Hook {
+	return lifecycle.Hook{
+		Name:     e.Name,
+		Priority: lifecycle.PRIORITY_CLOSE_DB,
+		Func: func(ctx context.Context) error {
+			return e.Close()
+		},
+	}
+}
+
+// HealthChecks reads etcd, not critical: the registration is lost without etcd, but the service still serves
+func (e *EtcdCli) HealthChecks() []*health.Check {
+	return []*health.Check{{
+		Name: e.Name,
+		Checker: health.CheckerFunc(func(ctx context.Context) error {
+			_, err := e.c.Get(ctx, "health", clientv3.WithCountOnly())
+			return err
+		}),
+	}}
+}
+
+// Close stops the lease and the watchers and closes the client, once
+func (e *EtcdCli) Close() error {
+	e.closeOnce.Do(func() {
+		e.close()
+		e.closeErr = e.c.Close()
+	})
+	return e.closeErr
 }
 
 func (e *EtcdCli) Register(ctx context.Context, s *Service) error {
@@ -126,6 +166,11 @@ func (e *EtcdCli) DeRegister(ctx context.Context, s *Service) error {
 	return nil
 }
 
+// Client is the etcd client, e.g. for a config.EtcdSource
+func (e *EtcdCli) Client() *clientv3.Client {
+	return e.c
+}
+
 func (e *EtcdCli) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
 	return e.c.Watch(ctx, key, opts...)
 }
@@ -7,11 +7,11 @@ require (
 	github.com/coreos/etcd v3.3.18+incompatible
 	github.com/gin-gonic/gin v1.5.0
 	github.com/go-sql-driver/mysql v1.5.0
-	github.com/gogf/greuse v0.0.0-00010101000000-000000000000
 	github.com/golang/protobuf v1.3.2
 	github.com/gomodule/redigo v2.0.0+incompatible
 	github.com/google/uuid v1.1.1 // indirect
 	github.com/grpc-ecosystem/go-grpc-middleware v1.0.0
+	github.com/mitchellh/mapstructure v1.1.2
 	github.com/nsqio/go-nsq v1.0.8
 	github.com/rs/xid v1.2.1
 	github.com/spf13/pflag v1.0.3
@@ -19,11 +19,11 @@ require (
 	go.etcd.io/etcd v3.3.18+incompatible
 	go.uber.org/ratelimit v0.1.0
 	go.uber.org/zap v1.10.0
+	golang.org/x/sys v0.0.0-20190813064441-fde4db37ae7a
+	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4
 	google.golang.org/genproto v0.0.0-20180817151627-c66870c02cf8
 	google.golang.org/grpc v1.21.0
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df
-	sigs.k8s.io/yaml v1.2.0 // indirect
+	sigs.k8s.io/yaml v1.2.0
 )
-
-replace github.com/gogf/greuse => /Users/joselee/go/src/github.com/gogf/greuse